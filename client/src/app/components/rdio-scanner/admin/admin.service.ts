@@ -156,6 +156,7 @@ export interface System {
     label?: string;
     led?: string | null;
     order?: number | null;
+    remoteId?: string | null;
     talkgroups?: Talkgroup[];
     units?: Unit[];
 }
@@ -173,6 +174,7 @@ export interface Talkgroup {
     led?: string | null;
     name?: string;
     order?: number;
+    remoteId?: number | null;
     tagId?: number;
 }
 
@@ -393,7 +395,7 @@ export class RdioScannerAdminService implements OnDestroy {
             _id: [apiKey?._id],
             disabled: [apiKey?.disabled],
             ident: [apiKey?.ident, Validators.required],
-            key: [apiKey?.key, [Validators.required, this.validateApiKey()]],
+            key: [apiKey?.key, apiKey?._id === undefined ? [Validators.required, this.validateApiKey()] : this.validateApiKey()],
             order: [apiKey?.order],
             systems: [apiKey?.systems, Validators.required],
         });
@@ -463,6 +465,7 @@ export class RdioScannerAdminService implements OnDestroy {
             label: [system?.label, Validators.required],
             led: [system?.led],
             order: [system?.order],
+            remoteId: [system?.remoteId],
             talkgroups: this.ngFormBuilder.array(system?.talkgroups?.map((talkgroup) => this.newTalkgroupForm(talkgroup)) || []),
             units: this.ngFormBuilder.array(system?.units?.map((unit) => this.newUnitForm(unit)) || []),
         });
@@ -477,6 +480,7 @@ export class RdioScannerAdminService implements OnDestroy {
             led: [talkgroup?.led],
             name: [talkgroup?.name, Validators.required],
             order: [talkgroup?.order],
+            remoteId: [talkgroup?.remoteId, Validators.min(1)],
             tagId: [talkgroup?.tagId, [Validators.required, this.validateTag()]],
         });
     }
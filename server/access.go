@@ -24,6 +24,8 @@ import (
 	"time"
 )
 
+const AccessCodeHeader = "X-Access-Code"
+
 type Access struct {
 	Id         any    `json:"_id"`
 	Code       string `json:"code"`
@@ -197,6 +199,19 @@ func (accesses *Accesses) GetAccess(code string) (access *Access, ok bool) {
 	return nil, false
 }
 
+func (accesses *Accesses) GetAccessByIdent(ident string) (access *Access, ok bool) {
+	accesses.mutex.Lock()
+	defer accesses.mutex.Unlock()
+
+	for _, access := range accesses.List {
+		if access.Ident == ident && !access.HasExpired() {
+			return access, true
+		}
+	}
+
+	return nil, false
+}
+
 func (accesses *Accesses) IsRestricted() bool {
 	accesses.mutex.Lock()
 	defer accesses.mutex.Unlock()
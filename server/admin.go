@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +32,11 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	adminListenersInterval  = 10 * time.Second
+	adminListenersWriteWait = 10 * time.Second
+)
+
 type Admin struct {
 	Attempts         AdminLoginAttempts
 	AttemptsMax      uint
@@ -41,6 +47,8 @@ type Admin struct {
 	Register         chan *websocket.Conn
 	Tokens           []string
 	Unregister       chan *websocket.Conn
+	listenersConns   map[*websocket.Conn]bool
+	listenersMutex   sync.Mutex
 	mutex            sync.Mutex
 	running          bool
 }
@@ -63,10 +71,251 @@ func NewAdmin(controller *Controller) *Admin {
 		Register:         make(chan *websocket.Conn),
 		Tokens:           []string{},
 		Unregister:       make(chan *websocket.Conn),
+		listenersConns:   make(map[*websocket.Conn]bool),
+		listenersMutex:   sync.Mutex{},
 		mutex:            sync.Mutex{},
 	}
 }
 
+func (admin *Admin) ApikeyAddHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.apikeyaddhandler.post: %s", err.Error()))
+		}
+
+		if !admin.IsAuthorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		m := map[string]any{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		delete(m, "_id")
+
+		key := uuid.New().String()
+
+		apikey := (&Apikey{}).FromMap(m)
+		apikey.Key = HashApikey(key)
+
+		if apikey.Systems == nil {
+			apikey.Systems = "*"
+		}
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		admin.Controller.Apikeys.Add(apikey)
+
+		if err = admin.Controller.Apikeys.Write(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		if err = admin.Controller.Apikeys.Read(admin.Controller.Database); err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		admin.BroadcastConfig()
+
+		b, err := json.Marshal(map[string]any{"ident": apikey.Ident, "key": key, "signingSecret": GetSigningSecret(admin.Controller.Options.secret, apikey.Key)})
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api key added for ident %s", apikey.Ident))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// ApikeySecretHandler returns the signing secret of an existing api key. With rotate, the key is
+// replaced by a new one, which also changes its signing secret, and both are returned once.
+func (admin *Admin) ApikeySecretHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var key string
+
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.apikeysecrethandler.post: %s", err.Error()))
+		}
+
+		if !admin.IsAuthorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		m := map[string]any{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		id, ok := m["_id"].(float64)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		admin.mutex.Lock()
+		defer admin.mutex.Unlock()
+
+		apikey, ok := admin.Controller.Apikeys.GetApikeyById(uint(id))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if m["rotate"] == true {
+			key = uuid.New().String()
+
+			admin.Controller.Apikeys.mutex.Lock()
+			apikey.Key = HashApikey(key)
+			admin.Controller.Apikeys.mutex.Unlock()
+
+			if err = admin.Controller.Apikeys.Write(admin.Controller.Database); err != nil {
+				logError(err)
+				w.WriteHeader(http.StatusExpectationFailed)
+				return
+			}
+
+			if err = admin.Controller.Apikeys.Read(admin.Controller.Database); err != nil {
+				logError(err)
+				w.WriteHeader(http.StatusExpectationFailed)
+				return
+			}
+
+			admin.BroadcastConfig()
+		}
+
+		res := map[string]any{"ident": apikey.Ident, "signingSecret": GetSigningSecret(admin.Controller.Options.secret, apikey.Key)}
+		if len(key) > 0 {
+			res["key"] = key
+		}
+
+		b, err := json.Marshal(res)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+		if len(key) > 0 {
+			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api key rotated for ident %s", apikey.Ident))
+		} else {
+			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api key signing secret revealed for ident %s", apikey.Ident))
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) BroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	if !admin.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var (
+			count   int
+			idents  = []string{}
+			message string
+			systems = []uint{}
+		)
+
+		logError := func(err error) {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.broadcasthandler.post: %s", err.Error()))
+		}
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch v := m["message"].(type) {
+		case string:
+			message = strings.TrimSpace(v)
+		}
+
+		switch v := m["idents"].(type) {
+		case []any:
+			for _, f := range v {
+				switch ident := f.(type) {
+				case string:
+					idents = append(idents, ident)
+				}
+			}
+		}
+
+		switch v := m["systems"].(type) {
+		case []any:
+			for _, f := range v {
+				switch id := f.(type) {
+				case float64:
+					systems = append(systems, uint(id))
+				}
+			}
+		}
+
+		if m["motd"] == true {
+			admin.mutex.Lock()
+
+			admin.Controller.Options.SetMotd(message)
+
+			err := admin.Controller.Options.Write(admin.Controller.Database)
+
+			admin.mutex.Unlock()
+
+			if err != nil {
+				logError(err)
+				w.WriteHeader(http.StatusExpectationFailed)
+				return
+			}
+
+			go admin.BroadcastConfig()
+
+		} else if len(message) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(message) > 0 {
+			count = admin.Controller.Clients.EmitServerMessage(message, idents, systems)
+
+			admin.Controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("server message sent to %d listeners: %s", count, message))
+		}
+
+		if b, err := json.Marshal(map[string]any{"count": count}); err == nil {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusExpectationFailed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (admin *Admin) BroadcastConfig() {
 	if b, err := json.Marshal(admin.GetConfig()); err == nil {
 		for conn := range admin.Conns {
@@ -75,6 +324,33 @@ func (admin *Admin) BroadcastConfig() {
 	}
 }
 
+func (admin *Admin) BroadcastListener(event string, client *Client) {
+	admin.listenersMutex.Lock()
+	defer admin.listenersMutex.Unlock()
+
+	if len(admin.listenersConns) == 0 {
+		return
+	}
+
+	var listener map[string]any
+
+	if event == "disconnected" {
+		listener = map[string]any{"id": client.Id}
+	} else {
+		listener = client.GetStatus()
+	}
+
+	if b, err := json.Marshal(map[string]any{"event": event, "listener": listener}); err == nil {
+		for conn := range admin.listenersConns {
+			conn.SetWriteDeadline(time.Now().Add(adminListenersWriteWait))
+			if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				delete(admin.listenersConns, conn)
+				conn.Close()
+			}
+		}
+	}
+}
+
 func (admin *Admin) ChangePassword(currentPassword any, newPassword string) error {
 	var (
 		err  error
@@ -114,9 +390,7 @@ func (admin *Admin) ChangePassword(currentPassword any, newPassword string) erro
 
 func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if strings.EqualFold(r.Header.Get("upgrade"), "websocket") {
-		upgrader := websocket.Upgrader{}
-
-		conn, err := upgrader.Upgrade(w, r, nil)
+		conn, err := admin.Controller.Config.NewWebsocketUpgrader().Upgrade(w, r, nil)
 		if err != nil {
 			return
 		}
@@ -147,8 +421,7 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.confighandler.put: %s", err.Error()))
 		}
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
+		if !admin.IsAuthorized(r) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -169,6 +442,7 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 			defer admin.mutex.Unlock()
 
 			admin.Controller.Dirwatches.Stop()
+			admin.Controller.Upstreams.Stop()
 
 			switch v := m["access"].(type) {
 			case []any:
@@ -184,6 +458,20 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
+			switch v := m["alerts"].(type) {
+			case []any:
+				admin.Controller.Alerts.FromMap(v)
+				err = admin.Controller.Alerts.Write(admin.Controller.Database)
+				if err != nil {
+					logError(err)
+				} else {
+					err = admin.Controller.Alerts.Read(admin.Controller.Database)
+					if err != nil {
+						logError(err)
+					}
+				}
+			}
+
 			switch v := m["apiKeys"].(type) {
 			case []any:
 				admin.Controller.Apikeys.FromMap(v)
@@ -277,8 +565,37 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
+			switch v := m["toneSets"].(type) {
+			case []any:
+				admin.Controller.ToneSets.FromMap(v)
+				err = admin.Controller.ToneSets.Write(admin.Controller.Database)
+				if err != nil {
+					logError(err)
+				} else {
+					err = admin.Controller.ToneSets.Read(admin.Controller.Database)
+					if err != nil {
+						logError(err)
+					}
+				}
+			}
+
+			switch v := m["upstreams"].(type) {
+			case []any:
+				admin.Controller.Upstreams.FromMap(v)
+				err = admin.Controller.Upstreams.Write(admin.Controller.Database)
+				if err != nil {
+					logError(err)
+				} else {
+					err = admin.Controller.Upstreams.Read(admin.Controller.Database)
+					if err != nil {
+						logError(err)
+					}
+				}
+			}
+
 			admin.Controller.EmitConfig()
 			admin.Controller.Dirwatches.Start(admin.Controller)
+			admin.Controller.Upstreams.Start(admin.Controller)
 
 			admin.SendConfig(w)
 
@@ -290,6 +607,109 @@ func (admin *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (admin *Admin) DownstreamQueueHandler(w http.ResponseWriter, r *http.Request) {
+	logError := func(err error) {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.downstreamqueuehandler: %s", err.Error()))
+	}
+
+	if !admin.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var (
+			after uint
+			dead  any
+			limit uint
+		)
+
+		switch r.URL.Query().Get("dead") {
+		case "true":
+			dead = true
+		case "false":
+			dead = false
+		}
+
+		// entries are paged by passing the last _id received as after
+		if v, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64); err == nil {
+			after = uint(v)
+		}
+
+		if v, err := strconv.ParseUint(r.URL.Query().Get("limit"), 10, 64); err == nil {
+			limit = uint(v)
+		}
+
+		entries, err := admin.Controller.DownstreamQueue.List(dead, after, limit)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		b, err := json.Marshal(entries)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+	case http.MethodPost:
+		m := map[string]any{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch m["action"] {
+		case "purge":
+			err = admin.Controller.DownstreamQueue.Purge()
+		case "retry":
+			err = admin.Controller.DownstreamQueue.RetryAll()
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) DownstreamsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !admin.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(admin.Controller.Downstreams.GetStatuses())
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.downstreamsstatushandler: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (admin *Admin) GetAuthorization(r *http.Request) string {
 	return r.Header.Get("Authorization")
 }
@@ -312,6 +732,7 @@ func (admin *Admin) GetConfig() map[string]any {
 
 	return map[string]any{
 		"access":      admin.Controller.Accesses.List,
+		"alerts":      admin.Controller.Alerts.List,
 		"apiKeys":     admin.Controller.Apikeys.List,
 		"dirWatch":    admin.Controller.Dirwatches.List,
 		"downstreams": admin.Controller.Downstreams.List,
@@ -319,12 +740,97 @@ func (admin *Admin) GetConfig() map[string]any {
 		"options":     admin.Controller.Options,
 		"systems":     systems,
 		"tags":        admin.Controller.Tags.List,
+		"toneSets":    admin.Controller.ToneSets.List,
+		"upstreams":   admin.Controller.Upstreams.List,
 	}
 }
 
+func (admin *Admin) IngestSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	logError := func(err error) {
+		admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.ingestsourceshandler: %s", err.Error()))
+	}
+
+	if !admin.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ingestSources, err := admin.Controller.Calls.GetIngestSources(admin.Controller.Database)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		b, err := json.Marshal(ingestSources)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+	case http.MethodPost:
+		m := map[string]any{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ingestSource, ok := m["ingestSource"].(string)
+		if !ok || len(ingestSource) == 0 || m["action"] != "purge" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		count, err := admin.Controller.Calls.PurgeIngestSource(admin.Controller.Database, ingestSource)
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("purged %v calls from ingest source %s", count, ingestSource))
+
+		b, err := json.Marshal(map[string]any{"count": count})
+		if err != nil {
+			logError(err)
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (admin *Admin) IsAuthorized(r *http.Request) bool {
+	if admin.ValidateToken(admin.GetAuthorization(r)) {
+		return true
+	}
+
+	if apikey, ok := admin.Controller.Apikeys.GetApikey(r.Header.Get(ApikeyHeader)); ok {
+		ip := GetRemoteAddr(r)
+
+		if err := apikey.Authorize(ApikeyScopeAdmin, ip); err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("admin: %s for ident %s", err.Error(), apikey.Ident))
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
 func (admin *Admin) LogsHandler(w http.ResponseWriter, r *http.Request) {
-	t := admin.GetAuthorization(r)
-	if !admin.ValidateToken(t) {
+	if !admin.IsAuthorized(r) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -365,6 +871,89 @@ func (admin *Admin) LogsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (admin *Admin) ListenersHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("upgrade"), "websocket") {
+		conn, err := admin.Controller.Config.NewWebsocketUpgrader().Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		go admin.streamListeners(conn)
+
+		return
+	}
+
+	if !admin.IsAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		listeners := []map[string]any{}
+
+		for _, client := range admin.Controller.Clients.snapshot() {
+			listeners = append(listeners, client.GetStatus())
+		}
+
+		b, err := json.Marshal(listeners)
+		if err != nil {
+			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.listenershandler: %s", err.Error()))
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+
+		w.Write(b)
+
+	case http.MethodPost:
+		var id uint64
+
+		m := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch v := m["id"].(type) {
+		case float64:
+			id = uint64(v)
+		}
+
+		client, ok := admin.Controller.Clients.GetClient(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch m["action"] {
+		case "disconnect":
+			client.disconnect("kicked by admin")
+
+		case "message":
+			message, ok := m["message"].(string)
+			if !ok || len(message) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			client.enqueue(&Message{Command: MessageCommandServer, Payload: message})
+
+		case "revoke":
+			client.Revoke()
+			admin.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("listener access revoked for ip %s with ident %s", client.GetRemoteAddr(), client.GetAccess().Ident))
+			admin.BroadcastListener("updated", client)
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func (admin *Admin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -594,6 +1183,79 @@ func (admin *Admin) Start() error {
 	return nil
 }
 
+func (admin *Admin) streamListeners(conn *websocket.Conn) {
+	defer conn.Close()
+
+	// the first message must be a valid token
+	conn.SetReadDeadline(time.Now().Add(adminListenersWriteWait))
+	if _, b, err := conn.ReadMessage(); err != nil || !admin.ValidateToken(string(b)) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "unauthorized"))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	sendSnapshot := func() error {
+		listeners := []map[string]any{}
+		for _, client := range admin.Controller.Clients.snapshot() {
+			listeners = append(listeners, client.GetStatus())
+		}
+
+		b, err := json.Marshal(map[string]any{"event": "listeners", "listeners": listeners})
+		if err != nil {
+			return err
+		}
+
+		admin.listenersMutex.Lock()
+		defer admin.listenersMutex.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(adminListenersWriteWait))
+
+		return conn.WriteMessage(websocket.TextMessage, b)
+	}
+
+	if err := sendSnapshot(); err != nil {
+		return
+	}
+
+	admin.listenersMutex.Lock()
+	admin.listenersConns[conn] = true
+	admin.listenersMutex.Unlock()
+
+	defer func() {
+		admin.listenersMutex.Lock()
+		delete(admin.listenersConns, conn)
+		admin.listenersMutex.Unlock()
+	}()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// queue depths and bytes sent change continuously, so refresh them periodically
+	ticker := time.NewTicker(adminListenersInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			if err := sendSnapshot(); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func (admin *Admin) UserAddHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -601,8 +1263,7 @@ func (admin *Admin) UserAddHandler(w http.ResponseWriter, r *http.Request) {
 			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.useraddhandler.post: %s", err.Error()))
 		}
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
+		if !admin.IsAuthorized(r) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -641,8 +1302,7 @@ func (admin *Admin) UserRemoveHandler(w http.ResponseWriter, r *http.Request) {
 			admin.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("admin.userremovehandler.post: %s", err.Error()))
 		}
 
-		t := admin.GetAuthorization(r)
-		if !admin.ValidateToken(t) {
+		if !admin.IsAuthorized(r) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
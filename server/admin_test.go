@@ -0,0 +1,130 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestAdminKey adds an admin scoped api key and returns it, to authorize admin requests
+func newTestAdminKey(t *testing.T, controller *Controller, apikeys ...map[string]any) string {
+	t.Helper()
+
+	f := []any{map[string]any{"_id": float64(1), "ident": "automation", "key": "admin-key", "scopes": []any{ApikeyScopeAdmin}, "systems": "*"}}
+	for _, m := range apikeys {
+		f = append(f, m)
+	}
+
+	controller.Apikeys.FromMap(f)
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	return "admin-key"
+}
+
+func postTestAdmin(t *testing.T, handler http.HandlerFunc, key string, body string) (int, map[string]any) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set(ApikeyHeader, key)
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	m := map[string]any{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code, m
+}
+
+func TestAdminApikeySecret(t *testing.T) {
+	controller := newTestController(t)
+	secret := controller.Options.secret
+
+	adminKey := newTestAdminKey(t, controller, map[string]any{"_id": float64(2), "ident": "recorder", "key": "recorder-key", "requireSignature": true, "systems": "*"})
+
+	if code, _ := postTestAdmin(t, controller.Admin.ApikeySecretHandler, "recorder-key", `{"_id": 2}`); code != http.StatusUnauthorized {
+		t.Errorf("upload key got %d", code)
+	}
+
+	if code, _ := postTestAdmin(t, controller.Admin.ApikeySecretHandler, adminKey, `{"_id": 3}`); code != http.StatusNotFound {
+		t.Errorf("unknown key got %d", code)
+	}
+
+	code, res := postTestAdmin(t, controller.Admin.ApikeySecretHandler, adminKey, `{"_id": 2}`)
+	if code != http.StatusOK || res["signingSecret"] != GetSigningSecret(secret, HashApikey("recorder-key")) || res["key"] != nil {
+		t.Fatalf("reveal got %d %v", code, res)
+	}
+
+	code, res = postTestAdmin(t, controller.Admin.ApikeySecretHandler, adminKey, `{"_id": 2, "rotate": true}`)
+	key, _ := res["key"].(string)
+	if code != http.StatusOK || len(key) == 0 || res["signingSecret"] != GetSigningSecret(secret, HashApikey(key)) {
+		t.Fatalf("rotate got %d %v", code, res)
+	}
+
+	if _, ok := controller.Apikeys.GetApikey("recorder-key"); ok {
+		t.Error("old key still valid after rotation")
+	}
+
+	if apikey, ok := controller.Apikeys.GetApikey(key); !ok || !apikey.RequireSignature || apikey.Ident != "recorder" {
+		t.Errorf("rotated key not usable, %+v", apikey)
+	}
+}
+
+func TestAdminBroadcastMotd(t *testing.T) {
+	controller := newTestController(t)
+	controller.Options.MaxClients = 10
+	adminKey := newTestAdminKey(t, controller)
+
+	client, _ := newTestClient(t, controller)
+
+	// listeners get their config while the motd is replaced
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			client.sendConfig(GroupsMap{}, controller.Options, SystemsMap{}, TagsMap{}, 0)
+		}
+		done <- true
+	}()
+
+	code, res := postTestAdmin(t, controller.Admin.BroadcastHandler, adminKey, `{"message": " maintenance tonight ", "motd": true}`)
+	<-done
+
+	if code != http.StatusOK || res["count"] == nil {
+		t.Fatalf("broadcast got %d %v", code, res)
+	}
+
+	options := NewOptions()
+	if err := options.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if options.Motd != "maintenance tonight" {
+		t.Errorf("saved motd %q", options.Motd)
+	}
+}
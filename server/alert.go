@@ -0,0 +1,1243 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+const (
+	AlertActionEmail   = "email"
+	AlertActionExec    = "exec"
+	AlertActionWebhook = "webhook"
+)
+
+const (
+	alertActionQueueSize = 256
+	alertActionTimeout   = 30 * time.Second
+	alertActionWorkers   = 4
+)
+
+var alertHttpClient = &http.Client{
+	Timeout: alertActionTimeout,
+	Transport: &http.Transport{
+		DialContext:         alertDialContext,
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+var alertTimeRegexp = regexp.MustCompile(`^([01][0-9]|2[0-3]):([0-5][0-9])$`)
+
+type Alert struct {
+	Id        any            `json:"_id"`
+	Actions   []*AlertAction `json:"actions"`
+	Cooldown  uint           `json:"cooldown"`
+	Disabled  bool           `json:"disabled"`
+	Emergency bool           `json:"emergency"`
+	Groups    []string       `json:"groups"`
+	Label     string         `json:"label"`
+	Order     any            `json:"order"`
+	Systems   any            `json:"systems"`
+	Tags      []string       `json:"tags"`
+	TimeFrom  string         `json:"timeFrom"`
+	TimeTo    string         `json:"timeTo"`
+	ToneSets  []uint         `json:"toneSets"`
+	Units     []uint         `json:"units"`
+}
+
+func NewAlert() *Alert {
+	return &Alert{
+		Actions:  []*AlertAction{},
+		Groups:   []string{},
+		Tags:     []string{},
+		ToneSets: []uint{},
+		Units:    []uint{},
+	}
+}
+
+func (alert *Alert) FromMap(m map[string]any) *Alert {
+	switch v := m["_id"].(type) {
+	case float64:
+		alert.Id = uint(v)
+	}
+
+	switch v := m["actions"].(type) {
+	case []any:
+		for _, f := range v {
+			switch m := f.(type) {
+			case map[string]any:
+				alert.Actions = append(alert.Actions, (&AlertAction{}).FromMap(m))
+			}
+		}
+	}
+
+	switch v := m["cooldown"].(type) {
+	case float64:
+		alert.Cooldown = uint(v)
+	}
+
+	switch v := m["disabled"].(type) {
+	case bool:
+		alert.Disabled = v
+	}
+
+	switch v := m["emergency"].(type) {
+	case bool:
+		alert.Emergency = v
+	}
+
+	switch v := m["groups"].(type) {
+	case []any:
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				alert.Groups = append(alert.Groups, s)
+			}
+		}
+	}
+
+	switch v := m["label"].(type) {
+	case string:
+		alert.Label = v
+	}
+
+	switch v := m["order"].(type) {
+	case float64:
+		alert.Order = uint(v)
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			alert.Systems = string(b)
+		}
+	case string:
+		alert.Systems = v
+	}
+
+	switch v := m["tags"].(type) {
+	case []any:
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				alert.Tags = append(alert.Tags, s)
+			}
+		}
+	}
+
+	switch v := m["timeFrom"].(type) {
+	case string:
+		if alertTimeRegexp.MatchString(v) {
+			alert.TimeFrom = v
+		}
+	}
+
+	switch v := m["timeTo"].(type) {
+	case string:
+		if alertTimeRegexp.MatchString(v) {
+			alert.TimeTo = v
+		}
+	}
+
+	switch v := m["toneSets"].(type) {
+	case []any:
+		for _, f := range v {
+			switch u := f.(type) {
+			case float64:
+				alert.ToneSets = append(alert.ToneSets, uint(u))
+			}
+		}
+	}
+
+	switch v := m["units"].(type) {
+	case []any:
+		for _, f := range v {
+			switch u := f.(type) {
+			case float64:
+				alert.Units = append(alert.Units, uint(u))
+			}
+		}
+	}
+
+	return alert
+}
+
+func (alert *Alert) Match(call *Call) bool {
+	if alert.Disabled || len(alert.Actions) == 0 {
+		return false
+	}
+
+	if alert.Systems != nil && !hasSystemsAccess(alert.Systems, call) {
+		return false
+	}
+
+	if alert.Emergency && !call.emergency {
+		return false
+	}
+
+	if len(alert.Groups) > 0 && !alertHasLabel(alert.Groups, call.talkgroupGroup) {
+		return false
+	}
+
+	if len(alert.Tags) > 0 && !alertHasLabel(alert.Tags, call.talkgroupTag) {
+		return false
+	}
+
+	if len(alert.ToneSets) > 0 && !alert.hasToneSet(call) {
+		return false
+	}
+
+	if len(alert.Units) > 0 && !alert.hasUnit(call) {
+		return false
+	}
+
+	if len(alert.TimeFrom) > 0 && len(alert.TimeTo) > 0 && !alert.isInTimeFrame(call.DateTime) {
+		return false
+	}
+
+	return true
+}
+
+func (alert *Alert) hasToneSet(call *Call) bool {
+	switch v := call.Tones.(type) {
+	case []*CallTone:
+		for _, tone := range v {
+			for _, id := range alert.ToneSets {
+				if id == tone.ToneSetId {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (alert *Alert) hasUnit(call *Call) bool {
+	units := []uint{}
+
+	switch v := call.Source.(type) {
+	case int:
+		units = append(units, uint(v))
+	case uint:
+		units = append(units, v)
+	}
+
+	for _, s := range call.getSources() {
+		switch src := s["src"].(type) {
+		case float64:
+			units = append(units, uint(src))
+		}
+	}
+
+	for _, unit := range units {
+		for _, u := range alert.Units {
+			if u == unit {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// time frames are in server local time and may wrap around midnight, ie. 22:00 to 06:00
+func (alert *Alert) isInTimeFrame(t time.Time) bool {
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	t = t.Local()
+
+	now := t.Format("15:04")
+
+	if alert.TimeFrom <= alert.TimeTo {
+		return now >= alert.TimeFrom && now < alert.TimeTo
+	}
+
+	return now >= alert.TimeFrom || now < alert.TimeTo
+}
+
+type AlertAction struct {
+	Args    []string          `json:"args,omitempty"`
+	Attach  bool              `json:"attach,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Subject string            `json:"subject,omitempty"`
+	To      []string          `json:"to,omitempty"`
+	Type    string            `json:"type"`
+	Url     string            `json:"url,omitempty"`
+}
+
+func (action *AlertAction) FromMap(m map[string]any) *AlertAction {
+	switch v := m["args"].(type) {
+	case []any:
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				action.Args = append(action.Args, s)
+			}
+		}
+	}
+
+	switch v := m["attach"].(type) {
+	case bool:
+		action.Attach = v
+	}
+
+	switch v := m["body"].(type) {
+	case string:
+		action.Body = v
+	}
+
+	switch v := m["command"].(type) {
+	case string:
+		action.Command = v
+	}
+
+	switch v := m["headers"].(type) {
+	case map[string]any:
+		action.Headers = map[string]string{}
+		for k, f := range v {
+			switch s := f.(type) {
+			case string:
+				action.Headers[k] = s
+			}
+		}
+	}
+
+	switch v := m["method"].(type) {
+	case string:
+		action.Method = strings.ToUpper(v)
+	}
+
+	switch v := m["subject"].(type) {
+	case string:
+		action.Subject = v
+	}
+
+	switch v := m["to"].(type) {
+	case []any:
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				action.To = append(action.To, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				action.To = append(action.To, s)
+			}
+		}
+	}
+
+	switch v := m["type"].(type) {
+	case string:
+		switch v {
+		case AlertActionEmail, AlertActionExec, AlertActionWebhook:
+			action.Type = v
+		}
+	}
+
+	switch v := m["url"].(type) {
+	case string:
+		action.Url = v
+	}
+
+	return action
+}
+
+func (action *AlertAction) Run(call *Call, data map[string]any, options *Options) error {
+	switch action.Type {
+	case AlertActionEmail:
+		return action.runEmail(call, data, options)
+	case AlertActionExec:
+		return action.runExec(call, data, options)
+	case AlertActionWebhook:
+		return action.runWebhook(data, options)
+	default:
+		return fmt.Errorf("unknown action type %s", action.Type)
+	}
+}
+
+func (action *AlertAction) runEmail(call *Call, data map[string]any, options *Options) error {
+	if len(options.SmtpHost) == 0 {
+		return errors.New("no smtp host configured")
+	}
+
+	if len(action.To) == 0 {
+		return errors.New("no recipient")
+	}
+
+	subject := action.Subject
+	if len(subject) == 0 {
+		subject = "{{.label}}: {{.systemLabel}} {{.talkgroupLabel}}"
+	}
+
+	if s, err := executeTemplate(subject, data); err == nil {
+		subject = s
+	} else {
+		return err
+	}
+
+	body := action.Body
+	if len(body) == 0 {
+		body = "{{.label}}\n\n{{.systemLabel}} / {{.talkgroupLabel}} {{.talkgroupName}}\n{{.dateTime.Local.Format \"2006-01-02 15:04:05\"}}\n"
+	}
+
+	if s, err := executeTemplate(body, data); err == nil {
+		body = s
+	} else {
+		return err
+	}
+
+	from := options.SmtpFrom
+	if len(from) == 0 {
+		from = options.SmtpUsername
+	}
+
+	msg := &bytes.Buffer{}
+	mw := multipart.NewWriter(msg)
+
+	fmt.Fprintf(msg, "From: %s\r\n", from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(action.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(part, []byte(body))
+
+	if action.Attach && len(call.Audio) > 0 {
+		audioName, audioType := getCallAudioFile(call)
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {audioType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": audioName})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64Lines(part, call.Audio)
+	}
+
+	if err = mw.Close(); err != nil {
+		return err
+	}
+
+	return sendMail(options, from, action.To, msg.Bytes())
+}
+
+func (action *AlertAction) runExec(call *Call, data map[string]any, options *Options) error {
+	if len(action.Command) == 0 {
+		return errors.New("no command")
+	}
+
+	command, err := getAlertExecCommand(options.alertExecDir, action.Command)
+	if err != nil {
+		return err
+	}
+
+	args := []string{}
+	for _, arg := range action.Args {
+		s, err := executeTemplate(arg, data)
+		if err != nil {
+			return err
+		}
+		args = append(args, s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertActionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+
+	cmd.Env = os.Environ()
+	for _, k := range []string{"id", "label", "system", "systemLabel", "talkgroup", "talkgroupGroup", "talkgroupLabel", "talkgroupName", "talkgroupTag", "source", "emergency"} {
+		if data[k] != nil {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("RDIO_%s=%v", strings.ToUpper(k), data[k]))
+		}
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("RDIO_DATETIME=%s", call.DateTime.Format(time.RFC3339)))
+
+	if len(call.Audio) > 0 {
+		audioName, _ := getCallAudioFile(call)
+
+		f, err := os.CreateTemp("", "rdio-scanner-*"+path.Ext(audioName))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.Write(call.Audio)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		cmd.Env = append(cmd.Env, fmt.Sprintf("RDIO_AUDIO=%s", f.Name()))
+	}
+
+	if b, err := json.Marshal(data); err == nil {
+		cmd.Stdin = bytes.NewReader(b)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// getAlertExecCommand resolves the command of an exec action within the directory set by the
+// alert_exec_dir server option. Exec actions are refused when it is not set.
+func getAlertExecCommand(dir string, command string) (string, error) {
+	if len(dir) == 0 {
+		return "", errors.New("exec actions are disabled, set alert_exec_dir to enable them")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(command) {
+		command = filepath.Join(dir, command)
+	}
+
+	command = filepath.Clean(command)
+
+	if rel, err := filepath.Rel(dir, command); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("command %s is not in %s", command, dir)
+	}
+
+	return command, nil
+}
+
+func (action *AlertAction) runWebhook(data map[string]any, options *Options) error {
+	var body []byte
+
+	if len(action.Url) == 0 {
+		return errors.New("no url")
+	}
+
+	if len(action.Body) > 0 {
+		s, err := executeTemplate(action.Body, data)
+		if err != nil {
+			return err
+		}
+		body = []byte(s)
+
+	} else if b, err := json.Marshal(data); err == nil {
+		body = b
+
+	} else {
+		return err
+	}
+
+	method := action.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+
+	u, err := url.Parse(action.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+		return errors.New("invalid url")
+	}
+
+	ctx := context.WithValue(context.Background(), alertAllowListKey{}, newAlertAllowList(options.AlertWebhookAllowList))
+
+	req, err := http.NewRequestWithContext(ctx, method, action.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range action.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := alertHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", res.Status)
+	}
+
+	return nil
+}
+
+// webhooks reach public hosts only, listing hosts, addresses or cidrs restricts them to those
+// and is the only way to reach a private address. a single * lifts the restriction.
+type alertAllowList struct {
+	all   bool
+	hosts []string
+	nets  []*net.IPNet
+}
+
+type alertAllowListKey struct{}
+
+func newAlertAllowList(s string) *alertAllowList {
+	allowList := &alertAllowList{hosts: []string{}, nets: []*net.IPNet{}}
+
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if f == "*" {
+			allowList.all = true
+
+		} else if _, n, err := net.ParseCIDR(f); err == nil {
+			allowList.nets = append(allowList.nets, n)
+
+		} else if ip := net.ParseIP(f); ip != nil {
+			allowList.nets = append(allowList.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+
+		} else {
+			allowList.hosts = append(allowList.hosts, strings.ToLower(f))
+		}
+	}
+
+	return allowList
+}
+
+func (allowList *alertAllowList) hasHost(host string) bool {
+	if allowList.all {
+		return true
+	}
+
+	for _, h := range allowList.hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (allowList *alertAllowList) hasIp(ip net.IP) bool {
+	if allowList.all {
+		return true
+	}
+
+	for _, n := range allowList.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return len(allowList.hosts) == 0 && len(allowList.nets) == 0 && isPublicIp(ip)
+}
+
+type Alerts struct {
+	List      []*Alert
+	cooldowns map[string]time.Time
+	jobs      chan *alertJob
+	mutex     sync.Mutex
+}
+
+type alertJob struct {
+	action     *AlertAction
+	alert      *Alert
+	call       *Call
+	controller *Controller
+	data       map[string]any
+}
+
+func NewAlerts() *Alerts {
+	alerts := &Alerts{
+		List:      []*Alert{},
+		cooldowns: map[string]time.Time{},
+		jobs:      make(chan *alertJob, alertActionQueueSize),
+		mutex:     sync.Mutex{},
+	}
+
+	for i := 0; i < alertActionWorkers; i++ {
+		go func() {
+			for job := range alerts.jobs {
+				if err := job.action.Run(job.call, job.data, job.controller.Options); err != nil {
+					job.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("alert: %s %s action failed, %v", job.alert.Label, job.action.Type, err))
+				}
+			}
+		}()
+	}
+
+	return alerts
+}
+
+func (alerts *Alerts) FromMap(f []any) *Alerts {
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	alerts.List = []*Alert{}
+
+	for _, r := range f {
+		switch m := r.(type) {
+		case map[string]any:
+			alerts.List = append(alerts.List, NewAlert().FromMap(m))
+		}
+	}
+
+	return alerts
+}
+
+// Process runs the actions of the alerts matching the call, cooldowns apply per alert and talkgroup.
+func (alerts *Alerts) Process(call *Call, controller *Controller) {
+	alerts.process(call, controller, false)
+}
+
+// ProcessTones runs the alerts on tone sets once the tones of the call are detected,
+// the others already ran when the call was ingested.
+func (alerts *Alerts) ProcessTones(call *Call, controller *Controller) {
+	alerts.process(call, controller, true)
+}
+
+// Prune forgets the cooldowns that are over.
+func (alerts *Alerts) Prune() {
+	now := time.Now()
+
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	for key, t := range alerts.cooldowns {
+		if now.After(t) {
+			delete(alerts.cooldowns, key)
+		}
+	}
+}
+
+func (alerts *Alerts) Read(db *Database) error {
+	var (
+		actions  string
+		err      error
+		groups   sql.NullString
+		id       sql.NullFloat64
+		order    sql.NullFloat64
+		rows     *sql.Rows
+		systems  string
+		tags     sql.NullString
+		toneSets sql.NullString
+		units    sql.NullString
+	)
+
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	alerts.List = []*Alert{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("alerts.read: %v", err)
+	}
+
+	q := "select `_id`, `actions`, `cooldown`, `disabled`, `emergency`, `groups`, `label`, `order`, `systems`, `tags`, `timeFrom`, `timeTo`, `toneSets`, `units` from `rdioScannerAlerts`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id, actions, cooldown, disabled, emergency, \"groups\", label, \"order\", systems, tags, timeFrom, timeTo, toneSets, units from rdioScannerAlerts"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		alert := NewAlert()
+
+		if err = rows.Scan(&id, &actions, &alert.Cooldown, &alert.Disabled, &alert.Emergency, &groups, &alert.Label, &order, &systems, &tags, &alert.TimeFrom, &alert.TimeTo, &toneSets, &units); err != nil {
+			break
+		}
+
+		if id.Valid && id.Float64 > 0 {
+			alert.Id = uint(id.Float64)
+		}
+
+		if order.Valid && order.Float64 > 0 {
+			alert.Order = uint(order.Float64)
+		}
+
+		if err := json.Unmarshal([]byte(actions), &alert.Actions); err != nil {
+			alert.Actions = []*AlertAction{}
+		}
+
+		if groups.Valid && len(groups.String) > 0 {
+			json.Unmarshal([]byte(groups.String), &alert.Groups)
+		}
+
+		if err = json.Unmarshal([]byte(systems), &alert.Systems); err != nil {
+			alert.Systems = []any{}
+		}
+
+		if tags.Valid && len(tags.String) > 0 {
+			json.Unmarshal([]byte(tags.String), &alert.Tags)
+		}
+
+		if toneSets.Valid && len(toneSets.String) > 0 {
+			json.Unmarshal([]byte(toneSets.String), &alert.ToneSets)
+		}
+
+		if units.Valid && len(units.String) > 0 {
+			json.Unmarshal([]byte(units.String), &alert.Units)
+		}
+
+		alerts.List = append(alerts.List, alert)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (alerts *Alerts) Write(db *Database) error {
+	var (
+		actions  []byte
+		count    uint
+		err      error
+		groups   []byte
+		rows     *sql.Rows
+		rowIds   = []uint{}
+		systems  any
+		tags     []byte
+		toneSets []byte
+		units    []byte
+	)
+
+	alerts.mutex.Lock()
+	defer alerts.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("alerts.write: %v", err)
+	}
+
+	q := "select `_id` from `rdioScannerAlerts`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id from rdioScannerAlerts"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var rowId uint
+		if err = rows.Scan(&rowId); err != nil {
+			break
+		}
+		remove := true
+		for _, alert := range alerts.List {
+			if alert.Id == nil || alert.Id == rowId {
+				remove = false
+				break
+			}
+		}
+		if remove {
+			rowIds = append(rowIds, rowId)
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	if len(rowIds) > 0 {
+		if b, err := json.Marshal(rowIds); err == nil {
+			s := string(b)
+			s = strings.ReplaceAll(s, "[", "(")
+			s = strings.ReplaceAll(s, "]", ")")
+			q := fmt.Sprintf("delete from `rdioScannerAlerts` where `_id` in %v", s)
+			if db.Config.DbType == DbTypePostgresql {
+				q = fmt.Sprintf("delete from rdioScannerAlerts where _id in %v", s)
+			}
+			if _, err = db.Sql.Exec(q); err != nil {
+				return formatError(err)
+			}
+		}
+	}
+
+	for _, alert := range alerts.List {
+		switch alert.Systems {
+		case nil:
+			systems = `"*"`
+		case "*":
+			systems = `"*"`
+		default:
+			systems = alert.Systems
+		}
+
+		if actions, err = json.Marshal(alert.Actions); err != nil {
+			break
+		}
+
+		if groups, err = json.Marshal(alert.Groups); err != nil {
+			break
+		}
+
+		if tags, err = json.Marshal(alert.Tags); err != nil {
+			break
+		}
+
+		if toneSets, err = json.Marshal(alert.ToneSets); err != nil {
+			break
+		}
+
+		if units, err = json.Marshal(alert.Units); err != nil {
+			break
+		}
+
+		q := "select count(*) from `rdioScannerAlerts` where `_id` = ?"
+		if db.Config.DbType == DbTypePostgresql {
+			q = "select count(*) from rdioScannerAlerts where _id = $1"
+		}
+		if err = db.Sql.QueryRow(q, alert.Id).Scan(&count); err != nil {
+			break
+		}
+
+		if count == 0 {
+			q := "insert into `rdioScannerAlerts` (`_id`, `actions`, `cooldown`, `disabled`, `emergency`, `groups`, `label`, `order`, `systems`, `tags`, `timeFrom`, `timeTo`, `toneSets`, `units`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "insert into rdioScannerAlerts (_id, actions, cooldown, disabled, emergency, \"groups\", label, \"order\", systems, tags, timeFrom, timeTo, toneSets, units) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)"
+			}
+			if _, err = db.Sql.Exec(q, alert.Id, string(actions), alert.Cooldown, alert.Disabled, alert.Emergency, string(groups), alert.Label, alert.Order, systems, string(tags), alert.TimeFrom, alert.TimeTo, string(toneSets), string(units)); err != nil {
+				break
+			}
+
+		} else {
+			q := "update `rdioScannerAlerts` set `_id` = ?, `actions` = ?, `cooldown` = ?, `disabled` = ?, `emergency` = ?, `groups` = ?, `label` = ?, `order` = ?, `systems` = ?, `tags` = ?, `timeFrom` = ?, `timeTo` = ?, `toneSets` = ?, `units` = ? where `_id` = ?"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "update rdioScannerAlerts set _id = $1, actions = $2, cooldown = $3, disabled = $4, emergency = $5, \"groups\" = $6, label = $7, \"order\" = $8, systems = $9, tags = $10, timeFrom = $11, timeTo = $12, toneSets = $13, units = $14 where _id = $15"
+			}
+			if _, err = db.Sql.Exec(q, alert.Id, string(actions), alert.Cooldown, alert.Disabled, alert.Emergency, string(groups), alert.Label, alert.Order, systems, string(tags), alert.TimeFrom, alert.TimeTo, string(toneSets), string(units), alert.Id); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (alerts *Alerts) process(call *Call, controller *Controller, toneSetsOnly bool) {
+	var (
+		matched = []*Alert{}
+		now     = time.Now()
+	)
+
+	alerts.mutex.Lock()
+
+	for _, alert := range alerts.List {
+		if toneSetsOnly && len(alert.ToneSets) == 0 {
+			continue
+		}
+
+		if !alert.Match(call) {
+			continue
+		}
+
+		key := fmt.Sprintf("%v:%d:%d", alert.Id, call.System, call.Talkgroup)
+
+		if t, ok := alerts.cooldowns[key]; ok && now.Before(t) {
+			continue
+		}
+
+		if alert.Cooldown > 0 {
+			alerts.cooldowns[key] = now.Add(time.Duration(alert.Cooldown) * time.Second)
+		}
+
+		matched = append(matched, alert)
+	}
+
+	alerts.mutex.Unlock()
+
+	for _, alert := range matched {
+		data := getCallTemplateData(call, getCallUnitLabels(call, controller.Systems))
+		data["label"] = alert.Label
+
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("alert: %s triggered by system=%v talkgroup=%v", alert.Label, call.System, call.Talkgroup))
+
+		for _, action := range alert.Actions {
+			// a burst of matches must not fan out into unbounded webhooks, mails and processes
+			select {
+			case alerts.jobs <- &alertJob{action: action, alert: alert, call: call, controller: controller, data: data}:
+			default:
+				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("alert: %s %s action dropped, queue is full", alert.Label, action.Type))
+			}
+		}
+	}
+}
+
+func alertDialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	allowList, ok := ctx.Value(alertAllowListKey{}).(*alertAllowList)
+	if !ok {
+		allowList = newAlertAllowList("")
+	}
+
+	dialer := &net.Dialer{Timeout: alertActionTimeout}
+
+	if allowList.hasHost(host) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	// dial the address that was checked, not whatever the name resolves to next
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if allowList.hasIp(addr.IP) {
+			return dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not an allowed webhook host", host)
+}
+
+func alertHasLabel(labels []string, f any) bool {
+	switch v := f.(type) {
+	case string:
+		for _, label := range labels {
+			if strings.EqualFold(label, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func executeTemplate(text string, data map[string]any) (string, error) {
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"join": strings.Join,
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func getCallAudioFile(call *Call) (audioName string, audioType string) {
+	switch v := call.AudioName.(type) {
+	case string:
+		audioName = v
+	}
+
+	switch v := call.AudioType.(type) {
+	case string:
+		audioType = v
+	}
+
+	if len(audioName) == 0 {
+		audioName = fmt.Sprintf("%v-%v-%v.m4a", call.System, call.Talkgroup, call.DateTime.Unix())
+	}
+
+	if len(audioType) == 0 {
+		if audioType = mime.TypeByExtension(path.Ext(audioName)); len(audioType) == 0 {
+			audioType = "application/octet-stream"
+		}
+	}
+
+	return audioName, audioType
+}
+
+func getCallTemplateData(call *Call, units []string) map[string]any {
+	tones := []string{}
+
+	switch v := call.Tones.(type) {
+	case []*CallTone:
+		for _, tone := range v {
+			tones = append(tones, tone.Label)
+		}
+	}
+
+	if units == nil {
+		units = []string{}
+	}
+
+	return map[string]any{
+		"id":             call.Id,
+		"audioName":      call.AudioName,
+		"dateTime":       call.DateTime,
+		"emergency":      call.emergency,
+		"frequency":      call.Frequency,
+		"source":         call.Source,
+		"system":         call.System,
+		"systemLabel":    call.systemLabel,
+		"talkgroup":      call.Talkgroup,
+		"talkgroupGroup": call.talkgroupGroup,
+		"talkgroupLabel": call.talkgroupLabel,
+		"talkgroupName":  call.talkgroupName,
+		"talkgroupTag":   call.talkgroupTag,
+		"tones":          tones,
+		"units":          units,
+	}
+}
+
+// sources are []map[string]any from the parsers and []any once read back from the database or a retry queue
+func getCallUnitLabels(call *Call, systems *Systems) []string {
+	units := []string{}
+
+	system, ok := systems.GetSystem(call.System)
+	if !ok {
+		return units
+	}
+
+	for _, s := range call.getSources() {
+		for _, unit := range system.Units.List {
+			if float64(unit.Id) == s["src"] {
+				units = append(units, unit.Label)
+				break
+			}
+		}
+	}
+
+	return units
+}
+
+func sendMail(options *Options, from string, to []string, msg []byte) error {
+	var (
+		client *smtp.Client
+		conn   net.Conn
+		err    error
+	)
+
+	addr := net.JoinHostPort(options.SmtpHost, fmt.Sprintf("%d", options.SmtpPort))
+	dialer := &net.Dialer{Timeout: alertActionTimeout}
+	tlsConfig := &tls.Config{ServerName: options.SmtpHost}
+
+	// port 465 is implicit tls, others upgrade with starttls when offered
+	if options.SmtpPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(alertActionTimeout))
+
+	if client, err = smtp.NewClient(conn, options.SmtpHost); err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && options.SmtpPort != 465 {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if len(options.SmtpUsername) > 0 {
+		if err = client.Auth(smtp.PlainAuth("", options.SmtpUsername, options.SmtpPassword, options.SmtpHost)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err = client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func writeBase64Lines(w io.Writer, b []byte) {
+	s := base64.StdEncoding.EncodeToString(b)
+	for len(s) > 76 {
+		io.WriteString(w, s[:76]+"\r\n")
+		s = s[76:]
+	}
+	io.WriteString(w, s+"\r\n")
+}
@@ -0,0 +1,464 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestAlertCall() *Call {
+	call := newTestDownstreamCall()
+	call.DateTime = time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	call.talkgroupGroup = "Fire"
+	call.talkgroupTag = "Dispatch"
+	return call
+}
+
+func newTestAlertServer(t *testing.T) (*httptest.Server, *atomic.Int64, chan *http.Request) {
+	t.Helper()
+
+	hits := &atomic.Int64{}
+	requests := make(chan *http.Request, 16)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		hits.Add(1)
+		requests <- r
+	}))
+	t.Cleanup(server.Close)
+
+	return server, hits, requests
+}
+
+// a bare smtp server without tls nor auth, enough for sendMail
+func newTestSmtpServer(t *testing.T) (string, uint, chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 4)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+
+				reply("220 localhost ready")
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+						reply("250 localhost")
+					case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+						reply("250 ok")
+					case cmd == "DATA":
+						reply("354 go ahead")
+						msg := &strings.Builder{}
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							msg.WriteString(l)
+						}
+						messages <- msg.String()
+						reply("250 queued")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("502 not implemented")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	return addr.IP.String(), uint(addr.Port), messages
+}
+
+func TestAlertMatch(t *testing.T) {
+	webhook := []*AlertAction{{Type: AlertActionWebhook, Url: "https://hooks.example.com"}}
+
+	emergency := newTestAlertCall()
+	emergency.emergency = true
+
+	toned := newTestAlertCall()
+	toned.Tones = []*CallTone{{A: 600, B: 900, ToneSetId: 7}}
+
+	unit := newTestAlertCall()
+	unit.Source = uint(4242)
+
+	stored := newTestAlertCall()
+	stored.Sources = []any{map[string]any{"pos": float64(0), "src": float64(4242)}}
+
+	for _, c := range []struct {
+		name  string
+		alert *Alert
+		call  *Call
+		match bool
+	}{
+		{"all", &Alert{Actions: webhook}, newTestAlertCall(), true},
+		{"no actions", &Alert{}, newTestAlertCall(), false},
+		{"disabled", &Alert{Actions: webhook, Disabled: true}, newTestAlertCall(), false},
+		{"system", &Alert{Actions: webhook, Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(150)}}}}, newTestAlertCall(), true},
+		{"other talkgroup", &Alert{Actions: webhook, Systems: []any{map[string]any{"id": float64(1), "talkgroups": []any{float64(151)}}}}, newTestAlertCall(), false},
+		{"emergency only", &Alert{Actions: webhook, Emergency: true}, newTestAlertCall(), false},
+		{"emergency", &Alert{Actions: webhook, Emergency: true}, emergency, true},
+		{"group", &Alert{Actions: webhook, Groups: []string{"fire"}}, newTestAlertCall(), true},
+		{"other group", &Alert{Actions: webhook, Groups: []string{"EMS"}}, newTestAlertCall(), false},
+		{"tag", &Alert{Actions: webhook, Tags: []string{"Dispatch"}}, newTestAlertCall(), true},
+		{"other tag", &Alert{Actions: webhook, Tags: []string{"Law"}}, newTestAlertCall(), false},
+		{"tone set", &Alert{Actions: webhook, ToneSets: []uint{7}}, toned, true},
+		{"no tones", &Alert{Actions: webhook, ToneSets: []uint{7}}, newTestAlertCall(), false},
+		{"unit", &Alert{Actions: webhook, Units: []uint{4242}}, unit, true},
+		{"other unit", &Alert{Actions: webhook, Units: []uint{1}}, unit, false},
+		{"stored sources", &Alert{Actions: webhook, Units: []uint{4242}}, stored, true},
+		{"in time frame", &Alert{Actions: webhook, TimeFrom: "11:00", TimeTo: "13:00"}, newTestAlertCall(), true},
+		{"out of time frame", &Alert{Actions: webhook, TimeFrom: "13:00", TimeTo: "14:00"}, newTestAlertCall(), false},
+	} {
+		if match := c.alert.Match(c.call); match != c.match {
+			t.Errorf("%s: expected %v, got %v", c.name, c.match, match)
+		}
+	}
+}
+
+func TestAlertIsInTimeFrame(t *testing.T) {
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2026, 10, 18, hour, minute, 0, 0, time.Local)
+	}
+
+	overnight := &Alert{TimeFrom: "22:00", TimeTo: "06:00"}
+	daytime := &Alert{TimeFrom: "08:00", TimeTo: "17:00"}
+
+	for _, c := range []struct {
+		alert *Alert
+		t     time.Time
+		in    bool
+	}{
+		{overnight, at(21, 59), false},
+		{overnight, at(22, 0), true},
+		{overnight, at(23, 30), true},
+		{overnight, at(0, 0), true},
+		{overnight, at(5, 59), true},
+		{overnight, at(6, 0), false},
+		{overnight, at(12, 0), false},
+		{daytime, at(7, 59), false},
+		{daytime, at(8, 0), true},
+		{daytime, at(16, 59), true},
+		{daytime, at(17, 0), false},
+		{daytime, at(23, 0), false},
+	} {
+		if in := c.alert.isInTimeFrame(c.t); in != c.in {
+			t.Errorf("%s-%s at %s: expected %v, got %v", c.alert.TimeFrom, c.alert.TimeTo, c.t.Format("15:04"), c.in, in)
+		}
+	}
+}
+
+func TestAlertsProcessCooldown(t *testing.T) {
+	controller := newTestController(t)
+	server, hits, _ := newTestAlertServer(t)
+
+	controller.Options.AlertWebhookAllowList = "127.0.0.1"
+	controller.Alerts.List = []*Alert{{
+		Id:       uint(1),
+		Actions:  []*AlertAction{{Type: AlertActionWebhook, Url: server.URL}},
+		Cooldown: 60,
+		Label:    "cooldown",
+	}}
+
+	wait := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for hits.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d webhooks, got %d", n, hits.Load())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	controller.Alerts.Process(newTestAlertCall(), controller)
+	wait(1)
+
+	controller.Alerts.Process(newTestAlertCall(), controller)
+
+	other := newTestAlertCall()
+	other.Talkgroup = 151
+	controller.Alerts.Process(other, controller)
+	wait(2)
+
+	time.Sleep(100 * time.Millisecond)
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("cooldown not applied, %d webhooks", n)
+	}
+
+	controller.Alerts.mutex.Lock()
+	controller.Alerts.cooldowns["1:1:150"] = time.Now().Add(-time.Second)
+	controller.Alerts.mutex.Unlock()
+
+	controller.Alerts.Prune()
+
+	controller.Alerts.mutex.Lock()
+	_, expired := controller.Alerts.cooldowns["1:1:150"]
+	_, active := controller.Alerts.cooldowns["1:1:151"]
+	controller.Alerts.mutex.Unlock()
+
+	if expired || !active {
+		t.Fatalf("prune kept %v and dropped %v", expired, !active)
+	}
+
+	controller.Alerts.Process(newTestAlertCall(), controller)
+	wait(3)
+}
+
+func TestAlertsProcessTones(t *testing.T) {
+	controller := newTestController(t)
+	server, _, requests := newTestAlertServer(t)
+
+	controller.Options.AlertWebhookAllowList = "127.0.0.1"
+	controller.Alerts.List = []*Alert{
+		{Id: uint(1), Actions: []*AlertAction{{Type: AlertActionWebhook, Url: server.URL + "/any"}}, Label: "any"},
+		{Id: uint(2), Actions: []*AlertAction{{Type: AlertActionWebhook, Url: server.URL + "/tones"}}, Label: "tones", ToneSets: []uint{7}},
+	}
+
+	next := func() string {
+		t.Helper()
+		select {
+		case r := <-requests:
+			return r.URL.Path
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook")
+		}
+		return ""
+	}
+
+	call := newTestAlertCall()
+
+	// tones are not known yet when the call is ingested
+	controller.Alerts.Process(call, controller)
+	if path := next(); path != "/any" {
+		t.Fatalf("got %s, want /any", path)
+	}
+
+	detected := *call
+	detected.Tones = []*CallTone{{A: 600.9, B: 832.5, ToneSetId: 7}}
+
+	controller.Alerts.ProcessTones(&detected, controller)
+	if path := next(); path != "/tones" {
+		t.Fatalf("got %s, want /tones", path)
+	}
+
+	select {
+	case r := <-requests:
+		t.Fatalf("unexpected webhook %s", r.URL.Path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAlertRunWebhook(t *testing.T) {
+	server, _, requests := newTestAlertServer(t)
+
+	options := NewOptions()
+	options.AlertWebhookAllowList = "127.0.0.1"
+
+	data := getCallTemplateData(newTestAlertCall(), nil)
+	data["label"] = "webhook"
+
+	action := &AlertAction{Headers: map[string]string{"X-Token": "secret"}, Type: AlertActionWebhook, Url: server.URL + "/hook"}
+	if err := action.Run(newTestAlertCall(), data, options); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	body, _ := io.ReadAll(r.Body)
+
+	payload := map[string]any{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Method != http.MethodPost || r.URL.Path != "/hook" || r.Header.Get("X-Token") != "secret" || payload["label"] != "webhook" || payload["talkgroup"] != float64(150) {
+		t.Fatalf("unexpected webhook %s %s %v %v", r.Method, r.URL.Path, r.Header, payload)
+	}
+
+	action = &AlertAction{Body: `{"text":"{{.label}} {{.talkgroup}}"}`, Method: http.MethodPut, Type: AlertActionWebhook, Url: server.URL}
+	if err := action.Run(newTestAlertCall(), data, options); err != nil {
+		t.Fatal(err)
+	}
+
+	r = <-requests
+	body, _ = io.ReadAll(r.Body)
+
+	if r.Method != http.MethodPut || string(body) != `{"text":"webhook 150"}` {
+		t.Fatalf("unexpected templated webhook %s %s", r.Method, body)
+	}
+}
+
+func TestAlertRunExec(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+
+	if err := os.WriteFile(filepath.Join(dir, "notify"), []byte("#!/bin/sh\necho \"$1 $RDIO_TALKGROUP\" > \"$2\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	data := getCallTemplateData(newTestAlertCall(), nil)
+	data["label"] = "exec"
+
+	action := &AlertAction{Args: []string{"{{.label}}", out}, Command: "notify", Type: AlertActionExec}
+
+	// exec actions are off until the server config names a directory
+	if err := action.Run(newTestAlertCall(), data, NewOptions()); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("exec ran without alert_exec_dir, %v", err)
+	}
+
+	options := NewOptions()
+	options.alertExecDir = dir
+
+	if err := action.Run(newTestAlertCall(), data, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(out); err != nil || string(b) != "exec 150\n" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+
+	for _, command := range []string{"/bin/sh", "../notify", filepath.Join(dir, "..", "notify"), "."} {
+		action := &AlertAction{Command: command, Type: AlertActionExec}
+		if err := action.Run(newTestAlertCall(), data, options); err == nil || !strings.Contains(err.Error(), "is not in") {
+			t.Errorf("command %s outside of alert_exec_dir, %v", command, err)
+		}
+	}
+}
+
+func TestAlertRunWebhookAllowList(t *testing.T) {
+	server, hits, _ := newTestAlertServer(t)
+
+	data := getCallTemplateData(newTestAlertCall(), nil)
+	action := &AlertAction{Type: AlertActionWebhook, Url: server.URL}
+
+	for _, allowList := range []string{"", "hooks.example.com", "10.0.0.0/8"} {
+		options := NewOptions()
+		options.AlertWebhookAllowList = allowList
+
+		if err := action.Run(newTestAlertCall(), data, options); err == nil || !strings.Contains(err.Error(), "not an allowed webhook host") {
+			t.Errorf("allow-list %q let the webhook through, %v", allowList, err)
+		}
+	}
+
+	for _, allowList := range []string{"*", "127.0.0.0/8", "hooks.example.com, 127.0.0.1"} {
+		options := NewOptions()
+		options.AlertWebhookAllowList = allowList
+
+		if err := action.Run(newTestAlertCall(), data, options); err != nil {
+			t.Errorf("allow-list %q refused the webhook, %v", allowList, err)
+		}
+	}
+
+	if n := hits.Load(); n != 3 {
+		t.Fatalf("expected 3 webhooks, got %d", n)
+	}
+
+	if err := (&AlertAction{Type: AlertActionWebhook, Url: "file:///etc/passwd"}).Run(newTestAlertCall(), data, NewOptions()); err == nil {
+		t.Fatal("non http url accepted")
+	}
+
+	if newAlertAllowList("").hasIp(net.ParseIP("8.8.8.8")) != true {
+		t.Fatal("public address refused without an allow-list")
+	}
+
+	if newAlertAllowList("hooks.example.com").hasIp(net.ParseIP("8.8.8.8")) != false {
+		t.Fatal("unlisted public address allowed with an allow-list")
+	}
+}
+
+func TestAlertRunEmail(t *testing.T) {
+	host, port, messages := newTestSmtpServer(t)
+
+	options := NewOptions()
+	options.SmtpFrom = "rdio@example.com"
+	options.SmtpHost = host
+	options.SmtpPort = port
+
+	call := newTestAlertCall()
+	call.Audio = []byte("audio")
+
+	data := getCallTemplateData(call, nil)
+	data["label"] = "mail"
+
+	action := &AlertAction{Attach: true, Subject: "{{.label}} on {{.talkgroup}}", To: []string{"a@example.com", "b@example.com"}, Type: AlertActionEmail}
+	if err := action.Run(call, data, options); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-messages:
+		for _, s := range []string{
+			"From: rdio@example.com\r\n",
+			"To: a@example.com, b@example.com\r\n",
+			"Subject: mail on 150\r\n",
+			"filename=call.m4a",
+			"YXVkaW8=",
+		} {
+			if !strings.Contains(msg, s) {
+				t.Errorf("message is missing %q", s)
+			}
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	if err := (&AlertAction{Type: AlertActionEmail}).Run(call, data, options); err == nil {
+		t.Fatal("mail sent without recipients")
+	}
+}
@@ -16,30 +16,355 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	broadcastifyMetadataMaxSize = 1 << 20
+	broadcastifyUploadMaxSize   = 32 << 20
+	broadcastifyUploadTimeout   = 5 * time.Minute
 )
 
 type Api struct {
-	Controller *Controller
+	Controller          *Controller
+	broadcastifyUploads map[string]*apiBroadcastifyUpload
+	mutex               sync.Mutex
+	rateLimiter         *RateLimiter
+	signatureNonces     *SignatureNonces
+}
+
+type apiBroadcastifyUpload struct {
+	call  *Call
+	key   string
+	timer *time.Timer
 }
 
 func NewApi(controller *Controller) *Api {
-	return &Api{Controller: controller}
+	return &Api{
+		Controller:          controller,
+		broadcastifyUploads: map[string]*apiBroadcastifyUpload{},
+		mutex:               sync.Mutex{},
+		rateLimiter:         NewRateLimiter(),
+		signatureNonces:     NewSignatureNonces(),
+	}
 }
 
-func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
+func (api *Api) BroadcastifyCallUploadHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		var (
 			call = NewCall()
+			ext  = ".mp3"
 			key  string
 		)
 
+		if !api.checkIpRateLimit(w, r) {
+			return
+		}
+
+		// the audio comes later with the put request, only the call metadata is posted here
+		r.Body = http.MaxBytesReader(w, r.Body, broadcastifyMetadataMaxSize)
+
+		if err := r.ParseMultipartForm(broadcastifyMetadataMaxSize); err != nil && err != http.ErrNotMultipart {
+			api.exitWithError(w, http.StatusBadRequest, fmt.Sprintf("1 Invalid-Form-Data: %s", err.Error()))
+			return
+		}
+
+		if r.MultipartForm != nil {
+			for _, fh := range r.MultipartForm.File["metadata"] {
+				if f, err := fh.Open(); err == nil {
+					b, err := io.ReadAll(f)
+					f.Close()
+					if err != nil {
+						api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("1 Invalid-Metadata: %s", err.Error()))
+						return
+					}
+					if err = ParseTrunkRecorderMeta(call, b); err != nil {
+						api.exitWithError(w, http.StatusExpectationFailed, "1 Invalid-Metadata")
+						return
+					}
+				}
+			}
+		}
+
+		for name, values := range r.Form {
+			if len(values) == 0 {
+				continue
+			}
+
+			v := values[0]
+
+			switch name {
+			case "apiKey", "key":
+				key = v
+			case "callDuration", "test":
+			case "enc":
+				if len(v) > 0 {
+					ext = fmt.Sprintf(".%s", strings.TrimPrefix(v, "."))
+				}
+			case "freq":
+				if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+					if f < 1e5 {
+						f *= 1e6
+					}
+					ParseFormContent(call, "frequency", "", []byte(fmt.Sprintf("%d", uint(f))))
+				}
+			case "metadata":
+				if err := ParseTrunkRecorderMeta(call, []byte(v)); err != nil {
+					api.exitWithError(w, http.StatusExpectationFailed, "1 Invalid-Metadata")
+					return
+				}
+			case "patches":
+				if !strings.HasPrefix(v, "[") {
+					v = fmt.Sprintf("[%s]", v)
+				}
+				ParseFormContent(call, "patches", "", []byte(v))
+			case "src":
+				ParseFormContent(call, "source", "", []byte(v))
+			case "systemId":
+				ParseFormContent(call, "system", "", []byte(v))
+			case "tg":
+				ParseFormContent(call, "talkgroup", "", []byte(v))
+			case "ts":
+				ParseFormContent(call, "dateTime", "", []byte(v))
+			default:
+				ParseFormContent(call, name, "", []byte(v))
+			}
+		}
+
+		apikey, ok := api.Controller.Apikeys.GetApikey(key)
+		if !ok {
+			api.exitWithError(w, http.StatusUnauthorized, "1 Invalid-API-Key")
+			return
+		}
+
+		if !api.checkApikeyRateLimit(w, r, apikey) {
+			return
+		}
+
+		if r.Form.Get("test") == "1" {
+			w.Write([]byte("OK"))
+			return
+		}
+
+		if call.System < 1 || call.Talkgroup < 1 || call.DateTime.IsZero() {
+			api.exitWithError(w, http.StatusExpectationFailed, "1 Incomplete-Call-Data")
+			return
+		}
+
+		if !apikey.HasAccess(call) || apikey.RequireSignature || apikey.Authorize(ApikeyScopeUpload, GetRemoteAddr(r)) != nil {
+			api.exitWithError(w, http.StatusUnauthorized, "1 API-Key-Access-Denied")
+			return
+		}
+
+		if call.AudioName == nil {
+			call.AudioName = fmt.Sprintf("%d-%d-%d%s", call.System, call.Talkgroup, call.DateTime.Unix(), ext)
+		}
+
+		token := uuid.New().String()
+
+		api.mutex.Lock()
+		api.broadcastifyUploads[token] = &apiBroadcastifyUpload{
+			call: call,
+			key:  key,
+			timer: time.AfterFunc(broadcastifyUploadTimeout, func() {
+				api.mutex.Lock()
+				delete(api.broadcastifyUploads, token)
+				api.mutex.Unlock()
+			}),
+		}
+		api.mutex.Unlock()
+
+		w.Write([]byte(fmt.Sprintf("0 %s/api/broadcastify-call-upload/%s", api.getBaseUrl(r), token)))
+
+	case http.MethodPut:
+		token := path.Base(r.URL.Path)
+
+		api.mutex.Lock()
+		_, ok := api.broadcastifyUploads[token]
+		api.mutex.Unlock()
+
+		if !ok {
+			api.exitWithError(w, http.StatusNotFound, "Invalid upload slot")
+			return
+		}
+
+		// the slot is kept until the audio is fully read so that a failed transfer can be retried
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, broadcastifyUploadMaxSize))
+		if err != nil {
+			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("ioread: %s", err.Error()))
+			return
+		}
+
+		api.mutex.Lock()
+		upload, ok := api.broadcastifyUploads[token]
+		if ok {
+			upload.timer.Stop()
+			delete(api.broadcastifyUploads, token)
+		}
+		api.mutex.Unlock()
+
+		if !ok {
+			api.exitWithError(w, http.StatusNotFound, "Invalid upload slot")
+			return
+		}
+
+		call := upload.call
+		call.Audio = b
+
+		switch v := call.AudioName.(type) {
+		case string:
+			call.AudioType = mime.TypeByExtension(path.Ext(v))
+		}
+
+		if t := r.Header.Get("Content-Type"); len(t) > 0 && t != "application/octet-stream" {
+			call.AudioType = t
+		}
+
+		if ok, err := call.IsValid(); ok {
+			apikey, _ := api.Controller.Apikeys.GetApikey(upload.key)
+			api.HandleCall(apikey, call, w, r)
+
+		} else {
+			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s\n", err.Error()))
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+	}
+}
+
+func (api *Api) CallsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Unsupported method\n"))
+		return
+	}
+
+	if suffix := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/calls"), "/"); len(suffix) > 0 {
+		id, resource, _ := strings.Cut(suffix, "/")
+
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 1 || (len(resource) > 0 && resource != "audio") {
+			api.exitWithError(w, http.StatusNotFound, "Invalid call id")
+			return
+		}
+
+		var access *Access
+
+		if token := r.URL.Query().Get("token"); len(token) > 0 && resource == "audio" {
+			if err = VerifyAudioToken(api.Controller.Options.secret, uint(i), token); err != nil {
+				api.exitWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+		} else if access, err = api.getAccess(r); err != nil {
+			api.exitWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		call, err := api.Controller.Calls.GetCall(uint(i), api.Controller.Database)
+		if err != nil {
+			api.exitWithError(w, http.StatusExpectationFailed, err.Error())
+			return
+		}
+
+		if call.System == 0 || (access != nil && !access.HasAccess(call)) {
+			api.exitWithError(w, http.StatusNotFound, "Call not found")
+			return
+		}
+
+		if resource == "audio" {
+			api.serveAudio(w, r, call)
+			return
+		}
+
+		api.Controller.populateCall(call)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if b, err := json.Marshal(call.getMetadata()); err == nil {
+			w.Write(b)
+		} else {
+			api.exitWithError(w, http.StatusExpectationFailed, err.Error())
+		}
+
+		return
+	}
+
+	access, err := api.getAccess(r)
+	if err != nil {
+		api.exitWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	client := &Client{Controller: api.Controller}
+	client.SetAccess(access)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	searchOptions := CallsSearchOptions{searchPatchedTalkgroups: api.Controller.Options.SearchPatchedTalkgroups}
+	if err := searchOptions.fromQuery(r.URL.Query()); err != nil {
+		api.exitWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client.SystemsMap = api.Controller.Systems.GetScopedSystems(client, api.Controller.Groups, api.Controller.Tags, api.Controller.Options.SortTalkgroups)
+	client.GroupsMap = api.Controller.Groups.GetGroupsMap(&client.SystemsMap)
+	client.TagsMap = api.Controller.Tags.GetTagsMap(&client.SystemsMap)
+
+	searchResults, err := api.Controller.Calls.Search(&searchOptions, client)
+	if err != nil {
+		api.exitWithError(w, http.StatusExpectationFailed, err.Error())
+		return
+	}
+
+	if b, err := json.Marshal(searchResults); err == nil {
+		w.Write(b)
+	} else {
+		api.exitWithError(w, http.StatusExpectationFailed, err.Error())
+	}
+}
+
+func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var (
+			call    = NewCall()
+			key     string
+			limited *Apikey
+		)
+
+		if !api.checkIpRateLimit(w, r) {
+			return
+		}
+
+		if apikey, ok := api.Controller.Apikeys.GetApikey(r.Header.Get(ApikeyHeader)); ok {
+			if !api.checkApikeyRateLimit(w, r, apikey) {
+				return
+			}
+			key = r.Header.Get(ApikeyHeader)
+			limited = apikey
+		}
+
 		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 		if err != nil {
 			api.exitWithError(w, http.StatusBadRequest, "Invalid content-type")
@@ -51,7 +376,11 @@ func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		mr := multipart.NewReader(r.Body, params["boundary"])
+		digest := sha256.New()
+
+		body := io.TeeReader(r.Body, digest)
+
+		mr := multipart.NewReader(body, params["boundary"])
 
 		for {
 			p, err := mr.NextPart()
@@ -71,17 +400,39 @@ func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
 			switch p.FormName() {
 			case "key":
 				key = string(b)
+
+				// the key part comes before the audio, so a throttled key does not get the whole body read
+				if limited == nil {
+					if apikey, ok := api.Controller.Apikeys.GetApikey(key); ok {
+						if !api.checkApikeyRateLimit(w, r, apikey) {
+							return
+						}
+						limited = apikey
+					}
+				}
 			default:
 				ParseMultipartContent(call, p, b)
 			}
 		}
 
+		io.Copy(io.Discard, body)
+
+		apikey, err := api.getUploadApikey(r, key, digest.Sum(nil))
+		if err != nil {
+			api.exitWithError(w, http.StatusUnauthorized, fmt.Sprintf("Invalid signature: %s\n", err.Error()))
+			return
+		}
+
+		if apikey != limited && !api.checkApikeyRateLimit(w, r, apikey) {
+			return
+		}
+
 		if call.AudioUrl != "" {
 			call.Audio = nil
 		}
 
 		if ok, err := call.IsValid(); ok {
-			api.HandleCall(key, call, w)
+			api.HandleCall(apikey, call, w, r)
 		} else {
 			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s\n", err.Error()))
 		}
@@ -92,13 +443,34 @@ func (api *Api) CallUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (api *Api) HandleCall(key string, call *Call, w http.ResponseWriter) {
+func (api *Api) HandleCall(apikey *Apikey, call *Call, w http.ResponseWriter, r *http.Request) {
 	msg := []byte(fmt.Sprintf("Invalid API key for system %v talkgroup %v.\n", call.System, call.Talkgroup))
 
-	if apikey, ok := api.Controller.Apikeys.GetApikey(key); ok {
+	switch v := call.Provenance.(type) {
+	case []string:
+		for _, hop := range v {
+			if hop == api.Controller.Options.serverId {
+				api.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("newcall: system=%v talkgroup=%v file=%v loop detected via %s", call.System, call.Talkgroup, call.AudioName, strings.Join(v, " > ")))
+				api.exitWithError(w, http.StatusConflict, "Call loop detected.\n")
+				return
+			}
+		}
+	}
+
+	if apikey != nil {
+		ip := GetRemoteAddr(r)
+
+		if err := apikey.Authorize(ApikeyScopeUpload, ip); err != nil {
+			api.exitWithError(w, http.StatusUnauthorized, fmt.Sprintf("Invalid API key: %s.\n", err.Error()))
+			return
+		}
+
 		if apikey.HasAccess(call) {
+			call.IngestSource = fmt.Sprintf("%s:%s", IngestSourceApikey, apikey.Ident)
 			api.Controller.Ingest <- call
 
+			api.Controller.Apikeys.Touch(apikey, ip)
+
 		} else {
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write(msg)
@@ -118,10 +490,23 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 	switch r.Method {
 	case http.MethodPost:
 		var (
-			call = NewCall()
-			key  string
+			call    = NewCall()
+			key     string
+			limited *Apikey
 		)
 
+		if !api.checkIpRateLimit(w, r) {
+			return
+		}
+
+		if apikey, ok := api.Controller.Apikeys.GetApikey(r.Header.Get(ApikeyHeader)); ok {
+			if !api.checkApikeyRateLimit(w, r, apikey) {
+				return
+			}
+			key = r.Header.Get(ApikeyHeader)
+			limited = apikey
+		}
+
 		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 		if err != nil {
 			api.exitWithError(w, http.StatusBadRequest, "Invalid content-type")
@@ -133,7 +518,11 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 			return
 		}
 
-		mr := multipart.NewReader(r.Body, params["boundary"])
+		digest := sha256.New()
+
+		body := io.TeeReader(r.Body, digest)
+
+		mr := multipart.NewReader(body, params["boundary"])
 
 		parts := map[*multipart.Part][]byte{}
 
@@ -155,6 +544,16 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 			switch p.FormName() {
 			case "key":
 				key = string(b)
+
+				// the key part comes before the audio, so a throttled key does not get the whole body read
+				if limited == nil {
+					if apikey, ok := api.Controller.Apikeys.GetApikey(key); ok {
+						if !api.checkApikeyRateLimit(w, r, apikey) {
+							return
+						}
+						limited = apikey
+					}
+				}
 			case "meta":
 				if err := ParseTrunkRecorderMeta(call, b); err != nil {
 					api.exitWithError(w, http.StatusExpectationFailed, "Invalid call data")
@@ -169,12 +568,24 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 			ParseMultipartContent(call, p, b)
 		}
 
+		io.Copy(io.Discard, body)
+
+		apikey, err := api.getUploadApikey(r, key, digest.Sum(nil))
+		if err != nil {
+			api.exitWithError(w, http.StatusUnauthorized, fmt.Sprintf("Invalid signature: %s\n", err.Error()))
+			return
+		}
+
+		if apikey != limited && !api.checkApikeyRateLimit(w, r, apikey) {
+			return
+		}
+
 		if call.AudioUrl != "" {
 			call.Audio = nil
 		}
 
 		if ok, err := call.IsValid(); ok {
-			api.HandleCall(key, call, w)
+			api.HandleCall(apikey, call, w, r)
 
 		} else {
 			api.exitWithError(w, http.StatusExpectationFailed, fmt.Sprintf("Incomplete call data: %s\n", err.Error()))
@@ -186,6 +597,169 @@ func (api *Api) TrunkRecorderCallUploadHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
+func (api *Api) GetAudioUrl(call *Call, r *http.Request) string {
+	var id uint
+
+	switch v := call.Id.(type) {
+	case uint:
+		id = v
+	}
+
+	token := GetAudioToken(api.Controller.Options.secret, id, time.Now().Add(audioTokenTtl))
+
+	return fmt.Sprintf("%s/api/calls/%d/audio?token=%s", api.getBaseUrl(r), id, token)
+}
+
+func (api *Api) getAccess(r *http.Request) (*Access, error) {
+	if key := r.Header.Get(ApikeyHeader); len(key) > 0 {
+		apikey, ok := api.Controller.Apikeys.GetApikey(key)
+		if !ok {
+			return nil, errors.New("invalid api key")
+		}
+
+		if err := apikey.Authorize(ApikeyScopeRead, GetRemoteAddr(r)); err != nil {
+			return nil, err
+		}
+
+		return &Access{Ident: apikey.Ident, Systems: apikey.Systems}, nil
+	}
+
+	if code := r.Header.Get(AccessCodeHeader); len(code) > 0 {
+		access, ok := api.Controller.Accesses.GetAccess(code)
+		if !ok {
+			api.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("invalid access code %s for ip %s", code, GetRemoteAddr(r)))
+			return nil, errors.New("invalid access code")
+		}
+
+		if access.HasExpired() {
+			return nil, errors.New("access code expired")
+		}
+
+		return access, nil
+	}
+
+	if api.Controller.Accesses.IsRestricted() {
+		return nil, errors.New("api key or access code required")
+	}
+
+	return NewAccess(), nil
+}
+
+func (api *Api) getBaseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+
+	host := r.Host
+	if h := r.Header.Get("X-Forwarded-Host"); len(h) > 0 {
+		host = h
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+func (api *Api) serveAudio(w http.ResponseWriter, r *http.Request, call *Call) {
+	if len(call.AudioUrl) > 0 {
+		http.Redirect(w, r, call.AudioUrl, http.StatusFound)
+		return
+	}
+
+	if len(call.Audio) == 0 {
+		api.exitWithError(w, http.StatusNotFound, "No audio")
+		return
+	}
+
+	digest := sha256.Sum256(call.Audio)
+
+	switch v := call.AudioType.(type) {
+	case string:
+		if len(v) > 0 {
+			w.Header().Set("Content-Type", v)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(digest[:16])))
+
+	var name string
+
+	switch v := call.AudioName.(type) {
+	case string:
+		name = v
+	}
+
+	http.ServeContent(w, r, name, call.DateTime, bytes.NewReader(call.Audio))
+}
+
+func (api *Api) checkApikeyRateLimit(w http.ResponseWriter, r *http.Request, apikey *Apikey) bool {
+	if apikey == nil {
+		return true
+	}
+
+	rate := api.Controller.Options.UploadRateLimit
+
+	switch v := apikey.RateLimit.(type) {
+	case uint:
+		if v > 0 {
+			rate = v
+		}
+	}
+
+	return api.checkRateLimit(w, fmt.Sprintf("apikey:%s", apikey.Key), rate, fmt.Sprintf("api key %s from ip %s", apikey.Ident, GetRemoteAddr(r)))
+}
+
+func (api *Api) checkIpRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	ip := GetRemoteAddr(r)
+
+	return api.checkRateLimit(w, fmt.Sprintf("ip:%s", ip), api.Controller.Options.UploadIpRateLimit, fmt.Sprintf("ip %s", ip))
+}
+
+func (api *Api) checkRateLimit(w http.ResponseWriter, bucket string, rate uint, label string) bool {
+	ok, retryAfter, log, throttled := api.rateLimiter.Allow(bucket, rate, api.Controller.Options.UploadRateLimitBurst)
+	if ok {
+		return true
+	}
+
+	if log {
+		api.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("api: upload rate limit exceeded for %s, %v requests throttled", label, throttled))
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte("Too many requests.\n"))
+
+	return false
+}
+
+// getUploadApikey resolves the api key of an upload, either from the signing key id of a signed
+// request or from the key sent in clear. It returns nil when the key is unknown.
+func (api *Api) getUploadApikey(r *http.Request, key string, digest []byte) (*Apikey, error) {
+	if len(r.Header.Get(SignatureHeader)) == 0 {
+		apikey, ok := api.Controller.Apikeys.GetApikey(key)
+		if !ok {
+			return nil, nil
+		}
+
+		if apikey.RequireSignature {
+			return nil, errors.New("signature required")
+		}
+
+		return apikey, nil
+	}
+
+	apikey, ok := api.Controller.Apikeys.GetApikeyByHash(r.Header.Get(SignatureKeyIdHeader))
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if err := VerifySignature(r.Header, GetSigningSecret(api.Controller.Options.secret, apikey.Key), digest, api.signatureNonces); err != nil {
+		return nil, err
+	}
+
+	return apikey, nil
+}
+
 func (api *Api) exitWithError(w http.ResponseWriter, status int, message string) {
 	api.Controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("api: %s", message))
 
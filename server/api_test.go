@@ -0,0 +1,465 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestApi(t *testing.T, rateLimit uint) *Api {
+	t.Helper()
+
+	controller := newTestController(t)
+	controller.Options.UploadRateLimit = rateLimit
+	controller.Options.UploadRateLimitBurst = 1
+	controller.Apikeys.List = []*Apikey{
+		(&Apikey{}).FromMap(map[string]any{"_id": float64(1), "ident": "inherit", "rateLimit": float64(0), "systems": "*"}),
+		(&Apikey{}).FromMap(map[string]any{"_id": float64(2), "ident": "own", "rateLimit": float64(1000), "systems": "*"}),
+	}
+	controller.Apikeys.List[0].Key = HashApikey("inherit")
+	controller.Apikeys.List[1].Key = HashApikey("own")
+
+	return NewApi(controller)
+}
+
+func TestApiBroadcastifyRateLimit(t *testing.T) {
+	api := newTestApi(t, 1)
+
+	post := func(key string) int {
+		var buf bytes.Buffer
+
+		mw := multipart.NewWriter(&buf)
+		mw.WriteField("apiKey", key)
+		mw.WriteField("systemId", "1")
+		mw.WriteField("tg", "100")
+		mw.WriteField("ts", "1700000000")
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/broadcastify-call-upload", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		res := httptest.NewRecorder()
+		api.BroadcastifyCallUploadHandler(res, req)
+
+		return res.Code
+	}
+
+	if code := post("inherit"); code != http.StatusOK {
+		t.Fatalf("first upload got status %d", code)
+	}
+
+	// a rate limit of 0 inherits the global limit instead of lifting it
+	if code := post("inherit"); code != http.StatusTooManyRequests {
+		t.Fatalf("second upload got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	api.Controller.Options.UploadIpRateLimit = 1
+	api.rateLimiter = NewRateLimiter()
+
+	if code := post("own"); code != http.StatusOK {
+		t.Fatalf("first upload got status %d", code)
+	}
+
+	if code := post("own"); code != http.StatusTooManyRequests {
+		t.Fatalf("ip limit not applied, got status %d", code)
+	}
+}
+
+func TestApiBroadcastifyCallUpload(t *testing.T) {
+	api := newTestApi(t, 0)
+
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("apiKey", "own")
+	mw.WriteField("callDuration", "4.2")
+	mw.WriteField("enc", "m4a")
+	mw.WriteField("freq", "851.0125")
+	mw.WriteField("patches", "200,300")
+	mw.WriteField("src", "4242")
+	mw.WriteField("systemId", "1")
+	mw.WriteField("tg", "100")
+	mw.WriteField("ts", "1700000000")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/broadcastify-call-upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	res := httptest.NewRecorder()
+	api.BroadcastifyCallUploadHandler(res, req)
+
+	// the slot request answers with the url where the audio goes
+	slot, ok := strings.CutPrefix(res.Body.String(), "0 http://example.com/api/broadcastify-call-upload/")
+	if res.Code != http.StatusOK || !ok || len(slot) == 0 {
+		t.Fatalf("slot request got %d %q", res.Code, res.Body.String())
+	}
+
+	if len(api.Controller.Ingest) != 0 {
+		t.Fatal("call ingested before its audio")
+	}
+
+	audio := bytes.Repeat([]byte("audio"), 20)
+
+	put := func() int {
+		req := httptest.NewRequest(http.MethodPut, "/api/broadcastify-call-upload/"+slot, bytes.NewReader(audio))
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		res := httptest.NewRecorder()
+		api.BroadcastifyCallUploadHandler(res, req)
+
+		return res.Code
+	}
+
+	if code := put(); code != http.StatusOK {
+		t.Fatalf("audio upload got status %d", code)
+	}
+
+	if len(api.Controller.Ingest) != 1 {
+		t.Fatalf("got %d ingested calls, want 1", len(api.Controller.Ingest))
+	}
+
+	call := <-api.Controller.Ingest
+
+	if call.System != 1 || call.Talkgroup != 100 || call.DateTime.Unix() != 1700000000 {
+		t.Errorf("got system %d talkgroup %d date %v", call.System, call.Talkgroup, call.DateTime)
+	}
+	if call.Frequency != uint(851012500) || call.Source != 4242 {
+		t.Errorf("got frequency %v source %v", call.Frequency, call.Source)
+	}
+	if patches, ok := call.Patches.([]uint); !ok || len(patches) != 2 || patches[0] != 200 || patches[1] != 300 {
+		t.Errorf("got patches %v", call.Patches)
+	}
+	if !bytes.Equal(call.Audio, audio) || call.AudioName != "1-100-1700000000.m4a" || call.AudioType != "audio/mp4" {
+		t.Errorf("got audio %q name %v type %v", call.Audio, call.AudioName, call.AudioType)
+	}
+	if call.IngestSource != IngestSourceApikey+":own" {
+		t.Errorf("got ingest source %v", call.IngestSource)
+	}
+
+	// a slot takes a single upload
+	if code := put(); code != http.StatusNotFound {
+		t.Errorf("second audio upload got status %d", code)
+	}
+}
+
+func TestApiCallUploadRateLimitBeforeBody(t *testing.T) {
+	api := newTestApi(t, 1)
+
+	upload := func() (int, int64) {
+		pr, pw := io.Pipe()
+
+		mw := multipart.NewWriter(pw)
+
+		var written atomic.Int64
+
+		go func() {
+			mw.WriteField("key", "inherit")
+			w, _ := mw.CreateFormFile("audio", "call.m4a")
+			for i := 0; i < 64; i++ {
+				n, err := w.Write(bytes.Repeat([]byte{0}, 64<<10))
+				if err != nil {
+					return
+				}
+				written.Add(int64(n))
+			}
+			mw.WriteField("system", "1")
+			mw.WriteField("talkgroup", "100")
+			mw.WriteField("dateTime", "1700000000")
+			mw.Close()
+			pw.Close()
+		}()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/call-upload", pr)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		res := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			api.CallUploadHandler(res, req)
+			pr.Close()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler did not return")
+		}
+
+		return res.Code, written.Load()
+	}
+
+	if code, _ := upload(); code != http.StatusOK {
+		t.Fatalf("first upload got status %d", code)
+	}
+
+	code, written := upload()
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("second upload got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	if written >= 64*64<<10 {
+		t.Errorf("throttled upload body was read in full")
+	}
+}
+
+func TestApiHandleCallLoop(t *testing.T) {
+	api := newTestApi(t, 0)
+
+	if err := api.Controller.Options.Read(api.Controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	apikey, _ := api.Controller.Apikeys.GetApikey("own")
+
+	handle := func(provenance []string) int {
+		call := newTestDownstreamCall()
+		call.Provenance = provenance
+
+		res := httptest.NewRecorder()
+		api.HandleCall(apikey, call, res, httptest.NewRequest(http.MethodPost, "/api/call-upload", nil))
+
+		return res.Code
+	}
+
+	// a call that already went through this server came back around
+	if code := handle([]string{"other server", api.Controller.Options.serverId}); code != http.StatusConflict {
+		t.Errorf("looped call got status %d, want %d", code, http.StatusConflict)
+	}
+
+	if len(api.Controller.Ingest) != 0 {
+		t.Fatal("looped call ingested")
+	}
+
+	if code := handle([]string{"other server"}); code != http.StatusOK {
+		t.Errorf("relayed call got status %d", code)
+	}
+
+	if len(api.Controller.Ingest) != 1 {
+		t.Fatalf("got %d ingested calls, want 1", len(api.Controller.Ingest))
+	}
+}
+
+// newTestCallsApi serves three calls, two on system 1 and one on system 2, to access codes and
+// api keys scoped to either system
+func newTestCallsApi(t *testing.T) *Api {
+	t.Helper()
+
+	api := newTestApi(t, 0)
+	controller := api.Controller
+
+	for i, tc := range []struct{ system, talkgroup uint }{{1, 150}, {1, 151}, {2, 250}} {
+		call := newTestDownstreamCall()
+		call.Audio = make([]byte, 100)
+		call.AudioName = fmt.Sprintf("call-%d.m4a", i+1)
+		call.AudioType = "audio/mp4"
+		call.DateTime = call.DateTime.Add(time.Duration(i) * time.Second)
+		call.System = tc.system
+		call.Talkgroup = tc.talkgroup
+
+		for j := range call.Audio {
+			call.Audio[j] = byte(j)
+		}
+
+		if _, err := controller.Calls.WriteCall(call, controller.Database); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controller.Accesses.FromMap([]any{
+		map[string]any{"code": "system-1", "ident": "system 1", "systems": []any{map[string]any{"id": float64(1), "talkgroups": "*"}}},
+		map[string]any{"code": "expired", "expiration": "2020-01-01T00:00:00Z", "ident": "expired", "systems": "*"},
+	})
+
+	if err := controller.Accesses.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Accesses.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	controller.Apikeys.FromMap([]any{
+		map[string]any{"_id": float64(1), "ident": "reader", "key": "reader", "scopes": []any{ApikeyScopeRead}, "systems": []any{map[string]any{"id": float64(2), "talkgroups": []any{float64(250)}}}},
+		map[string]any{"_id": float64(2), "ident": "uploader", "key": "uploader", "systems": "*"},
+	})
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	return api
+}
+
+func getTestCalls(api *Api, target string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if len(header) > 0 {
+		req.Header.Set(header, value)
+	}
+
+	res := httptest.NewRecorder()
+	api.CallsHandler(res, req)
+
+	return res
+}
+
+func TestApiCallsSearch(t *testing.T) {
+	api := newTestCallsApi(t)
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		header string
+		value  string
+		code   int
+		count  uint
+	}{
+		{name: "no credentials", code: http.StatusUnauthorized},
+		{name: "unknown access code", header: AccessCodeHeader, value: "nope", code: http.StatusUnauthorized},
+		{name: "expired access code", header: AccessCodeHeader, value: "expired", code: http.StatusUnauthorized},
+		{name: "access code", header: AccessCodeHeader, value: "system-1", code: http.StatusOK, count: 2},
+		{name: "talkgroup filter", query: "?system=1&talkgroup=151", header: AccessCodeHeader, value: "system-1", code: http.StatusOK, count: 1},
+		{name: "denied system", query: "?system=2", header: AccessCodeHeader, value: "system-1", code: http.StatusOK, count: 0},
+		{name: "date filter", query: "?dateFrom=2023-11-14T22:13:20.5Z", header: AccessCodeHeader, value: "system-1", code: http.StatusOK, count: 1},
+		{name: "invalid filter", query: "?system=one", header: AccessCodeHeader, value: "system-1", code: http.StatusBadRequest},
+		{name: "api key", header: ApikeyHeader, value: "reader", code: http.StatusOK, count: 1},
+		{name: "api key without read scope", header: ApikeyHeader, value: "uploader", code: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := getTestCalls(api, "/api/calls"+tc.query, tc.header, tc.value)
+			if res.Code != tc.code {
+				t.Fatalf("got status %d, want %d, %s", res.Code, tc.code, res.Body.String())
+			}
+
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			results := CallsSearchResults{}
+			if err := json.Unmarshal(res.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if results.Count != tc.count || len(results.Results) != int(tc.count) {
+				t.Errorf("got %d calls, want %d", results.Count, tc.count)
+			}
+		})
+	}
+}
+
+func TestApiCallsGet(t *testing.T) {
+	api := newTestCallsApi(t)
+
+	res := getTestCalls(api, "/api/calls/1", AccessCodeHeader, "system-1")
+	if res.Code != http.StatusOK {
+		t.Fatalf("got status %d", res.Code)
+	}
+
+	m := map[string]any{}
+	if err := json.Unmarshal(res.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["system"] != float64(1) || m["talkgroup"] != float64(150) {
+		t.Errorf("got metadata %v", m)
+	}
+
+	// a call outside the scope is not found rather than forbidden
+	for _, target := range []string{"/api/calls/3", "/api/calls/3/audio"} {
+		if res := getTestCalls(api, target, AccessCodeHeader, "system-1"); res.Code != http.StatusNotFound {
+			t.Errorf("%s got status %d", target, res.Code)
+		}
+	}
+
+	if res := getTestCalls(api, "/api/calls/3", ApikeyHeader, "reader"); res.Code != http.StatusOK {
+		t.Errorf("api key got status %d", res.Code)
+	}
+
+	for _, target := range []string{"/api/calls/one", "/api/calls/1/image", "/api/calls/9"} {
+		if res := getTestCalls(api, target, AccessCodeHeader, "system-1"); res.Code != http.StatusNotFound {
+			t.Errorf("%s got status %d", target, res.Code)
+		}
+	}
+}
+
+func TestApiCallsAudio(t *testing.T) {
+	api := newTestCallsApi(t)
+	secret := api.Controller.Options.secret
+
+	get := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		res := httptest.NewRecorder()
+		api.CallsHandler(res, req)
+
+		return res
+	}
+
+	res := get("/api/calls/1/audio", map[string]string{AccessCodeHeader: "system-1"})
+	if res.Code != http.StatusOK || res.Body.Len() != 100 || res.Header().Get("Content-Type") != "audio/mp4" {
+		t.Fatalf("got status %d, %d bytes of %s", res.Code, res.Body.Len(), res.Header().Get("Content-Type"))
+	}
+
+	etag := res.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("no etag")
+	}
+
+	res = get("/api/calls/1/audio", map[string]string{AccessCodeHeader: "system-1", "Range": "bytes=10-19"})
+	if res.Code != http.StatusPartialContent || res.Header().Get("Content-Range") != "bytes 10-19/100" {
+		t.Fatalf("range got status %d, %s", res.Code, res.Header().Get("Content-Range"))
+	}
+	if b := res.Body.Bytes(); len(b) != 10 || b[0] != 10 || b[9] != 19 {
+		t.Errorf("range got %v", b)
+	}
+
+	if res = get("/api/calls/1/audio", map[string]string{AccessCodeHeader: "system-1", "If-None-Match": etag}); res.Code != http.StatusNotModified {
+		t.Errorf("matching etag got status %d", res.Code)
+	}
+
+	// a signed url stands in for the credentials of an audio player
+	token := GetAudioToken(secret, 1, time.Now().Add(time.Minute))
+
+	if res = get("/api/calls/1/audio?token="+token, nil); res.Code != http.StatusOK {
+		t.Errorf("token got status %d", res.Code)
+	}
+
+	for name, target := range map[string]string{
+		"expired token":      "/api/calls/1/audio?token=" + GetAudioToken(secret, 1, time.Now().Add(-time.Minute)),
+		"token of another":   "/api/calls/2/audio?token=" + token,
+		"token for metadata": "/api/calls/1?token=" + token,
+		"forged token":       "/api/calls/1/audio?token=" + GetAudioToken("another secret", 1, time.Now().Add(time.Minute)),
+	} {
+		if res = get(target, nil); res.Code != http.StatusUnauthorized {
+			t.Errorf("%s got status %d", name, res.Code)
+		}
+	}
+}
@@ -16,22 +16,44 @@
 package main
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	ApikeyHeader        = "X-Api-Key"
+	ApikeyScopeAdmin    = "admin"
+	ApikeyScopeRead     = "read"
+	ApikeyScopeUpload   = "upload"
+	apikeyHashPrefix    = "sha256:"
+	apikeyTouchInterval = 10 * time.Second
+)
+
 type Apikey struct {
-	Id       any    `json:"_id"`
-	Disabled bool   `json:"disabled"`
-	Ident    string `json:"ident"`
-	Key      string `json:"key"`
-	Order    any    `json:"order"`
-	Systems  any    `json:"systems"`
+	Id               any    `json:"_id"`
+	AllowedCidrs     any    `json:"allowedCidrs"`
+	CallsCount       uint   `json:"callsCount"`
+	Disabled         bool   `json:"disabled"`
+	Expiration       any    `json:"expiration"`
+	Ident            string `json:"ident"`
+	Key              string `json:"-"`
+	LastUsedAt       any    `json:"lastUsedAt"`
+	LastUsedIp       any    `json:"lastUsedIp"`
+	Order            any    `json:"order"`
+	RateLimit        any    `json:"rateLimit"`
+	RequireSignature bool   `json:"requireSignature"`
+	Scopes           any    `json:"scopes"`
+	Systems          any    `json:"systems"`
 }
 
 func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
@@ -40,11 +62,50 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 		apikey.Id = uint(v)
 	}
 
+	switch v := m["allowedCidrs"].(type) {
+	case []any:
+		cidrs := []string{}
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				if s = strings.TrimSpace(s); len(s) > 0 {
+					cidrs = append(cidrs, s)
+				}
+			}
+		}
+		apikey.AllowedCidrs = cidrs
+	case string:
+		cidrs := []string{}
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				cidrs = append(cidrs, s)
+			}
+		}
+		apikey.AllowedCidrs = cidrs
+	case nil:
+		if _, ok := m["allowedCidrs"]; ok {
+			apikey.AllowedCidrs = nil
+		}
+	}
+
 	switch v := m["disabled"].(type) {
 	case bool:
 		apikey.Disabled = v
 	}
 
+	switch v := m["expiration"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			apikey.Expiration = t.UTC()
+		} else if len(v) == 0 {
+			apikey.Expiration = nil
+		}
+	case nil:
+		if _, ok := m["expiration"]; ok {
+			apikey.Expiration = nil
+		}
+	}
+
 	switch v := m["ident"].(type) {
 	case string:
 		apikey.Ident = v
@@ -52,7 +113,9 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 
 	switch v := m["key"].(type) {
 	case string:
-		apikey.Key = v
+		if len(v) > 0 {
+			apikey.Key = v
+		}
 	}
 
 	switch v := m["order"].(type) {
@@ -60,6 +123,41 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 		apikey.Order = uint(v)
 	}
 
+	// a rate limit of 0 inherits the global upload rate limit
+	switch v := m["rateLimit"].(type) {
+	case float64:
+		if v > 0 {
+			apikey.RateLimit = uint(v)
+		} else {
+			apikey.RateLimit = nil
+		}
+	case nil:
+		if _, ok := m["rateLimit"]; ok {
+			apikey.RateLimit = nil
+		}
+	}
+
+	switch v := m["requireSignature"].(type) {
+	case bool:
+		apikey.RequireSignature = v
+	}
+
+	switch v := m["scopes"].(type) {
+	case []any:
+		scopes := []string{}
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				scopes = append(scopes, s)
+			}
+		}
+		apikey.Scopes = scopes
+	case nil:
+		if _, ok := m["scopes"]; ok {
+			apikey.Scopes = nil
+		}
+	}
+
 	switch v := m["systems"].(type) {
 	case []any:
 		if b, err := json.Marshal(v); err == nil {
@@ -72,6 +170,22 @@ func (apikey *Apikey) FromMap(m map[string]any) *Apikey {
 	return apikey
 }
 
+func (apikey *Apikey) Authorize(scope string, ip string) error {
+	if apikey.HasExpired() {
+		return errors.New("api key expired")
+	}
+
+	if !apikey.HasScope(scope) {
+		return fmt.Errorf("api key not allowed to %s", scope)
+	}
+
+	if !apikey.IsIpAllowed(ip) {
+		return fmt.Errorf("api key not allowed from ip %s", ip)
+	}
+
+	return nil
+}
+
 func (apikey *Apikey) HasAccess(call *Call) bool {
 	switch v := apikey.Systems.(type) {
 	case []any:
@@ -110,28 +224,107 @@ func (apikey *Apikey) HasAccess(call *Call) bool {
 	return false
 }
 
+func (apikey *Apikey) HasExpired() bool {
+	switch v := apikey.Expiration.(type) {
+	case time.Time:
+		return !v.IsZero() && time.Now().After(v)
+	}
+
+	return false
+}
+
+func (apikey *Apikey) HasScope(scope string) bool {
+	switch v := apikey.Scopes.(type) {
+	case []string:
+		for _, s := range v {
+			if s == scope {
+				return true
+			}
+		}
+		return false
+	}
+
+	return scope == ApikeyScopeUpload
+}
+
+func (apikey *Apikey) IsIpAllowed(ip string) bool {
+	cidrs, ok := apikey.AllowedCidrs.([]string)
+	if !ok || len(cidrs) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(strings.Trim(ip, "[]"))
+	if addr == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(cidr); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type Apikeys struct {
-	List  []*Apikey
-	mutex sync.Mutex
+	List    []*Apikey
+	mutex   sync.Mutex
+	touches map[uint]*apikeyTouch
+}
+
+type apikeyTouch struct {
+	count      uint
+	lastUsedAt time.Time
+	lastUsedIp string
 }
 
 func NewApikeys() *Apikeys {
 	return &Apikeys{
-		List:  []*Apikey{},
-		mutex: sync.Mutex{},
+		List:    []*Apikey{},
+		mutex:   sync.Mutex{},
+		touches: map[uint]*apikeyTouch{},
 	}
 }
 
+func (apikeys *Apikeys) Add(apikey *Apikey) *Apikeys {
+	apikeys.mutex.Lock()
+	defer apikeys.mutex.Unlock()
+
+	apikeys.List = append(apikeys.List, apikey)
+
+	return apikeys
+}
+
 func (apikeys *Apikeys) FromMap(f []any) *Apikeys {
 	apikeys.mutex.Lock()
 	defer apikeys.mutex.Unlock()
 
+	// the key hashes are never sent to the admin and older admin apps do not know about
+	// every field, start from the existing key so what is not sent back is kept
+	existing := map[uint]*Apikey{}
+	for _, apikey := range apikeys.List {
+		if id, ok := apikey.Id.(uint); ok {
+			existing[id] = apikey
+		}
+	}
+
 	apikeys.List = []*Apikey{}
 
 	for _, r := range f {
 		switch m := r.(type) {
 		case map[string]any:
 			apikey := &Apikey{}
+			switch id := m["_id"].(type) {
+			case float64:
+				if previous, ok := existing[uint(id)]; ok {
+					*apikey = *previous
+				}
+			}
 			apikey.FromMap(m)
 			apikeys.List = append(apikeys.List, apikey)
 		}
@@ -144,8 +337,42 @@ func (apikeys *Apikeys) GetApikey(key string) (apikey *Apikey, ok bool) {
 	apikeys.mutex.Lock()
 	defer apikeys.mutex.Unlock()
 
+	if len(key) == 0 {
+		return nil, false
+	}
+
+	hash := HashApikey(key)
+
+	for _, apikey := range apikeys.List {
+		if apikey.Key == hash && !apikey.Disabled {
+			return apikey, true
+		}
+	}
+	return nil, false
+}
+
+func (apikeys *Apikeys) GetApikeyByHash(hash string) (apikey *Apikey, ok bool) {
+	apikeys.mutex.Lock()
+	defer apikeys.mutex.Unlock()
+
+	if !strings.HasPrefix(hash, apikeyHashPrefix) {
+		return nil, false
+	}
+
+	for _, apikey := range apikeys.List {
+		if apikey.Key == hash && !apikey.Disabled {
+			return apikey, true
+		}
+	}
+	return nil, false
+}
+
+func (apikeys *Apikeys) GetApikeyById(id uint) (apikey *Apikey, ok bool) {
+	apikeys.mutex.Lock()
+	defer apikeys.mutex.Unlock()
+
 	for _, apikey := range apikeys.List {
-		if apikey.Key == key && !apikey.Disabled {
+		if apikey.Id == id {
 			return apikey, true
 		}
 	}
@@ -154,11 +381,20 @@ func (apikeys *Apikeys) GetApikey(key string) (apikey *Apikey, ok bool) {
 
 func (apikeys *Apikeys) Read(db *Database) error {
 	var (
-		err     error
-		id      sql.NullFloat64
-		order   sql.NullFloat64
-		rows    *sql.Rows
-		systems string
+		allowedCidrs     sql.NullString
+		callsCount       sql.NullFloat64
+		err              error
+		expiration       any
+		id               sql.NullFloat64
+		lastUsedAt       any
+		lastUsedIp       sql.NullString
+		order            sql.NullFloat64
+		rateLimit        sql.NullFloat64
+		requireSignature sql.NullBool
+		rows             *sql.Rows
+		scopes           sql.NullString
+		systems          string
+		unhashed         = []*Apikey{}
 	)
 
 	apikeys.mutex.Lock()
@@ -170,9 +406,9 @@ func (apikeys *Apikeys) Read(db *Database) error {
 		return fmt.Errorf("apikeys.read: %v", err)
 	}
 
-	q := "select `_id`, `disabled`, `ident`, `key`, `order`, `systems` from `rdioScannerApiKeys`"
+	q := "select `_id`, `allowedCidrs`, `callsCount`, `disabled`, `expiration`, `ident`, `key`, `lastUsedAt`, `lastUsedIp`, `order`, `rateLimit`, `requireSignature`, `scopes`, `systems` from `rdioScannerApiKeys`"
 	if db.Config.DbType == DbTypePostgresql {
-		q = "select _id, disabled, ident, key, \"order\", systems from rdioScannerApiKeys"
+		q = "select _id, allowedCidrs, callsCount, disabled, expiration, ident, key, lastUsedAt, lastUsedIp, \"order\", rateLimit, requireSignature, scopes, systems from rdioScannerApiKeys"
 	}
 	if rows, err = db.Sql.Query(q); err != nil {
 		return formatError(err)
@@ -181,7 +417,7 @@ func (apikeys *Apikeys) Read(db *Database) error {
 	for rows.Next() {
 		apikey := &Apikey{}
 
-		if err = rows.Scan(&id, &apikey.Disabled, &apikey.Ident, &apikey.Key, &order, &systems); err != nil {
+		if err = rows.Scan(&id, &allowedCidrs, &callsCount, &apikey.Disabled, &expiration, &apikey.Ident, &apikey.Key, &lastUsedAt, &lastUsedIp, &order, &rateLimit, &requireSignature, &scopes, &systems); err != nil {
 			break
 		}
 
@@ -189,6 +425,21 @@ func (apikeys *Apikeys) Read(db *Database) error {
 			apikey.Id = uint(id.Float64)
 		}
 
+		if allowedCidrs.Valid && len(allowedCidrs.String) > 0 {
+			var cidrs []string
+			if err := json.Unmarshal([]byte(allowedCidrs.String), &cidrs); err == nil {
+				apikey.AllowedCidrs = cidrs
+			}
+		}
+
+		if callsCount.Valid && callsCount.Float64 > 0 {
+			apikey.CallsCount = uint(callsCount.Float64)
+		}
+
+		if t, err := db.ParseDateTime(expiration); err == nil && !t.IsZero() {
+			apikey.Expiration = t
+		}
+
 		if len(apikey.Ident) == 0 {
 			apikey.Ident = defaults.apikey.ident
 		}
@@ -197,14 +448,49 @@ func (apikeys *Apikeys) Read(db *Database) error {
 			apikey.Key = uuid.New().String()
 		}
 
+		if len(apikey.Key) > 0 && !strings.HasPrefix(apikey.Key, apikeyHashPrefix) {
+			apikey.Key = HashApikey(apikey.Key)
+			unhashed = append(unhashed, apikey)
+		}
+
+		if t, err := db.ParseDateTime(lastUsedAt); err == nil && !t.IsZero() {
+			apikey.LastUsedAt = t
+		}
+
+		if lastUsedIp.Valid && len(lastUsedIp.String) > 0 {
+			apikey.LastUsedIp = lastUsedIp.String
+		}
+
 		if order.Valid && order.Float64 > 0 {
 			apikey.Order = uint(order.Float64)
 		}
 
+		if rateLimit.Valid && rateLimit.Float64 > 0 {
+			apikey.RateLimit = uint(rateLimit.Float64)
+		}
+
+		if requireSignature.Valid {
+			apikey.RequireSignature = requireSignature.Bool
+		}
+
+		if scopes.Valid && len(scopes.String) > 0 {
+			var s []string
+			if err := json.Unmarshal([]byte(scopes.String), &s); err == nil {
+				apikey.Scopes = s
+			}
+		}
+
 		if err = json.Unmarshal([]byte(systems), &apikey.Systems); err != nil {
 			apikey.Systems = []any{}
 		}
 
+		// usage not flushed yet
+		if touch, ok := apikeys.touches[uint(id.Float64)]; ok {
+			apikey.CallsCount += touch.count
+			apikey.LastUsedAt = touch.lastUsedAt
+			apikey.LastUsedIp = touch.lastUsedIp
+		}
+
 		apikeys.List = append(apikeys.List, apikey)
 	}
 
@@ -214,16 +500,74 @@ func (apikeys *Apikeys) Read(db *Database) error {
 		return formatError(err)
 	}
 
+	for _, apikey := range unhashed {
+		q := "update `rdioScannerApiKeys` set `key` = ? where `_id` = ?"
+		if db.Config.DbType == DbTypePostgresql {
+			q = "update rdioScannerApiKeys set key = $1 where _id = $2"
+		}
+		if _, err = db.Sql.Exec(q, apikey.Key, apikey.Id); err != nil {
+			return formatError(err)
+		}
+	}
+
+	return nil
+}
+
+// Touch records the usage of an api key in memory, FlushTouches writes it to the database.
+func (apikeys *Apikeys) Touch(apikey *Apikey, ip string) {
+	now := time.Now().UTC()
+
+	apikeys.mutex.Lock()
+	defer apikeys.mutex.Unlock()
+
+	apikey.CallsCount++
+	apikey.LastUsedAt = now
+	apikey.LastUsedIp = ip
+
+	id, ok := apikey.Id.(uint)
+	if !ok {
+		return
+	}
+
+	touch, ok := apikeys.touches[id]
+	if !ok {
+		touch = &apikeyTouch{}
+		apikeys.touches[id] = touch
+	}
+
+	touch.count++
+	touch.lastUsedAt = now
+	touch.lastUsedIp = ip
+}
+
+func (apikeys *Apikeys) FlushTouches(db *Database) error {
+	apikeys.mutex.Lock()
+	touches := apikeys.touches
+	apikeys.touches = map[uint]*apikeyTouch{}
+	apikeys.mutex.Unlock()
+
+	for id, touch := range touches {
+		q := "update `rdioScannerApiKeys` set `callsCount` = coalesce(`callsCount`, 0) + ?, `lastUsedAt` = ?, `lastUsedIp` = ? where `_id` = ?"
+		if db.Config.DbType == DbTypePostgresql {
+			q = "update rdioScannerApiKeys set callsCount = coalesce(callsCount, 0) + $1, lastUsedAt = $2, lastUsedIp = $3 where _id = $4"
+		}
+		if _, err := db.Sql.Exec(q, touch.count, touch.lastUsedAt, touch.lastUsedIp, id); err != nil {
+			return fmt.Errorf("apikeys.flushtouches: %v", err)
+		}
+	}
+
 	return nil
 }
 
 func (apikeys *Apikeys) Write(db *Database) error {
 	var (
-		count   uint
-		err     error
-		rows    *sql.Rows
-		rowIds  = []uint{}
-		systems any
+		allowedCidrs any
+		count        uint
+		err          error
+		rows         *sql.Rows
+		rowIds       = []uint{}
+		scopes       any
+		systems      any
 	)
 
 	apikeys.mutex.Lock()
@@ -287,6 +631,24 @@ func (apikeys *Apikeys) Write(db *Database) error {
 			systems = apikey.Systems
 		}
 
+		if len(apikey.Key) > 0 && !strings.HasPrefix(apikey.Key, apikeyHashPrefix) {
+			apikey.Key = HashApikey(apikey.Key)
+		}
+
+		allowedCidrs = nil
+		if apikey.AllowedCidrs != nil {
+			if b, err := json.Marshal(apikey.AllowedCidrs); err == nil {
+				allowedCidrs = string(b)
+			}
+		}
+
+		scopes = nil
+		if apikey.Scopes != nil {
+			if b, err := json.Marshal(apikey.Scopes); err == nil {
+				scopes = string(b)
+			}
+		}
+
 		q := "select count(*) from `rdioScannerApiKeys` where `_id` = ?"
 		if db.Config.DbType == DbTypePostgresql {
 			q = "select count(*) from rdioScannerApiKeys where _id = $1"
@@ -296,19 +658,19 @@ func (apikeys *Apikeys) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			q := "insert into `rdioScannerApiKeys` (`_id`, `disabled`, `ident`, `key`, `order`, `systems`) values (?, ?, ?, ?, ?, ?)"
+			q := "insert into `rdioScannerApiKeys` (`_id`, `allowedCidrs`, `disabled`, `expiration`, `ident`, `key`, `order`, `rateLimit`, `requireSignature`, `scopes`, `systems`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "insert into rdioScannerApiKeys (_id, disabled, ident, key, \"order\", systems) values ($1, $2, $3, $4, $5, $6)"
+				q = "insert into rdioScannerApiKeys (_id, allowedCidrs, disabled, expiration, ident, key, \"order\", rateLimit, requireSignature, scopes, systems) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
 			}
-			if _, err = db.Sql.Exec(q, apikey.Id, apikey.Disabled, apikey.Ident, apikey.Key, apikey.Order, systems); err != nil {
+			if _, err = db.Sql.Exec(q, apikey.Id, allowedCidrs, apikey.Disabled, apikey.Expiration, apikey.Ident, apikey.Key, apikey.Order, apikey.RateLimit, apikey.RequireSignature, scopes, systems); err != nil {
 				break
 			}
 		} else {
-			q := "update `rdioScannerApiKeys` set `_id` = ?, `disabled` = ?, `ident` = ?, `key` = ?, `order` = ?, `systems` = ? where `_id` = ?"
+			q := "update `rdioScannerApiKeys` set `_id` = ?, `allowedCidrs` = ?, `disabled` = ?, `expiration` = ?, `ident` = ?, `key` = ?, `order` = ?, `rateLimit` = ?, `requireSignature` = ?, `scopes` = ?, `systems` = ? where `_id` = ?"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "update rdioScannerApiKeys set _id = $1, disabled = $2, ident = $3, key = $4, \"order\" = $5, systems = $6 where _id = $7"
+				q = "update rdioScannerApiKeys set _id = $1, allowedCidrs = $2, disabled = $3, expiration = $4, ident = $5, key = $6, \"order\" = $7, rateLimit = $8, requireSignature = $9, scopes = $10, systems = $11 where _id = $12"
 			}
-			if _, err = db.Sql.Exec(q, apikey.Id, apikey.Disabled, apikey.Ident, apikey.Key, apikey.Order, systems, apikey.Id); err != nil {
+			if _, err = db.Sql.Exec(q, apikey.Id, allowedCidrs, apikey.Disabled, apikey.Expiration, apikey.Ident, apikey.Key, apikey.Order, apikey.RateLimit, apikey.RequireSignature, scopes, systems, apikey.Id); err != nil {
 				break
 			}
 		}
@@ -320,3 +682,9 @@ func (apikeys *Apikeys) Write(db *Database) error {
 
 	return nil
 }
+
+func HashApikey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return apikeyHashPrefix + hex.EncodeToString(hash[:])
+}
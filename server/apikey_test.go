@@ -0,0 +1,186 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApikeysKeepHashesOutOfJson(t *testing.T) {
+	controller := newTestController(t)
+
+	controller.Apikeys.FromMap([]any{map[string]any{"_id": float64(1), "ident": "recorder", "key": "secret", "systems": "*"}})
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(controller.Apikeys.List)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), apikeyHashPrefix) || strings.Contains(string(b), `"key"`) {
+		t.Fatalf("key exposed in %s", b)
+	}
+
+	// the admin sends the keys back without them
+	f := []any{}
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	controller.Apikeys.FromMap(f)
+
+	if err = controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err = controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := controller.Apikeys.GetApikey("secret"); !ok {
+		t.Fatal("key lost after an admin round trip")
+	}
+}
+
+func TestApikeysTouch(t *testing.T) {
+	controller := newTestController(t)
+
+	controller.Apikeys.FromMap([]any{map[string]any{"_id": float64(1), "ident": "recorder", "key": "secret", "systems": "*"}})
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	apikey, _ := controller.Apikeys.GetApikey("secret")
+
+	for i := 0; i < 3; i++ {
+		controller.Apikeys.Touch(apikey, "192.0.2.1")
+	}
+
+	// pending usage survives a reload before it is flushed
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if apikey, _ = controller.Apikeys.GetApikey("secret"); apikey.CallsCount != 3 {
+		t.Fatalf("got %d calls before flush, want 3", apikey.CallsCount)
+	}
+
+	if err := controller.Apikeys.FlushTouches(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	controller.Apikeys.Touch(apikey, "192.0.2.2")
+
+	if err := controller.Apikeys.FlushTouches(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	if apikey, _ = controller.Apikeys.GetApikey("secret"); apikey.CallsCount != 4 || apikey.LastUsedIp != "192.0.2.2" {
+		t.Fatalf("unexpected usage %d calls from %v", apikey.CallsCount, apikey.LastUsedIp)
+	}
+}
+
+func TestApikeysKeepRestrictionsOnAdminSave(t *testing.T) {
+	controller := newTestController(t)
+
+	expiration := time.Now().Add(7 * 24 * time.Hour).UTC().Truncate(time.Second)
+
+	controller.Apikeys.FromMap([]any{map[string]any{
+		"_id":              float64(1),
+		"allowedCidrs":     []any{"192.0.2.0/24"},
+		"expiration":       expiration.Format(time.RFC3339),
+		"ident":            "recorder",
+		"key":              "secret",
+		"rateLimit":        float64(5),
+		"requireSignature": true,
+		"scopes":           []any{ApikeyScopeUpload, ApikeyScopeRead},
+		"systems":          "*",
+	}})
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	// what the stock admin app sends back
+	controller.Apikeys.FromMap([]any{map[string]any{
+		"_id":      float64(1),
+		"disabled": false,
+		"ident":    "recorder renamed",
+		"key":      nil,
+		"order":    float64(1),
+		"systems":  "*",
+	}})
+
+	if err := controller.Apikeys.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Apikeys.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	apikey, ok := controller.Apikeys.GetApikey("secret")
+	if !ok {
+		t.Fatal("key lost after an admin save")
+	}
+
+	if apikey.Ident != "recorder renamed" {
+		t.Errorf("ident not updated, got %s", apikey.Ident)
+	}
+	if apikey.IsIpAllowed("198.51.100.1") || !apikey.IsIpAllowed("192.0.2.1") {
+		t.Errorf("allowed cidrs changed to %v", apikey.AllowedCidrs)
+	}
+	if v, ok := apikey.Expiration.(time.Time); !ok || !v.Equal(expiration) {
+		t.Errorf("expiration changed to %v", apikey.Expiration)
+	}
+	if apikey.RateLimit != uint(5) {
+		t.Errorf("rate limit changed to %v", apikey.RateLimit)
+	}
+	if !apikey.RequireSignature {
+		t.Error("signature no longer required")
+	}
+	if !apikey.HasScope(ApikeyScopeRead) {
+		t.Errorf("scopes changed to %v", apikey.Scopes)
+	}
+
+	// fields that are sent empty are cleared
+	controller.Apikeys.FromMap([]any{map[string]any{
+		"_id":          float64(1),
+		"allowedCidrs": []any{},
+		"expiration":   nil,
+		"rateLimit":    float64(0),
+	}})
+
+	if apikey = controller.Apikeys.List[0]; !apikey.IsIpAllowed("198.51.100.1") || apikey.Expiration != nil || apikey.RateLimit != nil {
+		t.Errorf("restrictions not cleared, %+v", apikey)
+	}
+}
@@ -17,15 +17,24 @@ package main
 
 import (
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	IngestSourceApikey   = "apikey"
+	IngestSourceDirwatch = "dirwatch"
+	IngestSourceUpstream = "upstream"
+)
+
 type Call struct {
 	Id             any       `json:"id"`
 	Audio          []byte    `json:"audio"`
@@ -35,11 +44,15 @@ type Call struct {
 	DateTime       time.Time `json:"dateTime"`
 	Frequencies    any       `json:"frequencies"`
 	Frequency      any       `json:"frequency"`
+	IngestSource   any       `json:"ingestSource,omitempty"`
 	Patches        any       `json:"patches"`
+	Provenance     any       `json:"provenance,omitempty"`
 	Source         any       `json:"source"`
 	Sources        any       `json:"sources"`
 	System         uint      `json:"system"`
 	Talkgroup      uint      `json:"talkgroup"`
+	Tones          any       `json:"tones,omitempty"`
+	emergency      bool
 	systemLabel    any
 	talkgroupGroup any
 	talkgroupLabel any
@@ -85,12 +98,18 @@ func (call *Call) MarshalJSON() ([]byte, error) {
 	audio := fmt.Sprintf("%v", call.Audio)
 	audio = strings.ReplaceAll(audio, " ", ",")
 
-	return json.Marshal(map[string]any{
-		"id": call.Id,
-		"audio": map[string]any{
-			"data": json.RawMessage(audio),
-			"type": "Buffer",
-		},
+	payload := call.getPayload()
+	payload["audio"] = map[string]any{
+		"data": json.RawMessage(audio),
+		"type": "Buffer",
+	}
+
+	return json.Marshal(payload)
+}
+
+func (call *Call) getPayload() map[string]any {
+	return map[string]any{
+		"id":          call.Id,
 		"audioName":   call.AudioName,
 		"audioUrl":    call.AudioUrl,
 		"audioType":   call.AudioType,
@@ -98,11 +117,134 @@ func (call *Call) MarshalJSON() ([]byte, error) {
 		"frequencies": call.Frequencies,
 		"frequency":   call.Frequency,
 		"patches":     call.Patches,
+		"provenance":  call.Provenance,
 		"source":      call.Source,
 		"sources":     call.Sources,
 		"system":      call.System,
 		"talkgroup":   call.Talkgroup,
-	})
+		"tones":       call.Tones,
+	}
+}
+
+func (call *Call) getMetadata() map[string]any {
+	return map[string]any{
+		"id":             call.Id,
+		"audioName":      call.AudioName,
+		"audioType":      call.AudioType,
+		"dateTime":       call.DateTime.Format(time.RFC3339),
+		"frequencies":    call.Frequencies,
+		"frequency":      call.Frequency,
+		"patches":        call.Patches,
+		"source":         call.Source,
+		"sources":        call.Sources,
+		"system":         call.System,
+		"systemLabel":    call.systemLabel,
+		"talkgroup":      call.Talkgroup,
+		"talkgroupGroup": call.talkgroupGroup,
+		"talkgroupLabel": call.talkgroupLabel,
+		"talkgroupName":  call.talkgroupName,
+		"talkgroupTag":   call.talkgroupTag,
+		"tones":          call.Tones,
+	}
+}
+
+// binary audio frames are the call id as a big-endian uint64 followed by the raw audio
+func (call *Call) getAudioFrame() []byte {
+	var id uint
+
+	switch v := call.Id.(type) {
+	case uint:
+		id = v
+	}
+
+	b := make([]byte, 8, 8+len(call.Audio))
+	binary.BigEndian.PutUint64(b, uint64(id))
+
+	return append(b, call.Audio...)
+}
+
+func (call *Call) getDuration() float64 {
+	var d float64
+
+	switch v := call.Frequencies.(type) {
+	case []map[string]any:
+		for _, f := range v {
+			d = math.Max(d, toFloat(f["pos"])+toFloat(f["len"]))
+		}
+	case []any:
+		for _, f := range v {
+			switch f := f.(type) {
+			case map[string]any:
+				d = math.Max(d, toFloat(f["pos"])+toFloat(f["len"]))
+			}
+		}
+	}
+
+	switch v := call.Sources.(type) {
+	case []map[string]any:
+		for _, s := range v {
+			d = math.Max(d, toFloat(s["pos"]))
+		}
+	}
+
+	return d
+}
+
+func (call *Call) getFrequencies() []map[string]any {
+	frequencies := []map[string]any{}
+
+	add := func(f map[string]any) {
+		frequencies = append(frequencies, map[string]any{
+			"error_count": toFloat(f["errorCount"]),
+			"freq":        toFloat(f["freq"]),
+			"len":         toFloat(f["len"]),
+			"pos":         toFloat(f["pos"]),
+			"spike_count": toFloat(f["spikeCount"]),
+		})
+	}
+
+	switch v := call.Frequencies.(type) {
+	case []map[string]any:
+		for _, f := range v {
+			add(f)
+		}
+	case []any:
+		for _, f := range v {
+			switch f := f.(type) {
+			case map[string]any:
+				add(f)
+			}
+		}
+	}
+
+	return frequencies
+}
+
+func (call *Call) getSources() []map[string]any {
+	sources := []map[string]any{}
+
+	add := func(s map[string]any) {
+		sources = append(sources, map[string]any{
+			"pos": toFloat(s["pos"]),
+			"src": toFloat(s["src"]),
+		})
+	}
+
+	switch v := call.Sources.(type) {
+	case []map[string]any:
+		for _, s := range v {
+			add(s)
+		}
+	case []any:
+		for _, s := range v {
+			switch s := s.(type) {
+			case map[string]any:
+				add(s)
+			}
+		}
+	}
+
+	return sources
 }
 
 func (call *Call) ToJson() (string, error) {
@@ -113,6 +255,18 @@ func (call *Call) ToJson() (string, error) {
 	}
 }
 
+func toFloat(f any) float64 {
+	switch v := f.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case uint:
+		return float64(v)
+	}
+	return 0
+}
+
 type Calls struct {
 	mutex sync.Mutex
 }
@@ -146,16 +300,19 @@ func (calls *Calls) CheckDuplicate(call *Call, msTimeFrame uint, db *Database) b
 
 func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 	var (
-		audioName   sql.NullString
-		audioUrl    sql.NullString
-		audioType   sql.NullString
-		dateTime    any
-		frequency   sql.NullFloat64
-		source      sql.NullFloat64
-		frequencies string
-		patches     string
-		sources     string
-		t           time.Time
+		audioName    sql.NullString
+		audioUrl     sql.NullString
+		audioType    sql.NullString
+		dateTime     any
+		frequency    sql.NullFloat64
+		source       sql.NullFloat64
+		frequencies  string
+		ingestSource sql.NullString
+		patches      string
+		provenance   sql.NullString
+		sources      string
+		t            time.Time
+		tones        sql.NullString
 	)
 
 	calls.mutex.Lock()
@@ -163,11 +320,11 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 
 	call := Call{Id: id}
 
-	query := fmt.Sprintf("select `audio`, `audioName`,`audioUrl`, `audioType`, `DateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup` from `rdioScannerCalls` where `id` = %v", id)
+	query := fmt.Sprintf("select `audio`, `audioName`,`audioUrl`, `audioType`, `DateTime`, `frequencies`, `frequency`, `ingestSource`, `patches`, `provenance`, `source`, `sources`, `system`, `talkgroup`, `tones` from `rdioScannerCalls` where `id` = %v", id)
 	if db.Config.DbType == DbTypePostgresql {
-		query = fmt.Sprintf("select audio, audioName, audioUrl, audioType, DateTime, frequencies, frequency, patches, source, sources, system, talkgroup from rdioScannerCalls where id = %v", id)
+		query = fmt.Sprintf("select audio, audioName, audioUrl, audioType, DateTime, frequencies, frequency, ingestSource, patches, provenance, source, sources, system, talkgroup, tones from rdioScannerCalls where id = %v", id)
 	}
-	err := db.Sql.QueryRow(query).Scan(&call.Audio, &audioName, &audioUrl, &audioType, &dateTime, &frequencies, &frequency, &patches, &source, &sources, &call.System, &call.Talkgroup)
+	err := db.Sql.QueryRow(query).Scan(&call.Audio, &audioName, &audioUrl, &audioType, &dateTime, &frequencies, &frequency, &ingestSource, &patches, &provenance, &source, &sources, &call.System, &call.Talkgroup, &tones)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("getcall: %v, %v", err, query)
 	}
@@ -194,6 +351,10 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		call.DateTime = time.Time{}
 	}
 
+	if ingestSource.Valid && len(ingestSource.String) > 0 {
+		call.IngestSource = ingestSource.String
+	}
+
 	if len(frequencies) > 0 {
 		if err = json.Unmarshal([]byte(frequencies), &call.Frequencies); err != nil {
 			call.Frequencies = []any{}
@@ -206,6 +367,13 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		}
 	}
 
+	if provenance.Valid && len(provenance.String) > 0 {
+		var hops []string
+		if err = json.Unmarshal([]byte(provenance.String), &hops); err == nil {
+			call.Provenance = hops
+		}
+	}
+
 	if source.Valid && source.Float64 > 0 {
 		call.Source = uint(source.Float64)
 	}
@@ -216,9 +384,70 @@ func (calls *Calls) GetCall(id uint, db *Database) (*Call, error) {
 		}
 	}
 
+	if tones.Valid && len(tones.String) > 0 {
+		var callTones []*CallTone
+		if err = json.Unmarshal([]byte(tones.String), &callTones); err == nil {
+			call.Tones = callTones
+		}
+	}
+
 	return &call, nil
 }
 
+func (calls *Calls) GetIngestSources(db *Database) ([]map[string]any, error) {
+	var (
+		err  error
+		rows *sql.Rows
+	)
+
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("calls.getingestsources: %v", err)
+	}
+
+	q := "select `ingestSource`, count(*) from `rdioScannerCalls` group by `ingestSource` order by `ingestSource`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select ingestSource, count(*) from rdioScannerCalls group by ingestSource order by ingestSource"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return nil, formatError(err)
+	}
+
+	ingestSources := []map[string]any{}
+
+	for rows.Next() {
+		var (
+			count        uint
+			ingestSource sql.NullString
+		)
+
+		if err = rows.Scan(&ingestSource, &count); err != nil {
+			break
+		}
+
+		m := map[string]any{
+			"count":        count,
+			"ingestSource": nil,
+		}
+
+		if ingestSource.Valid {
+			m["ingestSource"] = ingestSource.String
+		}
+
+		ingestSources = append(ingestSources, m)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return nil, formatError(err)
+	}
+
+	return ingestSources, nil
+}
+
 func (calls *Calls) Prune(db *Database, pruneDays uint) error {
 	calls.mutex.Lock()
 	defer calls.mutex.Unlock()
@@ -233,6 +462,24 @@ func (calls *Calls) Prune(db *Database, pruneDays uint) error {
 	return err
 }
 
+func (calls *Calls) PurgeIngestSource(db *Database, ingestSource string) (int64, error) {
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+
+	filter, args := getIngestSourceFilter(ingestSource, db, 0)
+
+	q := fmt.Sprintf("delete from `rdioScannerCalls` where %s", filter)
+	if db.Config.DbType == DbTypePostgresql {
+		q = fmt.Sprintf("delete from rdioScannerCalls where %s", filter)
+	}
+	res, err := db.Sql.Exec(q, args...)
+	if err != nil {
+		return 0, fmt.Errorf("calls.purgeingestsource: %v", err)
+	}
+
+	return res.RowsAffected()
+}
+
 func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*CallsSearchResults, error) {
 	const (
 		ascOrder  = "asc"
@@ -240,6 +487,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	)
 
 	var (
+		args     []any
 		dateTime any
 		err      error
 		id       sql.NullFloat64
@@ -249,6 +497,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		query    string
 		rows     *sql.Rows
 		t        time.Time
+		tones    sql.NullString
 		where    string = "true"
 	)
 
@@ -266,8 +515,8 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		Results: []CallsSearchResult{},
 	}
 
-	if client.Access != nil {
-		switch v := client.Access.Systems.(type) {
+	if access := client.GetAccess(); access != nil {
+		switch v := access.Systems.(type) {
 		case []any:
 			a := []string{}
 			for _, scope := range v {
@@ -330,10 +579,12 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		where += fmt.Sprintf(" and (%s)", strings.Join(a, " and "))
 	}
 
+	groupsMap, tagsMap := client.getScopeMaps()
+
 	switch v := searchOptions.Group.(type) {
 	case string:
 		a := []string{}
-		for id, m := range client.GroupsMap[v] {
+		for id, m := range groupsMap[v] {
 			b := strings.ReplaceAll(fmt.Sprintf("%v", m), " ", ", ")
 			b = strings.ReplaceAll(b, "[", "(")
 			b = strings.ReplaceAll(b, "]", ")")
@@ -351,7 +602,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	switch v := searchOptions.Tag.(type) {
 	case string:
 		a := []string{}
-		for id, m := range client.TagsMap[v] {
+		for id, m := range tagsMap[v] {
 			b := strings.ReplaceAll(fmt.Sprintf("%v", m), " ", ", ")
 			b = strings.ReplaceAll(b, "[", "(")
 			b = strings.ReplaceAll(b, "]", ")")
@@ -366,11 +617,38 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		}
 	}
 
+	switch v := searchOptions.IngestSource.(type) {
+	case string:
+		filter, a := getIngestSourceFilter(v, db, len(args))
+		where += " and " + filter
+		args = append(args, a...)
+	}
+
+	switch v := searchOptions.ToneSet.(type) {
+	case uint:
+		if db.Config.DbType == DbTypePostgresql {
+			where += fmt.Sprintf(" and toneSetIds like '%%,%v,%%'", v)
+		} else {
+			where += fmt.Sprintf(" and `toneSetIds` like '%%,%v,%%'", v)
+		}
+	}
+
+	switch v := searchOptions.Tones.(type) {
+	case bool:
+		if v {
+			if db.Config.DbType == DbTypePostgresql {
+				where += " and tones is not null"
+			} else {
+				where += " and `tones` is not null"
+			}
+		}
+	}
+
 	query = fmt.Sprintf("select `dateTime` from `rdioScannerCalls` where %v order by `dateTime` asc", where)
 	if db.Config.DbType == DbTypePostgresql {
 		query = fmt.Sprintf("select dateTime from rdioScannerCalls where %v order by dateTime asc", where)
 	}
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -382,7 +660,7 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	if db.Config.DbType == DbTypePostgresql {
 		query = fmt.Sprintf("select dateTime from rdioScannerCalls where %v order by dateTime desc", where)
 	}
-	if err = db.Sql.QueryRow(query).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
+	if err = db.Sql.QueryRow(query, args...).Scan(&dateTime); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
@@ -427,6 +705,24 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 		}
 	}
 
+	switch v := searchOptions.DateFrom.(type) {
+	case time.Time:
+		if db.Config.DbType == DbTypePostgresql {
+			where += fmt.Sprintf(" and dateTime >= '%v'", v.UTC().Format(db.DateTimeFormat))
+		} else {
+			where += fmt.Sprintf(" and `dateTime` >= '%v'", v.UTC().Format(db.DateTimeFormat))
+		}
+	}
+
+	switch v := searchOptions.DateTo.(type) {
+	case time.Time:
+		if db.Config.DbType == DbTypePostgresql {
+			where += fmt.Sprintf(" and dateTime <= '%v'", v.UTC().Format(db.DateTimeFormat))
+		} else {
+			where += fmt.Sprintf(" and `dateTime` <= '%v'", v.UTC().Format(db.DateTimeFormat))
+		}
+	}
+
 	switch v := searchOptions.Limit.(type) {
 	case uint:
 		limit = uint(math.Min(float64(500), float64(v)))
@@ -443,24 +739,31 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	if db.Config.DbType == DbTypePostgresql {
 		query = fmt.Sprintf("select count(*) from rdioScannerCalls where %v", where)
 	}
-	if err = db.Sql.QueryRow(query).Scan(&searchResults.Count); err != nil && err != sql.ErrNoRows {
+	if err = db.Sql.QueryRow(query, args...).Scan(&searchResults.Count); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
-	query = fmt.Sprintf("select `id`, `DateTime`, `system`, `talkgroup` from `rdioScannerCalls` where %v order by `dateTime` %v limit %v offset %v", where, order, limit, offset)
+	query = fmt.Sprintf("select `id`, `DateTime`, `system`, `talkgroup`, `tones` from `rdioScannerCalls` where %v order by `dateTime` %v limit %v offset %v", where, order, limit, offset)
 	if db.Config.DbType == DbTypePostgresql {
-		query = fmt.Sprintf("select id, dateTime, system, talkgroup from rdioScannerCalls where %v order by dateTime %v limit %v offset %v", where, order, limit, offset)
+		query = fmt.Sprintf("select id, dateTime, system, talkgroup, tones from rdioScannerCalls where %v order by dateTime %v limit %v offset %v", where, order, limit, offset)
 	}
-	if rows, err = db.Sql.Query(query); err != nil && err != sql.ErrNoRows {
+	if rows, err = db.Sql.Query(query, args...); err != nil && err != sql.ErrNoRows {
 		return nil, formatError(fmt.Errorf("%v, %v", err, query))
 	}
 
 	for rows.Next() {
 		searchResult := CallsSearchResult{}
-		if err = rows.Scan(&id, &dateTime, &searchResult.System, &searchResult.Talkgroup); err != nil {
+		if err = rows.Scan(&id, &dateTime, &searchResult.System, &searchResult.Talkgroup, &tones); err != nil {
 			break
 		}
 
+		if tones.Valid && len(tones.String) > 0 {
+			var callTones []*CallTone
+			if json.Unmarshal([]byte(tones.String), &callTones) == nil {
+				searchResult.Tones = callTones
+			}
+		}
+
 		if id.Valid && id.Float64 > 0 {
 			searchResult.Id = uint(id.Float64)
 		}
@@ -484,6 +787,28 @@ func (calls *Calls) Search(searchOptions *CallsSearchOptions, client *Client) (*
 	return searchResults, err
 }
 
+// UpdateTones records the tones detected once the call was written
+func (calls *Calls) UpdateTones(id uint, tones []*CallTone, db *Database) error {
+	formatError := func(err error) error {
+		return fmt.Errorf("calls.updatetones: %v", err)
+	}
+
+	b, err := json.Marshal(tones)
+	if err != nil {
+		return formatError(err)
+	}
+
+	q := "update `rdioScannerCalls` set `toneSetIds` = ?, `tones` = ? where `id` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "update rdioScannerCalls set toneSetIds = $1, tones = $2 where id = $3"
+	}
+	if _, err = db.Sql.Exec(q, getToneSetIds(tones), string(b), id); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
 func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 	var (
 		b           []byte
@@ -491,8 +816,11 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 		frequencies string
 		id          int64
 		patches     string
+		provenance  any
 		res         sql.Result
 		sources     string
+		toneSetIds  any
+		tones       any
 	)
 
 	calls.mutex.Lock()
@@ -520,6 +848,15 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 		}
 	}
 
+	switch v := call.Provenance.(type) {
+	case []string:
+		if b, err = json.Marshal(v); err == nil {
+			provenance = string(b)
+		} else {
+			return 0, formatError(err)
+		}
+	}
+
 	switch v := call.Sources.(type) {
 	case []map[string]any:
 		if b, err = json.Marshal(v); err == nil {
@@ -529,9 +866,19 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 		}
 	}
 
+	switch v := call.Tones.(type) {
+	case []*CallTone:
+		if b, err = json.Marshal(v); err == nil {
+			tones = string(b)
+			toneSetIds = getToneSetIds(v)
+		} else {
+			return 0, formatError(err)
+		}
+	}
+
 	if db.Config.DbType == DbTypePostgresql {
 		if call.Id != nil {
-			if _, err = db.Sql.Exec("insert into rdioScannerCalls (id, audio, audioName, audioUrl, audioType, dateTime, frequencies, frequency, patches, source, sources, system, talkgroup) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)", call.Id, call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, patches, call.Source, sources, call.System, call.Talkgroup); err != nil {
+			if _, err = db.Sql.Exec("insert into rdioScannerCalls (id, audio, audioName, audioUrl, audioType, dateTime, frequencies, frequency, ingestSource, patches, provenance, source, sources, system, talkgroup, toneSetIds, tones) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)", call.Id, call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, call.IngestSource, patches, provenance, call.Source, sources, call.System, call.Talkgroup, toneSetIds, tones); err != nil {
 				return 0, formatError(err)
 			}
 			callInt, ok := call.Id.(int)
@@ -541,14 +888,14 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 			return 0, formatError(err)
 		} else {
 			var uid int
-			err = db.Sql.QueryRow("insert into rdioScannerCalls (audio, audioName, audioUrl, audioType, dateTime, frequencies, frequency, patches, source, sources, system, talkgroup) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id", call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, patches, call.Source, sources, call.System, call.Talkgroup).Scan(&uid)
+			err = db.Sql.QueryRow("insert into rdioScannerCalls (audio, audioName, audioUrl, audioType, dateTime, frequencies, frequency, ingestSource, patches, provenance, source, sources, system, talkgroup, toneSetIds, tones) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id", call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, call.IngestSource, patches, provenance, call.Source, sources, call.System, call.Talkgroup, toneSetIds, tones).Scan(&uid)
 			if err != nil {
 				return 0, formatError(err)
 			}
 			return uint(uid), nil
 		}
 	} else {
-		if res, err = db.Sql.Exec("insert into `rdioScannerCalls` (`id`, `audio`, `audioName`, audioUrl, `audioType`, `dateTime`, `frequencies`, `frequency`, `patches`, `source`, `sources`, `system`, `talkgroup`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", call.Id, call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, patches, call.Source, sources, call.System, call.Talkgroup); err != nil {
+		if res, err = db.Sql.Exec("insert into `rdioScannerCalls` (`id`, `audio`, `audioName`, audioUrl, `audioType`, `dateTime`, `frequencies`, `frequency`, `ingestSource`, `patches`, `provenance`, `source`, `sources`, `system`, `talkgroup`, `toneSetIds`, `tones`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", call.Id, call.Audio, call.AudioName, call.AudioUrl, call.AudioType, call.DateTime, frequencies, call.Frequency, call.IngestSource, patches, provenance, call.Source, sources, call.System, call.Talkgroup, toneSetIds, tones); err != nil {
 			return 0, formatError(err)
 		}
 
@@ -562,13 +909,18 @@ func (calls *Calls) WriteCall(call *Call, db *Database) (uint, error) {
 
 type CallsSearchOptions struct {
 	Date                    any `json:"date,omitempty"`
+	DateFrom                any `json:"dateFrom,omitempty"`
+	DateTo                  any `json:"dateTo,omitempty"`
 	Group                   any `json:"group,omitempty"`
+	IngestSource            any `json:"ingestSource,omitempty"`
 	Limit                   any `json:"limit,omitempty"`
 	Offset                  any `json:"offset,omitempty"`
 	Sort                    any `json:"sort,omitempty"`
 	System                  any `json:"system,omitempty"`
 	Tag                     any `json:"tag,omitempty"`
 	Talkgroup               any `json:"talkgroup,omitempty"`
+	ToneSet                 any `json:"toneSet,omitempty"`
+	Tones                   any `json:"tones,omitempty"`
 	searchPatchedTalkgroups bool
 }
 
@@ -580,11 +932,32 @@ func (searchOptions *CallsSearchOptions) fromMap(m map[string]any) error {
 		}
 	}
 
+	switch v := m["dateFrom"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			searchOptions.DateFrom = t
+		}
+	}
+
+	switch v := m["dateTo"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			searchOptions.DateTo = t
+		}
+	}
+
 	switch v := m["group"].(type) {
 	case string:
 		searchOptions.Group = v
 	}
 
+	switch v := m["ingestSource"].(type) {
+	case string:
+		if len(v) > 0 {
+			searchOptions.IngestSource = v
+		}
+	}
+
 	switch v := m["limit"].(type) {
 	case float64:
 		searchOptions.Limit = uint(v)
@@ -615,14 +988,55 @@ func (searchOptions *CallsSearchOptions) fromMap(m map[string]any) error {
 		searchOptions.Talkgroup = uint(v)
 	}
 
+	switch v := m["toneSet"].(type) {
+	case float64:
+		searchOptions.ToneSet = uint(v)
+	}
+
+	switch v := m["tones"].(type) {
+	case bool:
+		searchOptions.Tones = v
+	}
+
 	return nil
 }
 
+func (searchOptions *CallsSearchOptions) fromQuery(q url.Values) error {
+	m := map[string]any{}
+
+	for _, k := range []string{"date", "dateFrom", "dateTo", "group", "ingestSource", "tag"} {
+		if v := q.Get(k); len(v) > 0 {
+			m[k] = v
+		}
+	}
+
+	for _, k := range []string{"limit", "offset", "sort", "system", "talkgroup", "toneSet"} {
+		if v := q.Get(k); len(v) > 0 {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s", k)
+			}
+			m[k] = f
+		}
+	}
+
+	if v := q.Get("tones"); len(v) > 0 {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid tones")
+		}
+		m["tones"] = b
+	}
+
+	return searchOptions.fromMap(m)
+}
+
 type CallsSearchResult struct {
 	Id        uint      `json:"id"`
 	DateTime  time.Time `json:"dateTime"`
 	System    uint      `json:"system"`
 	Talkgroup uint      `json:"talkgroup"`
+	Tones     any       `json:"tones,omitempty"`
 }
 
 type CallsSearchResults struct {
@@ -632,3 +1046,15 @@ type CallsSearchResults struct {
 	Options   *CallsSearchOptions `json:"options"`
 	Results   []CallsSearchResult `json:"results"`
 }
+
+// getIngestSourceFilter matches an ingest source and its sub-sources. The postgresql placeholders
+// are numbered after the offset arguments already bound by the caller.
+func getIngestSourceFilter(ingestSource string, db *Database, offset int) (string, []any) {
+	prefix := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(ingestSource) + ":%"
+
+	if db.Config.DbType == DbTypePostgresql {
+		return fmt.Sprintf("(ingestSource = $%d or ingestSource like $%d escape '!')", offset+1, offset+2), []any{ingestSource, prefix}
+	}
+
+	return "(`ingestSource` = ? or `ingestSource` like ? escape '!')", []any{ingestSource, prefix}
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestCallsIngestSourceFilter(t *testing.T) {
+	controller := newTestController(t)
+
+	for i, ingestSource := range []string{"apikey:a_b", "apikey:a_b:1", "apikey:axb", "apikey:axb:1", "dirwatch:x"} {
+		call := newTestQueuedCall(t, controller, i)
+		if _, err := controller.Database.Sql.Exec("update `rdioScannerCalls` set `ingestSource` = ? where `id` = ?", ingestSource, call.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &Client{Controller: controller}
+	client.SetAccess(NewAccess())
+
+	for _, tc := range []struct {
+		ingestSource string
+		want         uint
+	}{
+		{ingestSource: "apikey:a_b", want: 2},
+		{ingestSource: "apikey:a%", want: 0},
+		{ingestSource: "x' or '1'='1", want: 0},
+		{ingestSource: `x\' or 1=1 -- `, want: 0},
+		{ingestSource: "apikey", want: 4},
+	} {
+		results, err := controller.Calls.Search(&CallsSearchOptions{IngestSource: tc.ingestSource}, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.Count != tc.want {
+			t.Errorf("search %q matched %d calls, want %d", tc.ingestSource, results.Count, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		ingestSource string
+		want         int64
+	}{
+		{ingestSource: "x' or '1'='1", want: 0},
+		{ingestSource: "apikey:a_b", want: 2},
+		{ingestSource: "apikey", want: 2},
+	} {
+		count, err := controller.Calls.PurgeIngestSource(controller.Database, tc.ingestSource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.want {
+			t.Errorf("purge %q deleted %d calls, want %d", tc.ingestSource, count, tc.want)
+		}
+	}
+}
+
+func TestCallGetAudioFrame(t *testing.T) {
+	call := NewCall()
+	call.Audio = []byte("audio")
+	call.Id = uint(1<<32 + 5)
+
+	b := call.getAudioFrame()
+
+	if id := binary.BigEndian.Uint64(b[:8]); id != 1<<32+5 {
+		t.Errorf("got id %d, want %d", id, uint64(1<<32+5))
+	}
+
+	if !bytes.Equal(b[8:], call.Audio) {
+		t.Errorf("got audio %q", b[8:])
+	}
+}
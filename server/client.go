@@ -16,28 +16,51 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	ClientAudioBinary = "binary"
+	ClientAudioUrl    = "url"
+	ClientConfigDiff  = "diff"
+)
+
+var clientLastId atomic.Uint64
+
 type Client struct {
-	Access     *Access
-	AuthCount  int
-	Controller *Controller
-	Conn       *websocket.Conn
-	Send       chan *Message
-	Systems    []System
-	GroupsMap  GroupsMap
-	TagsMap    TagsMap
-	Livefeed   *Livefeed
-	SystemsMap SystemsMap
-	request    *http.Request
+	Id            uint64
+	AuthCount     int
+	Controller    *Controller
+	Conn          *websocket.Conn
+	Send          chan *Message
+	Systems       []System
+	GroupsMap     GroupsMap
+	TagsMap       TagsMap
+	Livefeed      *Livefeed
+	Queue         *ClientQueue
+	SystemsMap    SystemsMap
+	access        *Access
+	audio         string
+	bytesSent     atomic.Uint64
+	closeOnce     sync.Once
+	configDiff    bool
+	configMutex   sync.Mutex
+	configVersion uint64
+	connectedAt   time.Time
+	mutex         sync.Mutex
+	removed       bool
+	request       *http.Request
+	revoked       atomic.Bool
 }
 
 func (client *Client) Init(controller *Controller, request *http.Request, conn *websocket.Conn) error {
@@ -56,19 +79,22 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 		return nil
 	}
 
-	client.Access = &Access{}
+	client.Id = clientLastId.Add(1)
+	client.SetAccess(&Access{})
 	client.Controller = controller
 	client.Conn = conn
 	client.Livefeed = NewLivefeed()
+	client.Queue = NewClientQueue()
 	client.Send = make(chan *Message, 8192)
+	client.connectedAt = time.Now().UTC()
 	client.request = request
 
 	go func() {
 		defer func() {
 			controller.Unregister <- client
 
-			if len(client.Access.Ident) > 0 {
-				controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("listener disconnected from ip %s with ident %s", client.GetRemoteAddr(), client.Access.Ident))
+			if ident := client.GetAccess().Ident; len(ident) > 0 {
+				controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("listener disconnected from ip %s with ident %s", client.GetRemoteAddr(), ident))
 
 			} else {
 				controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("listener disconnected from ip %s", client.GetRemoteAddr()))
@@ -118,8 +144,55 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 			}
 
 			client.Conn.Close()
+			client.Queue.Clear()
 		}()
 
+		write := func(message *Message) error {
+			var audio []byte
+
+			if call, ok := message.Payload.(*Call); ok && len(client.getAudio()) > 0 {
+				payload := call.getPayload()
+
+				if len(call.AudioUrl) == 0 {
+					switch client.getAudio() {
+					case ClientAudioBinary:
+						audio = call.getAudioFrame()
+						payload["audio"] = map[string]any{"length": len(call.Audio), "type": ClientAudioBinary}
+					case ClientAudioUrl:
+						payload["audioUrl"] = controller.Api.GetAudioUrl(call, client.request)
+					}
+				}
+
+				message = &Message{Command: message.Command, Payload: payload, Flag: message.Flag}
+			}
+
+			b, err := message.ToJson()
+			if err != nil {
+				log.Println(fmt.Errorf("client.message.tojson: %v", err))
+				return nil
+			}
+
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			if err = client.Conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				return err
+			}
+
+			client.bytesSent.Add(uint64(len(b)))
+
+			if audio != nil {
+				client.bytesSent.Add(uint64(len(audio)))
+
+				// compressed audio gains nothing from deflate
+				client.Conn.EnableWriteCompression(false)
+				defer client.Conn.EnableWriteCompression(true)
+
+				return client.Conn.WriteMessage(websocket.BinaryMessage, audio)
+			}
+
+			return nil
+		}
+
 		for {
 			select {
 			case message, ok := <-client.Send:
@@ -134,8 +207,8 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 
 						controller.Register <- client
 
-						if len(client.Access.Ident) > 0 {
-							controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("new listener from ip %s with ident %s", client.GetRemoteAddr(), client.Access.Ident))
+						if ident := client.GetAccess().Ident; len(ident) > 0 {
+							controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("new listener from ip %s with ident %s", client.GetRemoteAddr(), ident))
 
 						} else {
 							controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("new listener from ip %s", client.GetRemoteAddr()))
@@ -143,14 +216,13 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 					}
 				}
 
-				b, err := message.ToJson()
-				if err != nil {
-					log.Println(fmt.Errorf("client.message.tojson: %v", err))
-
-				} else {
-					client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := write(message); err != nil {
+					return
+				}
 
-					if err = client.Conn.WriteMessage(websocket.TextMessage, b); err != nil {
+			case <-client.Queue.Ready:
+				for message := client.Queue.Pop(controller.Options); message != nil; message = client.Queue.Pop(controller.Options) {
+					if err := write(message); err != nil {
 						return
 					}
 				}
@@ -168,14 +240,157 @@ func (client *Client) Init(controller *Controller, request *http.Request, conn *
 	return nil
 }
 
+// GetAccess returns the access of the listener, it is replaced on the reader goroutine when the
+// listener authenticates while the emitters read it.
+func (client *Client) GetAccess() *Access {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.access
+}
+
 func (client *Client) GetRemoteAddr() string {
 	return GetRemoteAddr(client.request)
 }
 
+func (client *Client) GetStatus() map[string]any {
+	return map[string]any{
+		"id":          client.Id,
+		"bytesSent":   client.bytesSent.Load(),
+		"connectedAt": client.connectedAt,
+		"ident":       client.GetAccess().Ident,
+		"ip":          client.GetRemoteAddr(),
+		"livefeed":    client.Livefeed.GetTalkgroups(),
+		"queueDrops":  client.Queue.Drops(),
+		"queueLength": client.Queue.Len(),
+		"revoked":     client.revoked.Load(),
+		"userAgent":   client.request.UserAgent(),
+	}
+}
+
+func (client *Client) Revoke() {
+	client.revoked.Store(true)
+	client.Queue.Clear()
+	client.enqueue(&Message{Command: MessageCommandExpired})
+}
+
+func (client *Client) SetAccess(access *Access) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.access = access
+}
+
 func (client *Client) SendConfig(groups *Groups, options *Options, systems *Systems, tags *Tags) {
-	client.SystemsMap = systems.GetScopedSystems(client, groups, tags, options.SortTalkgroups)
-	client.GroupsMap = groups.GetGroupsMap(&client.SystemsMap)
-	client.TagsMap = tags.GetTagsMap(&client.SystemsMap)
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	systemsMap := systems.GetScopedSystems(client, groups, tags, options.SortTalkgroups)
+
+	client.sendConfig(groups.GetGroupsMap(&systemsMap), options, systemsMap, tags.GetTagsMap(&systemsMap), client.Controller.ConfigUpdater.Version())
+}
+
+func (client *Client) SendConfigUpdate(groupsMap GroupsMap, options *Options, systemsMap SystemsMap, tagsMap TagsMap, version uint64) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	// a listener that missed an update cannot apply the diff
+	if !client.configDiff || client.configVersion+1 < version {
+		client.sendConfig(groupsMap, options, systemsMap, tagsMap, version)
+		return
+	}
+
+	payload := getConfigDiff(client.SystemsMap, systemsMap)
+
+	if diff := getConfigMapDiff(client.GroupsMap, groupsMap); len(diff) > 0 {
+		payload["groups"] = diff
+	}
+
+	if diff := getConfigMapDiff(client.TagsMap, tagsMap); len(diff) > 0 {
+		payload["tags"] = diff
+	}
+
+	from := client.configVersion
+
+	client.SystemsMap = systemsMap
+	client.GroupsMap = groupsMap
+	client.TagsMap = tagsMap
+	client.configVersion = version
+
+	if len(payload) == 0 {
+		return
+	}
+
+	payload["from"] = from
+	payload["version"] = version
+
+	client.enqueue(&Message{Command: MessageCommandConfigDiff, Payload: payload})
+}
+
+func (client *Client) SendListenersCount(count int) {
+	client.enqueue(&Message{
+		Command: MessagecommandListenersCount,
+		Payload: count,
+	})
+}
+
+func (client *Client) disconnect(reason string) {
+	client.closeOnce.Do(func() {
+		client.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("listener from ip %s disconnected, %s", client.GetRemoteAddr(), reason))
+
+		client.Conn.Close()
+	})
+}
+
+func (client *Client) getAudio() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.audio
+}
+
+func (client *Client) getScopeMaps() (GroupsMap, TagsMap) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	return client.GroupsMap, client.TagsMap
+}
+
+func (client *Client) hasSystems(systems []uint) bool {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	for _, system := range client.SystemsMap {
+		switch id := system["id"].(type) {
+		case uint:
+			if slices.Contains(systems, id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (client *Client) setAudio(audio string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.audio = audio
+}
+
+func (client *Client) setConfigDiff(configDiff bool) {
+	client.configMutex.Lock()
+	defer client.configMutex.Unlock()
+
+	client.configDiff = configDiff
+}
+
+func (client *Client) sendConfig(groupsMap GroupsMap, options *Options, systemsMap SystemsMap, tagsMap TagsMap, version uint64) {
+	client.SystemsMap = systemsMap
+	client.GroupsMap = groupsMap
+	client.TagsMap = tagsMap
+	client.configVersion = version
 
 	var payload = map[string]any{
 		"branding":           options.Branding,
@@ -188,19 +403,27 @@ func (client *Client) SendConfig(groups *Groups, options *Options, systems *Syst
 		"tags":               client.TagsMap,
 		"tagsToggle":         options.TagsToggle,
 		"time12hFormat":      options.Time12hFormat,
+		"version":            version,
 	}
 
 	if len(options.AfsSystems) > 0 {
 		payload["afs"] = options.AfsSystems
 	}
 
-	client.Send <- &Message{Command: MessageCommandConfig, Payload: payload}
+	if motd := options.GetMotd(); len(motd) > 0 {
+		payload["motd"] = motd
+	}
+
+	client.enqueue(&Message{Command: MessageCommandConfig, Payload: payload})
 }
 
-func (client *Client) SendListenersCount(count int) {
-	client.Send <- &Message{
-		Command: MessagecommandListenersCount,
-		Payload: count,
+func (client *Client) enqueue(message *Message) bool {
+	select {
+	case client.Send <- message:
+		return true
+	default:
+		client.disconnect("send queue full")
+		return false
 	}
 }
 
@@ -219,8 +442,8 @@ func NewClients() *Clients {
 func (clients *Clients) AccessCount(client *Client) int {
 	count := 0
 
-	for c := range clients.Map {
-		if c.Access == client.Access {
+	for _, c := range clients.snapshot() {
+		if c.GetAccess() == client.GetAccess() {
 			count++
 		}
 	}
@@ -228,52 +451,152 @@ func (clients *Clients) AccessCount(client *Client) int {
 	return count
 }
 
-func (clients *Clients) Add(client *Client) {
+func (clients *Clients) Add(client *Client) bool {
 	clients.mutex.Lock()
 	defer clients.mutex.Unlock()
 
+	if client.removed {
+		return false
+	}
+
 	clients.Map[client] = true
+
+	return true
 }
 
 func (clients *Clients) Count() int {
+	clients.mutex.Lock()
+	defer clients.mutex.Unlock()
+
 	return len(clients.Map)
 }
 
+func (clients *Clients) EmitServerMessage(message string, idents []string, systems []uint) int {
+	count := 0
+
+	for _, c := range clients.snapshot() {
+		if len(idents) > 0 && !slices.Contains(idents, c.GetAccess().Ident) {
+			continue
+		}
+
+		if len(systems) > 0 && !c.hasSystems(systems) {
+			continue
+		}
+
+		if c.enqueue(&Message{Command: MessageCommandServer, Payload: message}) {
+			count++
+		}
+	}
+
+	return count
+}
+
 func (clients *Clients) EmitCall(call *Call, restricted bool) {
-	for c := range clients.Map {
-		if (!restricted || c.Access.HasAccess(call)) && c.Livefeed.IsEnabled(call) {
-			c.Send <- &Message{Command: MessageCommandCall, Payload: call}
+	for _, c := range clients.snapshot() {
+		if (!restricted || c.GetAccess().HasAccess(call)) && c.Livefeed.IsEnabled(call) && !c.revoked.Load() {
+			if !c.Queue.Push(call, &Message{Command: MessageCommandCall, Payload: call}, c.Controller.Options) {
+				metricListenerDisconnected.Inc()
+				c.disconnect("send queue too slow")
+			}
 		}
 	}
 }
 
 func (clients *Clients) EmitConfig(groups *Groups, options *Options, systems *Systems, tags *Tags, restricted bool) {
-	count := len(clients.Map)
+	list := clients.snapshot()
 
-	for c := range clients.Map {
+	for _, c := range list {
 		if restricted {
-			c.Send <- &Message{Command: MessageCommandPin}
+			c.enqueue(&Message{Command: MessageCommandPin})
 		} else {
 			c.SendConfig(groups, options, systems, tags)
 		}
 
 		if options.ShowListenersCount {
-			c.SendListenersCount(count)
+			c.SendListenersCount(len(list))
+		}
+	}
+}
+
+func (clients *Clients) EmitConfigUpdate(groups *Groups, options *Options, systems *Systems, tags *Tags, version uint64, restricted bool) {
+	type scope struct {
+		groupsMap  GroupsMap
+		systemsMap SystemsMap
+		tagsMap    TagsMap
+	}
+
+	// listeners sharing the same access share the same scoped config
+	scopes := map[string]*scope{}
+
+	for _, c := range clients.snapshot() {
+		access := c.GetAccess()
+
+		// listeners that have not entered their access code yet are only asked for it
+		if restricted && (access == nil || access.Systems == nil) {
+			c.enqueue(&Message{Command: MessageCommandPin})
+			continue
 		}
+
+		b, _ := json.Marshal(access.Systems)
+
+		s, ok := scopes[string(b)]
+		if !ok {
+			systemsMap := systems.GetScopedSystems(c, groups, tags, options.SortTalkgroups)
+			s = &scope{
+				groupsMap:  groups.GetGroupsMap(&systemsMap),
+				systemsMap: systemsMap,
+				tagsMap:    tags.GetTagsMap(&systemsMap),
+			}
+			scopes[string(b)] = s
+		}
+
+		c.SendConfigUpdate(s.groupsMap, options, s.systemsMap, s.tagsMap, version)
 	}
 }
 
 func (clients *Clients) EmitListenersCount() {
-	count := len(clients.Map)
+	list := clients.snapshot()
 
-	for c := range clients.Map {
-		c.SendListenersCount(count)
+	for _, c := range list {
+		c.SendListenersCount(len(list))
+	}
+}
+
+func (clients *Clients) GetClient(id uint64) (*Client, bool) {
+	for _, c := range clients.snapshot() {
+		if c.Id == id {
+			return c, true
+		}
 	}
+
+	return nil, false
+}
+
+func (clients *Clients) Has(client *Client) bool {
+	clients.mutex.Lock()
+	defer clients.mutex.Unlock()
+
+	return clients.Map[client]
 }
 
 func (clients *Clients) Remove(client *Client) {
 	clients.mutex.Lock()
 	defer clients.mutex.Unlock()
 
+	client.removed = true
+	client.Queue.Clear()
+
 	delete(clients.Map, client)
 }
+
+func (clients *Clients) snapshot() []*Client {
+	clients.mutex.Lock()
+	defer clients.mutex.Unlock()
+
+	list := make([]*Client, 0, len(clients.Map))
+	for c := range clients.Map {
+		list = append(list, c)
+	}
+
+	return list
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestClient connects a listener to the controller through a real websocket and returns both ends.
+func newTestClient(t *testing.T, controller *Controller) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		client := &Client{}
+		if err = client.Init(controller, r, conn); err != nil {
+			t.Error(err)
+		}
+
+		clients <- client
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := <-clients
+	client.Livefeed.FromMap(map[string]any{"1": map[string]any{"150": true}})
+
+	return client, conn
+}
+
+func TestClientsConcurrentAccess(t *testing.T) {
+	controller := newTestController(t)
+	controller.Options.MaxClients = 100
+
+	var wg sync.WaitGroup
+
+	list := []*Client{}
+
+	for i := 0; i < 4; i++ {
+		client, conn := newTestClient(t, controller)
+		list = append(list, client)
+
+		// drain the listener side
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	for _, client := range list {
+		client := client
+
+		wg.Add(4)
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				controller.Clients.Add(client)
+				controller.Clients.Remove(client)
+				controller.Clients.Add(client)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				client.SetAccess(&Access{Ident: "ident", Systems: "*"})
+				controller.ProcessMessageCommandVersion(client, &Message{Command: MessageCommandVersion, Payload: map[string]any{"audio": ClientAudioBinary, "config": ClientConfigDiff}})
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				client.GetStatus()
+				controller.Clients.EmitServerMessage("hello", []string{"ident"}, nil)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				controller.Clients.EmitConfigUpdate(controller.Groups, controller.Options, controller.Systems, controller.Tags, uint64(i), true)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			controller.Clients.EmitCall(newTestDownstreamCall(), true)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestClientSlowConsumerIsDisconnected(t *testing.T) {
+	controller := newTestController(t)
+	controller.Options.ListenerQueueMaxAge = 0
+	controller.Options.ListenerQueueMaxLength = 4
+	controller.Options.ListenerQueuePolicy = ListenerQueuePolicyDisconnect
+	controller.Options.MaxClients = 100
+
+	// the listener never reads, so the writer blocks once the socket buffers are full
+	client, _ := newTestClient(t, controller)
+	controller.Clients.Add(client)
+
+	call := newTestDownstreamCall()
+	call.Audio = make([]byte, 1<<20)
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for controller.Clients.Has(client) {
+		if time.Now().After(deadline) {
+			t.Fatalf("slow listener not disconnected, %d calls queued", client.Queue.Len())
+		}
+
+		controller.Clients.EmitCall(call, false)
+
+		// the disconnected listener unregisters itself
+		select {
+		case c := <-controller.Unregister:
+			controller.Clients.Remove(c)
+		default:
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := client.Queue.Len(); n != 0 {
+		t.Errorf("queue of the disconnected listener still holds %d calls", n)
+	}
+}
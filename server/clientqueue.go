@@ -0,0 +1,211 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// when full, drop the queued calls of the same talkgroup, or the oldest call if there is none
+	ListenerQueuePolicyCoalesce   = "coalesce"
+	ListenerQueuePolicyDisconnect = "disconnect"
+	ListenerQueuePolicyDropOldest = "drop-oldest"
+)
+
+// from the most lenient to the strictest, coalesce drops more than drop-oldest and disconnect
+// frees the whole queue
+var listenerQueuePolicyRanks = map[string]int{
+	ListenerQueuePolicyDropOldest: 1,
+	ListenerQueuePolicyCoalesce:   2,
+	ListenerQueuePolicyDisconnect: 3,
+}
+
+type ClientQueue struct {
+	Ready     chan struct{}
+	drops     uint
+	entries   []*clientQueueEntry
+	maxAge    uint
+	maxLength uint
+	mutex     sync.Mutex
+	policy    string
+}
+
+type clientQueueEntry struct {
+	call     *Call
+	message  *Message
+	queuedAt time.Time
+}
+
+func NewClientQueue() *ClientQueue {
+	return &ClientQueue{
+		Ready:   make(chan struct{}, 1),
+		entries: []*clientQueueEntry{},
+		mutex:   sync.Mutex{},
+	}
+}
+
+func (queue *ClientQueue) Clear() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	metricListenerQueueDepth.Sub(float64(len(queue.entries)))
+
+	queue.entries = []*clientQueueEntry{}
+}
+
+func (queue *ClientQueue) Drops() uint {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.drops
+}
+
+func (queue *ClientQueue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return len(queue.entries)
+}
+
+func (queue *ClientQueue) Pop(options *Options) *Message {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.expire(queue.getMaxAge(options))
+
+	if len(queue.entries) == 0 {
+		return nil
+	}
+
+	entry := queue.entries[0]
+	queue.entries = queue.entries[1:]
+
+	metricListenerQueueDepth.Dec()
+
+	return entry.message
+}
+
+// returns false when the policy requires the listener to be disconnected
+func (queue *ClientQueue) Push(call *Call, message *Message, options *Options) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	maxAge := queue.getMaxAge(options)
+	policy := queue.getPolicy(options)
+
+	if policy == ListenerQueuePolicyDisconnect && queue.isStale(maxAge) {
+		return false
+	}
+
+	queue.expire(maxAge)
+
+	if max := int(queue.getMaxLength(options)); max > 0 && len(queue.entries) >= max {
+		switch policy {
+		case ListenerQueuePolicyDisconnect:
+			return false
+
+		case ListenerQueuePolicyCoalesce:
+			entries := []*clientQueueEntry{}
+			for _, entry := range queue.entries {
+				if entry.call.System == call.System && entry.call.Talkgroup == call.Talkgroup {
+					queue.drop("coalesce")
+				} else {
+					entries = append(entries, entry)
+				}
+			}
+			queue.entries = entries
+		}
+
+		for len(queue.entries) >= max {
+			queue.entries = queue.entries[1:]
+			queue.drop("length")
+		}
+	}
+
+	queue.entries = append(queue.entries, &clientQueueEntry{call: call, message: message, queuedAt: time.Now()})
+
+	metricListenerQueueDepth.Inc()
+
+	select {
+	case queue.Ready <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// SetPolicy overrides the server queue policy for one listener. The policy and limits can only be
+// made stricter, a more lenient policy or a zero value keeps the server one.
+func (queue *ClientQueue) SetPolicy(policy string, maxLength uint, maxAge uint) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	switch policy {
+	case ListenerQueuePolicyCoalesce, ListenerQueuePolicyDisconnect, ListenerQueuePolicyDropOldest:
+		queue.policy = policy
+	}
+
+	queue.maxAge = maxAge
+	queue.maxLength = maxLength
+}
+
+func (queue *ClientQueue) drop(reason string) {
+	queue.drops++
+
+	metricListenerQueueDepth.Dec()
+	metricListenerDropped.WithLabelValues(reason).Inc()
+}
+
+func (queue *ClientQueue) expire(maxAge uint) {
+	for queue.isStale(maxAge) {
+		queue.entries = queue.entries[1:]
+		queue.drop("age")
+	}
+}
+
+func (queue *ClientQueue) getMaxAge(options *Options) uint {
+	return getClientQueueLimit(queue.maxAge, options.ListenerQueueMaxAge)
+}
+
+func (queue *ClientQueue) getMaxLength(options *Options) uint {
+	return getClientQueueLimit(queue.maxLength, options.ListenerQueueMaxLength)
+}
+
+func (queue *ClientQueue) getPolicy(options *Options) string {
+	if listenerQueuePolicyRanks[queue.policy] > listenerQueuePolicyRanks[options.ListenerQueuePolicy] {
+		return queue.policy
+	}
+
+	return options.ListenerQueuePolicy
+}
+
+func (queue *ClientQueue) isStale(maxAge uint) bool {
+	if maxAge == 0 || len(queue.entries) == 0 {
+		return false
+	}
+
+	return time.Since(queue.entries[0].queuedAt) > time.Duration(maxAge)*time.Second
+}
+
+func getClientQueueLimit(client uint, server uint) uint {
+	if client > 0 && (server == 0 || client < server) {
+		return client
+	}
+
+	return server
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func pushTestClientQueue(queue *ClientQueue, options *Options, talkgroups ...uint) bool {
+	for _, talkgroup := range talkgroups {
+		call := &Call{System: 1, Talkgroup: talkgroup}
+		if !queue.Push(call, &Message{Command: MessageCommandCall, Payload: call}, options) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestClientQueuePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy     string
+		talkgroups []uint
+		ok         bool
+		length     int
+		drops      uint
+		first      uint
+	}{
+		{policy: ListenerQueuePolicyDropOldest, talkgroups: []uint{1, 2, 3, 4, 5}, ok: true, length: 3, drops: 2, first: 3},
+		{policy: ListenerQueuePolicyCoalesce, talkgroups: []uint{1, 2, 3, 2}, ok: true, length: 3, drops: 1, first: 1},
+		{policy: ListenerQueuePolicyCoalesce, talkgroups: []uint{1, 2, 3, 4}, ok: true, length: 3, drops: 1, first: 2},
+		{policy: ListenerQueuePolicyDisconnect, talkgroups: []uint{1, 2, 3}, ok: true, length: 3, drops: 0, first: 1},
+		{policy: ListenerQueuePolicyDisconnect, talkgroups: []uint{1, 2, 3, 4}, ok: false, length: 3, drops: 0, first: 1},
+	} {
+		options := &Options{ListenerQueueMaxLength: 3, ListenerQueuePolicy: tc.policy}
+		queue := NewClientQueue()
+
+		if ok := pushTestClientQueue(queue, options, tc.talkgroups...); ok != tc.ok {
+			t.Errorf("%s %v: push returned %v, want %v", tc.policy, tc.talkgroups, ok, tc.ok)
+		}
+
+		if queue.Len() != tc.length || queue.Drops() != tc.drops {
+			t.Errorf("%s %v: got length %d drops %d, want %d and %d", tc.policy, tc.talkgroups, queue.Len(), queue.Drops(), tc.length, tc.drops)
+		}
+
+		if message := queue.Pop(options); message == nil || message.Payload.(*Call).Talkgroup != tc.first {
+			t.Errorf("%s %v: unexpected head %v", tc.policy, tc.talkgroups, message)
+		}
+	}
+}
+
+func TestClientQueueMaxAge(t *testing.T) {
+	options := &Options{ListenerQueueMaxAge: 1, ListenerQueuePolicy: ListenerQueuePolicyDropOldest}
+	queue := NewClientQueue()
+
+	pushTestClientQueue(queue, options, 1, 2)
+	queue.entries[0].queuedAt = time.Now().Add(-2 * time.Second)
+
+	if message := queue.Pop(options); message == nil || message.Payload.(*Call).Talkgroup != 2 || queue.Drops() != 1 {
+		t.Fatalf("stale call not expired, got %v with %d drops", message, queue.Drops())
+	}
+
+	options.ListenerQueuePolicy = ListenerQueuePolicyDisconnect
+
+	pushTestClientQueue(queue, options, 3)
+	queue.entries[0].queuedAt = time.Now().Add(-2 * time.Second)
+
+	if pushTestClientQueue(queue, options, 4) {
+		t.Fatal("stale listener not disconnected")
+	}
+}
+
+func TestClientQueueClientPolicy(t *testing.T) {
+	options := &Options{ListenerQueueMaxLength: 3, ListenerQueuePolicy: ListenerQueuePolicyDropOldest}
+
+	queue := NewClientQueue()
+	queue.SetPolicy(ListenerQueuePolicyCoalesce, 2, 0)
+
+	pushTestClientQueue(queue, options, 1, 2, 1)
+
+	if queue.Len() != 2 || queue.Drops() != 1 {
+		t.Errorf("got length %d drops %d, want 2 and 1", queue.Len(), queue.Drops())
+	}
+
+	// a listener cannot lift the server limits
+	queue = NewClientQueue()
+	queue.SetPolicy("", 100, 0)
+
+	pushTestClientQueue(queue, options, 1, 2, 3, 4)
+
+	if queue.Len() != 3 || queue.Drops() != 1 {
+		t.Errorf("got length %d drops %d, want 3 and 1", queue.Len(), queue.Drops())
+	}
+
+	// nor replace a stricter server policy
+	for _, policy := range []string{ListenerQueuePolicyDropOldest, ListenerQueuePolicyCoalesce} {
+		queue = NewClientQueue()
+		queue.SetPolicy(policy, 0, 0)
+
+		strict := &Options{ListenerQueueMaxLength: 3, ListenerQueuePolicy: ListenerQueuePolicyDisconnect}
+
+		if pushTestClientQueue(queue, strict, 1, 2, 3, 4) {
+			t.Errorf("%s listener not disconnected", policy)
+		}
+	}
+
+	// an empty server policy is drop-oldest, a listener may still ask to be disconnected
+	queue = NewClientQueue()
+	queue.SetPolicy(ListenerQueuePolicyDisconnect, 0, 0)
+
+	if pushTestClientQueue(queue, &Options{ListenerQueueMaxLength: 3}, 1, 2, 3, 4) {
+		t.Error("listener asking to be disconnected was not")
+	}
+}
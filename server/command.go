@@ -38,12 +38,15 @@ const (
 	COMMAND_ARG_IDENT      = "+ident"
 	COMMAND_ARG_IN         = "+in"
 	COMMAND_ARG_LIMIT      = "+limit"
+	COMMAND_ARG_MESSAGE    = "+message"
+	COMMAND_ARG_MOTD       = "+motd"
 	COMMAND_ARG_OUT        = "+out"
 	COMMAND_ARG_PASSWORD   = "+password"
 	COMMAND_ARG_SYSTEMS    = "+systems"
 	COMMAND_ARG_TOKEN      = "+token"
 	COMMAND_ARG_URL        = "+url"
 	COMMAND_ADMIN_PASSWORD = "admin-password"
+	COMMAND_BROADCAST      = "broadcast"
 	COMMAND_CONFIG_GET     = "config-get"
 	COMMAND_CONFIG_SET     = "config-set"
 	COMMAND_HELP           = "help"
@@ -64,6 +67,8 @@ type Command struct {
 	ident      string
 	in         string
 	limit      string
+	message    string
+	motd       bool
 	out        string
 	password   string
 	systems    string
@@ -119,6 +124,12 @@ func (command *Command) Do(action string) {
 		case COMMAND_ARG_LIMIT:
 			command.limit = readVal()
 
+		case COMMAND_ARG_MESSAGE:
+			command.message = readVal()
+
+		case COMMAND_ARG_MOTD:
+			command.motd = true
+
 		case COMMAND_ARG_OUT:
 			command.out = readVal()
 			if !strings.HasSuffix(strings.ToLower(command.out), ".json") {
@@ -154,6 +165,9 @@ func (command *Command) Do(action string) {
 	}
 
 	switch action {
+	case COMMAND_BROADCAST:
+		command.broadcast()
+
 	case COMMAND_CONFIG_GET:
 		command.configGet()
 
@@ -195,6 +209,12 @@ func (command *Command) printUsage() {
 	fmt.Printf("\nAvailable Commands:\n\n")
 	fmt.Printf("  %-11s – Change administrator password.\n\n", COMMAND_ADMIN_PASSWORD)
 	fmt.Printf("    %-11s %s%s -%s %s %s <password>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_ADMIN_PASSWORD, COMMAND_ARG_PASSWORD)
+	fmt.Printf("  %-11s – Send a message to listeners.\n\n", COMMAND_BROADCAST)
+	fmt.Printf("    %-11s %s%s -%s %s %s <message>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_BROADCAST, COMMAND_ARG_MESSAGE)
+	fmt.Printf("    %-11s Optional:\n\n", "")
+	fmt.Printf("      %-11s %-11s <ident1[,ident2,...]> – Only listeners with these access idents.\n", "", COMMAND_ARG_IDENT)
+	fmt.Printf("      %-11s %-11s                       – Also keep as message of the day, an empty message clears it.\n", "", COMMAND_ARG_MOTD)
+	fmt.Printf("      %-11s %-11s <sysid1[,sysid2,...]> – Only listeners with access to these systems.\n\n", "", COMMAND_ARG_SYSTEMS)
 	fmt.Printf("  %-11s – Retrieve server's configuration.\n\n", COMMAND_CONFIG_GET)
 	fmt.Printf("    %-11s %s%s -%s %s %s <file.json>\n\n", "", prompt, command.app, COMMAND_ARG, COMMAND_CONFIG_GET, COMMAND_ARG_OUT)
 	fmt.Printf("  %-11s – Set server's configuration.\n\n", COMMAND_CONFIG_SET)
@@ -237,6 +257,56 @@ func (command *Command) adminPassword() {
 	}
 }
 
+func (command *Command) broadcast() {
+	if command.message == "" && !command.motd {
+		command.exitWithError(fmt.Sprintf("Missing %s <message> arguments.", COMMAND_ARG_MESSAGE))
+	}
+
+	b := map[string]any{
+		"message": command.message,
+		"motd":    command.motd,
+	}
+
+	if command.ident != "" {
+		b["idents"] = strings.Split(command.ident, ",")
+	}
+
+	if command.systems != "" {
+		s := []int{}
+		for _, v := range strings.Split(command.systems, ",") {
+			if i, err := strconv.Atoi(v); err == nil {
+				s = append(s, i)
+			} else {
+				command.exitWithError(fmt.Sprintf("The value '%s' is invalid for %s", v, COMMAND_ARG_SYSTEMS))
+			}
+		}
+		b["systems"] = s
+	}
+
+	if body, err := command.writeBody(b); err == nil {
+		if res, err := command.submit(http.MethodPost, "/api/admin/broadcast", body, true); err == nil {
+			if res.StatusCode == http.StatusOK {
+				if data, err := command.readBody(res.Body); err == nil {
+					switch v := data.(type) {
+					case map[string]any:
+						fmt.Printf("Message sent to %v listeners.\n", v["count"])
+					default:
+						command.exitWithError(errors.New("invalid response"))
+					}
+				} else {
+					command.exitWithError(err)
+				}
+			} else {
+				command.exitWithError(errors.New(res.Status))
+			}
+		} else {
+			command.exitWithError(err)
+		}
+	} else {
+		command.exitWithError(err)
+	}
+}
+
 func (command *Command) configGet() {
 	if command.out == "" {
 		command.exitWithError(fmt.Sprintf("Missing %s <file.json> arguments.", COMMAND_ARG_OUT))
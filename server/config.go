@@ -24,7 +24,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
 
+	"github.com/gorilla/websocket"
 	"gopkg.in/ini.v1"
 )
 
@@ -43,6 +45,9 @@ const (
 	DbTypePostgresql string = "postgresql"
 )
 
+// write buffers are shared by all websocket connections and only held while a message is being written
+var websocketWriteBufferPool = &sync.Pool{}
+
 type sslMode string
 
 const (
@@ -53,6 +58,7 @@ const (
 )
 
 type Config struct {
+	AlertExecDir     string
 	BaseDir          string
 	ConfigFile       string
 	DbType           string
@@ -65,6 +71,9 @@ type Config struct {
 	DbPassword       string
 	MetricsPort      uint
 	Listen           string
+	WsCompression    bool
+	WsReadBuffer     uint
+	WsWriteBuffer    uint
 	daemon           *Daemon
 	newAdminPassword string
 }
@@ -77,7 +86,7 @@ func NewConfig() *Config {
 		config        = &Config{}
 		configSave    = flag.Bool("config_save", false, fmt.Sprintf("save configuration to %s", defaultConfigFile))
 		serviceAction = flag.String("service", "", "service command, one of start, stop, restart, install, uninstall")
-		version       = flag.Bool("version", false, "show application version")
+		showVersion   = flag.Bool("version", false, "show application version")
 	)
 
 	defaultDbType := os.Getenv("DB_TYPE")
@@ -106,6 +115,7 @@ func NewConfig() *Config {
 		defaultAdminUrl = "/admin"
 		defaultDbFile   = "rdio-scanner.db"
 		defaultListen   = ":3000"
+		defaultWsBuffer = 4096
 	)
 
 	if exe, err := os.Executable(); err == nil {
@@ -122,6 +132,7 @@ func NewConfig() *Config {
 		}
 	}
 
+	flag.StringVar(&config.AlertExecDir, "alert_exec_dir", "", "directory of the commands alert exec actions can run, exec actions are disabled if empty")
 	flag.StringVar(&config.BaseDir, "base_dir", config.BaseDir, "base directory where all data will be written")
 	flag.StringVar(&config.DbFile, "db_file", defaultDbFile, "sqlite database file")
 	flag.StringVar(&config.DbHost, "db_host", defaultDbHost, "database host ip or hostname")
@@ -135,6 +146,9 @@ func NewConfig() *Config {
 	flag.StringVar(&config.ConfigFile, "config", defaultConfigFile, "server config file")
 	flag.StringVar(&config.Listen, "listen", defaultListen, "listening address")
 	flag.StringVar(&config.newAdminPassword, "admin_password", "", "change admin password")
+	flag.BoolVar(&config.WsCompression, "ws_compression", true, "negotiate permessage-deflate compression on websockets")
+	flag.UintVar(&config.WsReadBuffer, "ws_read_buffer", defaultWsBuffer, "websocket read buffer size in bytes")
+	flag.UintVar(&config.WsWriteBuffer, "ws_write_buffer", defaultWsBuffer, "websocket write buffer size in bytes")
 	flag.Parse()
 
 	dbUsernameEnv := os.Getenv("DB_USER")
@@ -181,12 +195,16 @@ func NewConfig() *Config {
 			os.Exit(-1)
 		}
 
-	case *version:
+	case *showVersion:
 		fmt.Printf("Version %s Commit %s", version, commit)
 		os.Exit(0)
 
 	default:
 		if cfg, err := ini.Load(config.GetConfigFilePath()); err == nil {
+			if v := cfg.Section("").Key("alert_exec_dir").String(); len(v) > 0 {
+				config.AlertExecDir = v
+			}
+
 			if v := cfg.Section("").Key("db_file").String(); len(v) > 0 {
 				config.DbFile = v
 			}
@@ -222,6 +240,18 @@ func NewConfig() *Config {
 			if v := cfg.Section("").Key("listen").String(); len(v) > 0 {
 				config.Listen = v
 			}
+
+			if v, err := cfg.Section("").Key("ws_compression").Bool(); err == nil {
+				config.WsCompression = v
+			}
+
+			if v, err := cfg.Section("").Key("ws_read_buffer").Uint(); err == nil && v > 0 {
+				config.WsReadBuffer = v
+			}
+
+			if v, err := cfg.Section("").Key("ws_write_buffer").Uint(); err == nil && v > 0 {
+				config.WsWriteBuffer = v
+			}
 		}
 
 		if !(config.DbType == DbTypeMariadb || config.DbType == DbTypeMysql || config.DbType == DbTypePostgresql || config.DbType == DbTypeSqlite) {
@@ -256,6 +286,15 @@ func (config *Config) GetPath(p string) string {
 	return filepath.Join(config.BaseDir, p)
 }
 
+func (config *Config) NewWebsocketUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		EnableCompression: config.WsCompression,
+		ReadBufferSize:    int(config.WsReadBuffer),
+		WriteBufferPool:   websocketWriteBufferPool,
+		WriteBufferSize:   int(config.WsWriteBuffer),
+	}
+}
+
 func (config *Config) isBaseDirWritable() bool {
 	if f, err := os.CreateTemp(config.BaseDir, ".tmp*"); err == nil {
 		f.Close()
@@ -268,6 +307,10 @@ func (config *Config) isBaseDirWritable() bool {
 func (config *Config) saveConfig() error {
 	ini := []string{}
 
+	if config.AlertExecDir != "" {
+		ini = append(ini, fmt.Sprintf("alert_exec_dir = %s", config.AlertExecDir))
+	}
+
 	if config.DbType == DbTypeSqlite {
 		if config.DbFile != "" {
 			ini = append(ini, fmt.Sprintf("db_file = %s", config.DbFile))
@@ -307,6 +350,16 @@ func (config *Config) saveConfig() error {
 		ini = append(ini, fmt.Sprintf("listen = %s", config.Listen))
 	}
 
+	ini = append(ini, fmt.Sprintf("ws_compression = %v", config.WsCompression))
+
+	if config.WsReadBuffer > 0 {
+		ini = append(ini, fmt.Sprintf("ws_read_buffer = %d", config.WsReadBuffer))
+	}
+
+	if config.WsWriteBuffer > 0 {
+		ini = append(ini, fmt.Sprintf("ws_write_buffer = %d", config.WsWriteBuffer))
+	}
+
 	file, err := os.Create(config.GetConfigFilePath())
 	if err != nil {
 		return err
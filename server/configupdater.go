@@ -0,0 +1,210 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	configUpdateDebounce = 2 * time.Second
+	configUpdateMaxDelay = 10 * time.Second
+)
+
+type ConfigUpdater struct {
+	Controller *Controller
+	mutex      sync.Mutex
+	pendingAt  time.Time
+	timer      *time.Timer
+	version    uint64
+}
+
+func NewConfigUpdater(controller *Controller) *ConfigUpdater {
+	return &ConfigUpdater{
+		Controller: controller,
+		mutex:      sync.Mutex{},
+	}
+}
+
+// debounces bursts of config changes, like autopopulated talkgroups, into a single update to listeners
+func (updater *ConfigUpdater) Emit() {
+	updater.mutex.Lock()
+	defer updater.mutex.Unlock()
+
+	if updater.timer == nil {
+		updater.pendingAt = time.Now()
+	} else {
+		updater.timer.Stop()
+	}
+
+	delay := configUpdateDebounce
+	if d := configUpdateMaxDelay - time.Since(updater.pendingAt); d < delay {
+		delay = max(d, 0)
+	}
+
+	updater.timer = time.AfterFunc(delay, func() {
+		updater.mutex.Lock()
+		updater.timer = nil
+		updater.mutex.Unlock()
+
+		controller := updater.Controller
+
+		controller.Clients.EmitConfigUpdate(controller.Groups, controller.Options, controller.Systems, controller.Tags, updater.Next(), controller.Accesses.IsRestricted())
+		controller.Admin.BroadcastConfig()
+	})
+}
+
+func (updater *ConfigUpdater) Next() uint64 {
+	updater.mutex.Lock()
+	defer updater.mutex.Unlock()
+
+	updater.version++
+
+	return updater.version
+}
+
+func (updater *ConfigUpdater) Version() uint64 {
+	updater.mutex.Lock()
+	defer updater.mutex.Unlock()
+
+	return updater.version
+}
+
+func getConfigDiff(from SystemsMap, to SystemsMap) map[string]any {
+	var (
+		diff       = map[string]any{}
+		systems    = map[string][]any{}
+		talkgroups = map[string][]any{}
+	)
+
+	getId := func(m map[string]any) string {
+		return fmt.Sprintf("%v", m["id"])
+	}
+
+	withoutTalkgroups := func(system SystemMap) SystemMap {
+		m := SystemMap{}
+		for k, v := range system {
+			if k != "talkgroups" {
+				m[k] = v
+			}
+		}
+		return m
+	}
+
+	getTalkgroups := func(system SystemMap) map[string]TalkgroupMap {
+		m := map[string]TalkgroupMap{}
+		switch v := system["talkgroups"].(type) {
+		case TalkgroupsMap:
+			for _, talkgroup := range v {
+				m[getId(talkgroup)] = talkgroup
+			}
+		}
+		return m
+	}
+
+	fromSystems := map[string]SystemMap{}
+	for _, system := range from {
+		fromSystems[getId(system)] = system
+	}
+
+	for _, system := range to {
+		id := getId(system)
+
+		previous, ok := fromSystems[id]
+		if !ok {
+			systems["added"] = append(systems["added"], system)
+			continue
+		}
+
+		delete(fromSystems, id)
+
+		if !isConfigEqual(withoutTalkgroups(previous), withoutTalkgroups(system)) {
+			systems["changed"] = append(systems["changed"], withoutTalkgroups(system))
+		}
+
+		fromTalkgroups := getTalkgroups(previous)
+
+		for tgId, talkgroup := range getTalkgroups(system) {
+			if fromTalkgroup, ok := fromTalkgroups[tgId]; !ok {
+				talkgroups["added"] = append(talkgroups["added"], map[string]any{"system": system["id"], "talkgroup": talkgroup})
+			} else if !isConfigEqual(fromTalkgroup, talkgroup) {
+				talkgroups["changed"] = append(talkgroups["changed"], map[string]any{"system": system["id"], "talkgroup": talkgroup})
+			}
+			delete(fromTalkgroups, tgId)
+		}
+
+		for _, talkgroup := range fromTalkgroups {
+			talkgroups["removed"] = append(talkgroups["removed"], map[string]any{"system": system["id"], "talkgroup": talkgroup["id"]})
+		}
+	}
+
+	for _, system := range fromSystems {
+		systems["removed"] = append(systems["removed"], system["id"])
+	}
+
+	if len(systems) > 0 {
+		diff["systems"] = systems
+	}
+
+	if len(talkgroups) > 0 {
+		diff["talkgroups"] = talkgroups
+	}
+
+	return diff
+}
+
+// getConfigMapDiff diffs the groups or tags maps by label.
+func getConfigMapDiff(from map[string]map[uint][]uint, to map[string]map[uint][]uint) map[string]any {
+	var (
+		changed = map[string]map[uint][]uint{}
+		diff    = map[string]any{}
+		removed = []string{}
+	)
+
+	for label, m := range to {
+		if previous, ok := from[label]; !ok || !isConfigEqual(previous, m) {
+			changed[label] = m
+		}
+	}
+
+	for label := range from {
+		if _, ok := to[label]; !ok {
+			removed = append(removed, label)
+		}
+	}
+
+	if len(changed) > 0 {
+		diff["changed"] = changed
+	}
+
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		diff["removed"] = removed
+	}
+
+	return diff
+}
+
+func isConfigEqual(a any, b any) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+
+	return erra == nil && errb == nil && string(ja) == string(jb)
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newTestSystemsMap(talkgroups ...TalkgroupMap) SystemsMap {
+	return SystemsMap{
+		SystemMap{"id": uint(1), "label": "System", "talkgroups": TalkgroupsMap(talkgroups)},
+	}
+}
+
+func newTestConfigClient(controller *Controller, access *Access) *Client {
+	client := &Client{Controller: controller, Send: make(chan *Message, 8)}
+	client.SetAccess(access)
+
+	return client
+}
+
+func toTestJson(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestGetConfigDiff(t *testing.T) {
+	from := newTestSystemsMap(
+		TalkgroupMap{"id": uint(100), "label": "Fire"},
+		TalkgroupMap{"id": uint(200), "label": "Police"},
+	)
+
+	to := append(newTestSystemsMap(
+		TalkgroupMap{"id": uint(100), "label": "Fire Dispatch"},
+		TalkgroupMap{"id": uint(300), "label": "EMS"},
+	), SystemMap{"id": uint(2), "label": "Other", "talkgroups": TalkgroupsMap{}})
+	to[0]["label"] = "Renamed"
+
+	got := toTestJson(t, getConfigDiff(from, to))
+	want := `{"systems":{"added":[{"id":2,"label":"Other","talkgroups":[]}],"changed":[{"id":1,"label":"Renamed"}]},` +
+		`"talkgroups":{"added":[{"system":1,"talkgroup":{"id":300,"label":"EMS"}}],"changed":[{"system":1,"talkgroup":{"id":100,"label":"Fire Dispatch"}}],"removed":[{"system":1,"talkgroup":200}]}}`
+
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	if diff := getConfigDiff(to[1:], to[:1]); toTestJson(t, diff) != `{"systems":{"added":[{"id":1,"label":"Renamed","talkgroups":[{"id":100,"label":"Fire Dispatch"},{"id":300,"label":"EMS"}]}],"removed":[2]}}` {
+		t.Errorf("unexpected system swap diff %s", toTestJson(t, diff))
+	}
+
+	if diff := getConfigDiff(from, from); len(diff) != 0 {
+		t.Errorf("unexpected diff %v", diff)
+	}
+}
+
+func TestGetConfigMapDiff(t *testing.T) {
+	from := GroupsMap{"Fire": {1: {100}}, "Police": {1: {200}}}
+	to := GroupsMap{"Fire": {1: {100, 300}}, "EMS": {1: {400}}}
+
+	got := toTestJson(t, getConfigMapDiff(from, to))
+	want := `{"changed":{"EMS":{"1":[400]},"Fire":{"1":[100,300]}},"removed":["Police"]}`
+
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if diff := getConfigMapDiff(to, to); len(diff) != 0 {
+		t.Errorf("unexpected diff %v", diff)
+	}
+}
+
+func TestClientSendConfigUpdate(t *testing.T) {
+	controller := newTestController(t)
+
+	client := newTestConfigClient(controller, &Access{Systems: "*"})
+	client.configDiff = true
+
+	systemsMap := newTestSystemsMap(TalkgroupMap{"id": uint(100), "label": "Fire"})
+
+	client.sendConfig(GroupsMap{}, controller.Options, systemsMap, TagsMap{}, 1)
+	<-client.Send
+
+	// a group only change is sent as a diff
+	client.SendConfigUpdate(GroupsMap{"Fire": {1: {100}}}, controller.Options, systemsMap, TagsMap{}, 2)
+
+	message := <-client.Send
+	if message.Command != MessageCommandConfigDiff || toTestJson(t, message.Payload) != `{"from":1,"groups":{"changed":{"Fire":{"1":[100]}}},"version":2}` {
+		t.Fatalf("unexpected message %s %s", message.Command, toTestJson(t, message.Payload))
+	}
+
+	// no change, no message, but the listener is still up to date
+	client.SendConfigUpdate(GroupsMap{"Fire": {1: {100}}}, controller.Options, systemsMap, TagsMap{}, 3)
+
+	if len(client.Send) != 0 || client.configVersion != 3 {
+		t.Fatalf("unexpected update, %d messages at version %d", len(client.Send), client.configVersion)
+	}
+
+	// versions diverge, the listener gets the full config
+	client.SendConfigUpdate(GroupsMap{}, controller.Options, systemsMap, TagsMap{}, 5)
+
+	if message = <-client.Send; message.Command != MessageCommandConfig {
+		t.Fatalf("got %s, want a full config", message.Command)
+	}
+
+	if client.configVersion != 5 {
+		t.Errorf("got version %d, want 5", client.configVersion)
+	}
+}
+
+func TestClientsEmitConfigUpdateRestricted(t *testing.T) {
+	controller := newTestController(t)
+
+	anonymous := newTestConfigClient(controller, &Access{})
+	authorized := newTestConfigClient(controller, &Access{Ident: "ident", Systems: "*"})
+
+	controller.Clients.Add(anonymous)
+	controller.Clients.Add(authorized)
+
+	controller.Clients.EmitConfigUpdate(controller.Groups, controller.Options, controller.Systems, controller.Tags, 1, true)
+
+	if message := <-anonymous.Send; message.Command != MessageCommandPin {
+		t.Errorf("listener without access got %s", message.Command)
+	}
+
+	if message := <-authorized.Send; message.Command != MessageCommandConfig {
+		t.Errorf("listener with access got %s", message.Command)
+	}
+
+	controller.Clients.EmitConfigUpdate(controller.Groups, controller.Options, controller.Systems, controller.Tags, 2, false)
+
+	if message := <-anonymous.Send; message.Command != MessageCommandConfig {
+		t.Errorf("unrestricted listener got %s", message.Command)
+	}
+}
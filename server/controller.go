@@ -27,33 +27,40 @@ import (
 )
 
 type Controller struct {
-	Admin       *Admin
-	Api         *Api
-	Calls       *Calls
-	Config      *Config
-	Database    *Database
-	Accesses    *Accesses
-	Apikeys     *Apikeys
-	Dirwatches  *Dirwatches
-	Downstreams *Downstreams
-	FFMpeg      *FFMpeg
-	Groups      *Groups
-	Logs        *Logs
-	Options     *Options
-	Scheduler   *Scheduler
-	Systems     *Systems
-	Tags        *Tags
-	Clients     *Clients
-	Register    chan *Client
-	Unregister  chan *Client
-	Ingest      chan *Call
-	running     bool
+	Admin           *Admin
+	Alerts          *Alerts
+	Api             *Api
+	Calls           *Calls
+	Config          *Config
+	ConfigUpdater   *ConfigUpdater
+	Database        *Database
+	Accesses        *Accesses
+	Apikeys         *Apikeys
+	Dirwatches      *Dirwatches
+	Downstreams     *Downstreams
+	DownstreamQueue *DownstreamQueue
+	FFMpeg          *FFMpeg
+	Groups          *Groups
+	Logs            *Logs
+	Options         *Options
+	Scheduler       *Scheduler
+	Systems         *Systems
+	Tags            *Tags
+	ToneSets        *ToneSets
+	Upstreams       *Upstreams
+	WebPush         *WebPush
+	Clients         *Clients
+	Register        chan *Client
+	Unregister      chan *Client
+	Ingest          chan *Call
+	running         bool
 }
 
 func NewController(config *Config) *Controller {
 	controller := &Controller{
 		Config:      config,
 		Accesses:    NewAccesses(),
+		Alerts:      NewAlerts(),
 		Apikeys:     NewApikeys(),
 		Calls:       NewCalls(),
 		Dirwatches:  NewDirwatches(),
@@ -64,6 +71,8 @@ func NewController(config *Config) *Controller {
 		Options:     NewOptions(),
 		Systems:     NewSystems(),
 		Tags:        NewTags(),
+		ToneSets:    NewToneSets(),
+		Upstreams:   NewUpstreams(),
 		Clients:     NewClients(),
 		Register:    make(chan *Client, 8192),
 		Unregister:  make(chan *Client, 8192),
@@ -72,8 +81,14 @@ func NewController(config *Config) *Controller {
 
 	controller.Admin = NewAdmin(controller)
 	controller.Api = NewApi(controller)
+	controller.ConfigUpdater = NewConfigUpdater(controller)
 	controller.Database = NewDatabase(config)
+	controller.DownstreamQueue = NewDownstreamQueue(controller)
 	controller.Scheduler = NewScheduler(controller)
+	controller.WebPush = NewWebPush(controller)
+
+	// only the server config can allow alert exec actions, the admin config cannot
+	controller.Options.alertExecDir = config.AlertExecDir
 
 	controller.Logs.setDaemon(config.daemon)
 	controller.Logs.setDatabase(controller.Database)
@@ -82,11 +97,14 @@ func NewController(config *Config) *Controller {
 }
 
 func (controller *Controller) EmitCall(call *Call) {
-	go controller.Downstreams.Send(controller, call)
+	controller.Downstreams.Send(controller, call)
 	go controller.Clients.EmitCall(call, controller.Accesses.IsRestricted())
+	controller.WebPush.Send(call)
 }
 
 func (controller *Controller) EmitConfig() {
+	controller.ConfigUpdater.Next()
+
 	go controller.Clients.EmitConfig(controller.Groups, controller.Options, controller.Systems, controller.Tags, controller.Accesses.IsRestricted())
 	go controller.Admin.BroadcastConfig()
 }
@@ -108,7 +126,7 @@ func (controller *Controller) IngestCall(call *Call) {
 	)
 
 	logCall := func(call *Call, level string, message string) {
-		controller.Logs.LogEvent(level, fmt.Sprintf("newcall: system=%v talkgroup=%v audioUrl=%v file=%v %v", call.System, call.Talkgroup, call.AudioUrl, call.AudioName, message))
+		controller.Logs.LogEvent(level, fmt.Sprintf("newcall: system=%v talkgroup=%v audioUrl=%v file=%v ingestSource=%v %v", call.System, call.Talkgroup, call.AudioUrl, call.AudioName, call.IngestSource, message))
 	}
 
 	logError := func(err error) {
@@ -265,7 +283,7 @@ func (controller *Controller) IngestCall(call *Call) {
 			return
 		}
 
-		controller.EmitConfig()
+		controller.ConfigUpdater.Emit()
 	}
 
 	if system == nil || talkgroup == nil {
@@ -280,12 +298,26 @@ func (controller *Controller) IngestCall(call *Call) {
 		}
 	}
 
+	// tones are detected on the audio as received, the conversion below replaces it
+	var toneAudio []byte
+
 	if call.AudioUrl == "" {
+		if controller.ToneSets.HasAccess(call) {
+			toneAudio = call.Audio
+		}
+
 		if err := controller.FFMpeg.Convert(call, controller.Systems, controller.Tags, controller.Options.AudioConversion, controller.Options.AudioBitrate); err != nil {
 			controller.Logs.LogEvent(LogLevelWarn, err.Error())
 		}
 	}
 
+	switch v := call.Provenance.(type) {
+	case []string:
+		call.Provenance = append(v, controller.Options.serverId)
+	default:
+		call.Provenance = []string{controller.Options.serverId}
+	}
+
 	if id, err = controller.Calls.WriteCall(call, controller.Database); err == nil {
 		call.Id = id
 		call.systemLabel = system.Label
@@ -306,8 +338,14 @@ func (controller *Controller) IngestCall(call *Call) {
 
 		logCall(call, LogLevelInfo, "success")
 
+		controller.Alerts.Process(call, controller)
+
 		controller.EmitCall(call)
 
+		if len(toneAudio) > 0 && !controller.ToneSets.Enqueue(call, toneAudio, controller) {
+			logCall(call, LogLevelWarn, "tone detection skipped, queue is full")
+		}
+
 	} else {
 		logError(err)
 	}
@@ -317,12 +355,40 @@ func (controller *Controller) LogClientsCount() {
 	controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("listeners count is %v", controller.Clients.Count()))
 }
 
+func (controller *Controller) populateCall(call *Call) {
+	system, ok := controller.Systems.GetSystem(call.System)
+	if !ok {
+		return
+	}
+
+	call.systemLabel = system.Label
+
+	talkgroup, ok := system.Talkgroups.GetTalkgroup(call.Talkgroup)
+	if !ok {
+		return
+	}
+
+	call.talkgroupLabel = talkgroup.Label
+	call.talkgroupName = talkgroup.Name
+
+	if group, ok := controller.Groups.GetGroup(talkgroup.GroupId); ok {
+		call.talkgroupGroup = group.Label
+	}
+
+	if tag, ok := controller.Tags.GetTag(talkgroup.TagId); ok {
+		call.talkgroupTag = tag.Label
+	}
+}
+
 func (controller *Controller) ProcessMessage(client *Client, message *Message) error {
 	if message.Command == MessageCommandVersion {
-		controller.ProcessMessageCommandVersion(client)
+		controller.ProcessMessageCommandVersion(client, message)
 
-	} else if controller.Accesses.IsRestricted() && client.Access.Systems == nil && message.Command != MessageCommandPin {
-		client.Send <- &Message{Command: MessageCommandPin}
+	} else if client.revoked.Load() {
+		client.enqueue(&Message{Command: MessageCommandExpired})
+
+	} else if controller.Accesses.IsRestricted() && client.GetAccess().Systems == nil && message.Command != MessageCommandPin {
+		client.enqueue(&Message{Command: MessageCommandPin})
 
 	} else if message.Command == MessageCommandCall {
 		if err := controller.ProcessMessageCommandCall(client, message); err != nil {
@@ -344,6 +410,9 @@ func (controller *Controller) ProcessMessage(client *Client, message *Message) e
 		if err := controller.ProcessMessageCommandPin(client, message); err != nil {
 			return err
 		}
+
+	} else if message.Command == MessageCommandPushId {
+		controller.ProcessMessageCommandPushId(client, message)
 	}
 
 	return nil
@@ -372,8 +441,8 @@ func (controller *Controller) ProcessMessageCommandCall(client *Client, message
 		return err
 	}
 
-	if !controller.Accesses.IsRestricted() || client.Access.HasAccess(call) {
-		client.Send <- &Message{Command: MessageCommandCall, Payload: call, Flag: message.Flag}
+	if !controller.Accesses.IsRestricted() || client.GetAccess().HasAccess(call) {
+		client.enqueue(&Message{Command: MessageCommandCall, Payload: call, Flag: message.Flag})
 	}
 
 	return nil
@@ -385,7 +454,7 @@ func (controller *Controller) ProcessMessageCommandListCall(client *Client, mess
 		searchOptions := CallsSearchOptions{searchPatchedTalkgroups: controller.Options.SearchPatchedTalkgroups}
 		searchOptions.fromMap(v)
 		if searchResults, err := controller.Calls.Search(&searchOptions, client); err == nil {
-			client.Send <- &Message{Command: MessageCommandListCall, Payload: searchResults}
+			client.enqueue(&Message{Command: MessageCommandListCall, Payload: searchResults})
 		} else {
 			return fmt.Errorf("controller.processmessage.commandlistcall: %v", err)
 		}
@@ -395,7 +464,11 @@ func (controller *Controller) ProcessMessageCommandListCall(client *Client, mess
 
 func (controller *Controller) ProcessMessageCommandLivefeedMap(client *Client, message *Message) {
 	client.Livefeed.FromMap(message.Payload)
-	client.Send <- &Message{Command: MessageCommandLivefeedMap, Payload: !client.Livefeed.IsAllOff()}
+	client.enqueue(&Message{Command: MessageCommandLivefeedMap, Payload: !client.Livefeed.IsAllOff()})
+
+	if controller.Clients.Has(client) {
+		go controller.Admin.BroadcastListener("updated", client)
+	}
 }
 
 func (controller *Controller) ProcessMessageCommandPin(client *Client, message *Message) error {
@@ -410,37 +483,38 @@ func (controller *Controller) ProcessMessageCommandPin(client *Client, message *
 
 		client.AuthCount++
 		if client.AuthCount > maxAuthCount {
-			client.Send <- &Message{Command: MessageCommandPin}
+			client.enqueue(&Message{Command: MessageCommandPin})
 			return nil
 		}
 
 		if controller.Accesses.IsRestricted() {
 			code := string(b)
-			if access, ok := controller.Accesses.GetAccess(code); ok {
-				client.Access = access
+			access, ok := controller.Accesses.GetAccess(code)
+			if ok {
+				client.SetAccess(access)
 			} else {
 				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("invalid access code %s for ip %s", code, client.GetRemoteAddr()))
-				client.Send <- &Message{Command: MessageCommandPin}
+				client.enqueue(&Message{Command: MessageCommandPin})
 				return nil
 			}
 
 			if client.AuthCount == maxAuthCount {
-				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("locked access for ident %s locked", client.Access.Ident))
-				client.Send <- &Message{Command: MessageCommandPin}
+				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("locked access for ident %s locked", access.Ident))
+				client.enqueue(&Message{Command: MessageCommandPin})
 				return nil
 			}
 
-			if client.Access.HasExpired() {
-				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("expired access for ident %s", client.Access.Ident))
-				client.Send <- &Message{Command: MessageCommandExpired}
+			if access.HasExpired() {
+				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("expired access for ident %s", access.Ident))
+				client.enqueue(&Message{Command: MessageCommandExpired})
 				return nil
 			}
 
-			switch v := client.Access.Limit.(type) {
+			switch v := access.Limit.(type) {
 			case uint:
 				if controller.Clients.AccessCount(client) > int(v) {
-					controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("too many concurrent connections for ident %s, limit is %d", client.Access.Ident, client.Access.Limit))
-					client.Send <- &Message{Command: MessageCommandMax}
+					controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("too many concurrent connections for ident %s, limit is %d", access.Ident, access.Limit))
+					client.enqueue(&Message{Command: MessageCommandMax})
 					return nil
 				}
 			}
@@ -454,14 +528,126 @@ func (controller *Controller) ProcessMessageCommandPin(client *Client, message *
 	return nil
 }
 
-func (controller *Controller) ProcessMessageCommandVersion(client *Client) {
+func (controller *Controller) ProcessMessageCommandPushId(client *Client, message *Message) {
+	var (
+		endpoint     string
+		subscription = &WebPushSubscription{Filters: []WebPushFilter{}}
+	)
+
+	p := map[string]any{"publicKey": controller.WebPush.GetPublicKey()}
+
+	switch v := message.Payload.(type) {
+	case map[string]any:
+		switch s := v["subscription"].(type) {
+		case map[string]any:
+			switch e := s["endpoint"].(type) {
+			case string:
+				endpoint = e
+			}
+
+			switch t := s["expirationTime"].(type) {
+			case float64:
+				subscription.Expiration = time.UnixMilli(int64(t)).UTC()
+			}
+
+			switch k := s["keys"].(type) {
+			case map[string]any:
+				switch a := k["auth"].(type) {
+				case string:
+					subscription.Auth = a
+				}
+
+				switch d := k["p256dh"].(type) {
+				case string:
+					subscription.P256dh = d
+				}
+			}
+		}
+
+		switch f := v["filters"].(type) {
+		case []any:
+			for _, m := range f {
+				switch m := m.(type) {
+				case map[string]any:
+					filter := &WebPushFilter{}
+					subscription.Filters = append(subscription.Filters, *filter.FromMap(m))
+				}
+			}
+		}
+
+		if len(endpoint) == 0 {
+			break
+		}
+
+		if len(subscription.Filters) == 0 {
+			if err := controller.WebPush.Unsubscribe(endpoint, subscription.Auth, controller.Database); err != nil {
+				client.enqueue(&Message{Command: MessageCommandPushId, Payload: map[string]any{"error": err.Error()}})
+				return
+			}
+
+			p["subscribed"] = false
+
+		} else {
+			subscription.Endpoint = endpoint
+			subscription.Ip = client.GetRemoteAddr()
+
+			if access := client.GetAccess(); access != nil {
+				subscription.Ident = access.Ident
+			}
+
+			if err := controller.WebPush.Subscribe(subscription, controller.Database); err != nil {
+				client.enqueue(&Message{Command: MessageCommandPushId, Payload: map[string]any{"error": err.Error()}})
+				return
+			}
+
+			p["subscribed"] = true
+		}
+	}
+
+	client.enqueue(&Message{Command: MessageCommandPushId, Payload: p})
+}
+
+func (controller *Controller) ProcessMessageCommandVersion(client *Client, message *Message) {
 	p := map[string]string{"version": version, "commit": commit}
 
+	// binary audio and config diffs are opt-in, the bundled web app does not ask for them and keeps
+	// the json call and full config messages
+	switch v := message.Payload.(type) {
+	case map[string]any:
+		switch v["audio"] {
+		case ClientAudioBinary, ClientAudioUrl:
+			client.setAudio(v["audio"].(string))
+			p["audio"] = v["audio"].(string)
+		}
+
+		if v["config"] == ClientConfigDiff {
+			client.setConfigDiff(true)
+			p["config"] = ClientConfigDiff
+		}
+
+		switch q := v["queue"].(type) {
+		case map[string]any:
+			var maxAge, maxLength uint
+
+			if f, ok := q["maxAge"].(float64); ok && f > 0 {
+				maxAge = uint(f)
+			}
+
+			if f, ok := q["maxLength"].(float64); ok && f > 0 {
+				maxLength = uint(f)
+			}
+
+			policy, _ := q["policy"].(string)
+
+			client.Queue.SetPolicy(policy, maxLength, maxAge)
+		}
+	}
+
 	if len(controller.Options.Branding) > 0 {
 		p["branding"] = controller.Options.Branding
 	}
 
-	client.Send <- &Message{Command: MessageCommandVersion, Payload: p}
+	client.enqueue(&Message{Command: MessageCommandVersion, Payload: p})
 }
 
 func (controller *Controller) Start() error {
@@ -482,6 +668,9 @@ func (controller *Controller) Start() error {
 	if err = controller.Accesses.Read(controller.Database); err != nil {
 		return err
 	}
+	if err = controller.Alerts.Read(controller.Database); err != nil {
+		return err
+	}
 	if err = controller.Apikeys.Read(controller.Database); err != nil {
 		return err
 	}
@@ -503,6 +692,19 @@ func (controller *Controller) Start() error {
 	if err = controller.Tags.Read(controller.Database); err != nil {
 		return err
 	}
+	if err = controller.ToneSets.Read(controller.Database); err != nil {
+		return err
+	}
+	if err = controller.Upstreams.Read(controller.Database); err != nil {
+		return err
+	}
+	if err = controller.WebPush.Read(controller.Database); err != nil {
+		return err
+	}
+
+	if err = controller.WebPush.Init(controller.Options.vapidPrivateKey); err != nil {
+		return err
+	}
 
 	if err = controller.Admin.Start(); err != nil {
 		return err
@@ -510,6 +712,9 @@ func (controller *Controller) Start() error {
 	if err = controller.Scheduler.Start(); err != nil {
 		return err
 	}
+	if err = controller.DownstreamQueue.Start(); err != nil {
+		return err
+	}
 
 	go func() {
 		c := make(chan os.Signal, 8)
@@ -525,6 +730,14 @@ func (controller *Controller) Start() error {
 		}
 	}()
 
+	go func() {
+		for range time.Tick(apikeyTouchInterval) {
+			if err := controller.Apikeys.FlushTouches(controller.Database); err != nil {
+				controller.Logs.LogEvent(LogLevelError, err.Error())
+			}
+		}
+	}()
+
 	go func() {
 		const (
 			minTimeout = 3
@@ -561,23 +774,32 @@ func (controller *Controller) Start() error {
 		for {
 			select {
 			case client := <-controller.Register:
-				controller.Clients.Add(client)
-				doClientsCount()
+				if controller.Clients.Add(client) {
+					doClientsCount()
+					go controller.Admin.BroadcastListener("connected", client)
+				}
 
 			case client := <-controller.Unregister:
 				controller.Clients.Remove(client)
 				doClientsCount()
+				go controller.Admin.BroadcastListener("disconnected", client)
 			}
 		}
 	}()
 
 	controller.Dirwatches.Start(controller)
+	controller.Upstreams.Start(controller)
 
 	return nil
 }
 
 func (controller *Controller) Terminate() {
 	controller.Dirwatches.Stop()
+	controller.Upstreams.Stop()
+
+	if err := controller.Apikeys.FlushTouches(controller.Database); err != nil {
+		log.Println(err)
+	}
 
 	if err := controller.Database.Sql.Close(); err != nil {
 		log.Println(err)
@@ -0,0 +1,93 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// newTestController returns a controller backed by a throwaway sqlite database, it is not started.
+func newTestController(t *testing.T) *Controller {
+	t.Helper()
+
+	controller := NewController(&Config{
+		BaseDir: t.TempDir(),
+		DbFile:  "rdio-scanner.db",
+		DbType:  DbTypeSqlite,
+	})
+
+	t.Cleanup(func() {
+		controller.Database.Sql.Close()
+	})
+
+	return controller
+}
+
+func TestControllerIngestCallProvenance(t *testing.T) {
+	controller := newTestController(t)
+
+	if err := controller.Options.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	controller.Systems.FromMap([]any{map[string]any{
+		"id":         float64(1),
+		"label":      "System",
+		"talkgroups": []any{map[string]any{"id": float64(150), "label": "TG"}},
+	}})
+
+	if err := controller.Systems.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Systems.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, provenance := range [][]string{nil, {"other server"}} {
+		call := newTestDownstreamCall()
+		call.Audio = bytes.Repeat([]byte("audio"), 20)
+		call.DateTime = call.DateTime.Add(time.Duration(i) * time.Minute)
+		if provenance != nil {
+			call.Provenance = provenance
+		}
+
+		controller.IngestCall(call)
+
+		id, ok := call.Id.(uint)
+		if !ok {
+			t.Fatalf("call %d not written", i)
+		}
+
+		call, err := controller.Calls.GetCall(id, controller.Database)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := append(provenance, controller.Options.serverId)
+
+		hops, ok := call.Provenance.([]string)
+		if !ok || len(hops) != len(want) {
+			t.Fatalf("got provenance %v, want %v", call.Provenance, want)
+		}
+		for j := range hops {
+			if hops[j] != want[j] {
+				t.Errorf("got provenance %v, want %v", hops, want)
+			}
+		}
+	}
+}
@@ -144,6 +144,63 @@ func (db *Database) migrate() error {
 	if err == nil {
 		err = db.migration20250322153000(verbose)
 	}
+	if err == nil {
+		err = db.migration20261018090000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018100000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018110000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018120000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018130000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018140000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018150000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018160000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018170000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018180000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018190000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018200000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018210000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018220000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018230000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018240000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018250000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018260000(verbose)
+	}
+	if err == nil {
+		err = db.migration20261018270000(verbose)
+	}
 
 	return err
 }
@@ -654,6 +711,331 @@ func (db *Database) migration20250322153000(verbose bool) error {
 	return db.migrateWithSchema("migration20250322153000-audio-column-nullable", queries, verbose)
 }
 
+func (db *Database) migration20261018090000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column remoteSystem varchar(255)",
+			"alter table rdioScannerDownstreams add column type varchar(255)",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `remoteSystem` varchar(255)",
+			"alter table `rdioScannerDownstreams` add column `type` varchar(255)",
+		}
+	}
+	return db.migrateWithSchema("20261018090000-downstream-type", queries, verbose)
+}
+
+func (db *Database) migration20261018100000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `rdioScannerDownstreamQueue` (`_id` integer primary key autoincrement, `attempts` integer not null default 0, `callId` integer not null, `createdAt` datetime not null, `dead` tinyint(1) default 0, `downstreamId` integer not null, `error` text, `nextAttempt` datetime not null, `system` integer not null, `talkgroup` integer not null)",
+			"create index `rdio_scanner_downstream_queue_downstream_id_system_talkgroup` on `rdioScannerDownstreamQueue` (`downstreamId`, `system`, `talkgroup`)",
+		}
+	} else if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"create table rdioScannerDownstreamQueue (_id serial primary key, attempts integer not null default 0, callId integer not null, createdAt timestamp not null, dead boolean default false, downstreamId integer not null, error text, nextAttempt timestamp not null, system integer not null, talkgroup integer not null)",
+			"create index rdio_scanner_downstream_queue_downstream_id_system_talkgroup on rdioScannerDownstreamQueue (downstreamId, system, talkgroup)",
+		}
+	} else {
+		queries = []string{
+			"create table `rdioScannerDownstreamQueue` (`_id` integer primary key auto_increment, `attempts` integer not null default 0, `callId` integer not null, `createdAt` datetime not null, `dead` tinyint(1) default 0, `downstreamId` integer not null, `error` text, `nextAttempt` datetime not null, `system` integer not null, `talkgroup` integer not null)",
+			"create index `rdio_scanner_downstream_queue_downstream_id_system_talkgroup` on `rdioScannerDownstreamQueue` (`downstreamId`, `system`, `talkgroup`)",
+		}
+	}
+	return db.migrateWithSchema("20261018100000-downstream-queue", queries, verbose)
+}
+
+func (db *Database) migration20261018110000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column concurrency integer",
+			"alter table rdioScannerDownstreams add column overflow varchar(255)",
+			"alter table rdioScannerDownstreams add column queueSize integer",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `concurrency` integer",
+			"alter table `rdioScannerDownstreams` add column `overflow` varchar(255)",
+			"alter table `rdioScannerDownstreams` add column `queueSize` integer",
+		}
+	}
+	return db.migrateWithSchema("20261018110000-downstream-workers", queries, verbose)
+}
+
+func (db *Database) migration20261018120000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column autoDisable integer",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `autoDisable` integer",
+		}
+	}
+	return db.migrateWithSchema("20261018120000-downstream-auto-disable", queries, verbose)
+}
+
+func (db *Database) migration20261018130000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column remaps text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `remaps` text",
+		}
+	}
+	return db.migrateWithSchema("20261018130000-downstream-remaps", queries, verbose)
+}
+
+func (db *Database) migration20261018140000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerApiKeys add column requireSignature boolean default false",
+			"alter table rdioScannerDownstreams add column sign boolean default false",
+			"alter table rdioScannerDownstreams add column tlsCa text",
+			"alter table rdioScannerDownstreams add column tlsCert text",
+			"alter table rdioScannerDownstreams add column tlsKey text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerApiKeys` add column `requireSignature` tinyint(1) default 0",
+			"alter table `rdioScannerDownstreams` add column `sign` tinyint(1) default 0",
+			"alter table `rdioScannerDownstreams` add column `tlsCa` text",
+			"alter table `rdioScannerDownstreams` add column `tlsCert` text",
+			"alter table `rdioScannerDownstreams` add column `tlsKey` text",
+		}
+	}
+	return db.migrateWithSchema("20261018140000-downstream-signing", queries, verbose)
+}
+
+func (db *Database) migration20261018150000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerCalls add column provenance text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerCalls` add column `provenance` text",
+		}
+	}
+	return db.migrateWithSchema("20261018150000-call-provenance", queries, verbose)
+}
+
+func (db *Database) migration20261018160000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `rdioScannerUpstreams` (`_id` integer primary key autoincrement, `accessCode` varchar(255) not null default '', `disabled` tinyint(1) default 0, `order` integer, `remaps` text, `systems` text not null, `url` varchar(255) not null)",
+		}
+	} else if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"create table rdioScannerUpstreams (_id serial primary key, accessCode varchar(255) not null default '', disabled boolean default false, \"order\" integer, remaps text, systems text not null, url varchar(255) not null)",
+		}
+	} else {
+		queries = []string{
+			"create table `rdioScannerUpstreams` (`_id` integer primary key auto_increment, `accessCode` varchar(255) not null default '', `disabled` tinyint(1) default 0, `order` integer, `remaps` text, `systems` text not null, `url` varchar(255) not null)",
+		}
+	}
+	return db.migrateWithSchema("20261018160000-upstreams", queries, verbose)
+}
+
+func (db *Database) migration20261018170000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerCalls add column ingestSource varchar(255)",
+			"create index rdio_scanner_calls_ingest_source on rdioScannerCalls (ingestSource)",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerCalls` add column `ingestSource` varchar(255)",
+			"create index `rdio_scanner_calls_ingest_source` on `rdioScannerCalls` (`ingestSource`)",
+		}
+	}
+	return db.migrateWithSchema("20261018170000-call-ingest-source", queries, verbose)
+}
+
+func (db *Database) migration20261018180000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerApiKeys add column rateLimit integer",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerApiKeys` add column `rateLimit` integer",
+		}
+	}
+	return db.migrateWithSchema("20261018180000-apikey-rate-limit", queries, verbose)
+}
+
+func (db *Database) migration20261018190000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerApiKeys add column allowedCidrs text",
+			"alter table rdioScannerApiKeys add column callsCount integer not null default 0",
+			"alter table rdioScannerApiKeys add column expiration timestamp",
+			"alter table rdioScannerApiKeys add column lastUsedAt timestamp",
+			"alter table rdioScannerApiKeys add column lastUsedIp varchar(255)",
+			"alter table rdioScannerApiKeys add column scopes text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerApiKeys` add column `allowedCidrs` text",
+			"alter table `rdioScannerApiKeys` add column `callsCount` integer not null default 0",
+			"alter table `rdioScannerApiKeys` add column `expiration` datetime",
+			"alter table `rdioScannerApiKeys` add column `lastUsedAt` datetime",
+			"alter table `rdioScannerApiKeys` add column `lastUsedIp` varchar(255)",
+			"alter table `rdioScannerApiKeys` add column `scopes` text",
+		}
+	}
+	return db.migrateWithSchema("20261018190000-apikey-scopes", queries, verbose)
+}
+
+func (db *Database) migration20261018200000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `rdioScannerPushSubscriptions` (`_id` integer primary key autoincrement, `auth` varchar(255) not null, `createdAt` datetime not null, `endpoint` text not null, `expiration` datetime, `filters` text not null, `ident` varchar(255) not null default '', `p256dh` varchar(255) not null)",
+		}
+	} else if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"create table rdioScannerPushSubscriptions (_id serial primary key, auth varchar(255) not null, createdAt timestamp not null, endpoint text not null, expiration timestamp, filters text not null, ident varchar(255) not null default '', p256dh varchar(255) not null)",
+		}
+	} else {
+		queries = []string{
+			"create table `rdioScannerPushSubscriptions` (`_id` integer primary key auto_increment, `auth` varchar(255) not null, `createdAt` datetime not null, `endpoint` text not null, `expiration` datetime, `filters` text not null, `ident` varchar(255) not null default '', `p256dh` varchar(255) not null)",
+		}
+	}
+	return db.migrateWithSchema("20261018200000-push-subscriptions", queries, verbose)
+}
+
+func (db *Database) migration20261018210000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `rdioScannerAlerts` (`_id` integer primary key autoincrement, `actions` text not null, `cooldown` integer not null default 0, `disabled` tinyint(1) default 0, `emergency` tinyint(1) default 0, `groups` text, `label` varchar(255) not null default '', `order` integer, `systems` text not null, `tags` text, `timeFrom` varchar(5) not null default '', `timeTo` varchar(5) not null default '', `units` text)",
+		}
+	} else if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"create table rdioScannerAlerts (_id serial primary key, actions text not null, cooldown integer not null default 0, disabled boolean default false, emergency boolean default false, \"groups\" text, label varchar(255) not null default '', \"order\" integer, systems text not null, tags text, timeFrom varchar(5) not null default '', timeTo varchar(5) not null default '', units text)",
+		}
+	} else {
+		queries = []string{
+			"create table `rdioScannerAlerts` (`_id` integer primary key auto_increment, `actions` text not null, `cooldown` integer not null default 0, `disabled` tinyint(1) default 0, `emergency` tinyint(1) default 0, `groups` text, `label` varchar(255) not null default '', `order` integer, `systems` text not null, `tags` text, `timeFrom` varchar(5) not null default '', `timeTo` varchar(5) not null default '', `units` text)",
+		}
+	}
+	return db.migrateWithSchema("20261018210000-alerts", queries, verbose)
+}
+
+func (db *Database) migration20261018220000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column attachAudio boolean default false",
+			"alter table rdioScannerDownstreams add column template text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `attachAudio` tinyint(1) default 0",
+			"alter table `rdioScannerDownstreams` add column `template` text",
+		}
+	}
+	return db.migrateWithSchema("20261018220000-downstream-webhooks", queries, verbose)
+}
+
+func (db *Database) migration20261018230000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypeSqlite {
+		queries = []string{
+			"create table `rdioScannerToneSets` (`_id` integer primary key autoincrement, `a` real not null default 0, `b` real not null default 0, `label` varchar(255) not null default '', `order` integer, `systems` text not null)",
+			"alter table `rdioScannerAlerts` add column `toneSets` text",
+			"alter table `rdioScannerCalls` add column `tones` text",
+		}
+	} else if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"create table rdioScannerToneSets (_id serial primary key, a double precision not null default 0, b double precision not null default 0, label varchar(255) not null default '', \"order\" integer, systems text not null)",
+			"alter table rdioScannerAlerts add column toneSets text",
+			"alter table rdioScannerCalls add column tones text",
+		}
+	} else {
+		queries = []string{
+			"create table `rdioScannerToneSets` (`_id` integer primary key auto_increment, `a` double not null default 0, `b` double not null default 0, `label` varchar(255) not null default '', `order` integer, `systems` text not null)",
+			"alter table `rdioScannerAlerts` add column `toneSets` text",
+			"alter table `rdioScannerCalls` add column `tones` text",
+		}
+	}
+	return db.migrateWithSchema("20261018230000-tone-sets", queries, verbose)
+}
+
+func (db *Database) migration20261018240000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerDownstreams add column signingSecret text",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerDownstreams` add column `signingSecret` text",
+		}
+	}
+	return db.migrateWithSchema("20261018240000-downstream-signing-secrets", queries, verbose)
+}
+
+func (db *Database) migration20261018250000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerPushSubscriptions add column ip varchar(255) not null default ''",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerPushSubscriptions` add column `ip` varchar(255) not null default ''",
+		}
+	}
+	return db.migrateWithSchema("20261018250000-push-subscriptions-ip", queries, verbose)
+}
+
+func (db *Database) migration20261018260000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerCalls add column toneSetIds varchar(255)",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerCalls` add column `toneSetIds` varchar(255)",
+		}
+	}
+	return db.migrateWithSchema("20261018260000-calls-tone-set-ids", queries, verbose)
+}
+
+func (db *Database) migration20261018270000(verbose bool) error {
+	var queries []string
+	if db.Config.DbType == DbTypePostgresql {
+		queries = []string{
+			"alter table rdioScannerSystems add column remoteId varchar(255)",
+			"alter table rdioScannerTalkgroups add column remoteId integer",
+		}
+	} else {
+		queries = []string{
+			"alter table `rdioScannerSystems` add column `remoteId` varchar(255)",
+			"alter table `rdioScannerTalkgroups` add column `remoteId` integer",
+		}
+	}
+	return db.migrateWithSchema("20261018270000-remote-ids", queries, verbose)
+}
+
 func (db *Database) prepareMigration() (bool, error) {
 	var (
 		err     error
@@ -46,26 +46,45 @@ type DefaultDirwatch struct {
 }
 
 type DefaultDownstream struct {
-	systems string
+	concurrency uint
+	overflow    string
+	queueSize   uint
+	systems     string
 }
 
 type DefaultOptions struct {
+	alertWebhookAllowList       string
 	autoPopulate                bool
 	audioConversion             uint
 	audioBitrate                uint
 	dimmerDelay                 uint
 	disableDuplicateDetection   bool
+	downstreamRetryMaxAge       uint
+	downstreamRetryMaxAttempts  uint
 	duplicateDetectionTimeFrame uint
 	keypadBeeps                 string
+	listenerQueueMaxAge         uint
+	listenerQueueMaxLength      uint
+	listenerQueuePolicy         string
 	maxClients                  uint
+	motd                        string
 	playbackGoesLive            bool
 	pruneCallDays               uint
 	pruneLogDays                uint
 	searchPatchedTalkgroups     bool
 	showListenersCount          bool
+	smtpFrom                    string
+	smtpHost                    string
+	smtpPassword                string
+	smtpPort                    uint
+	smtpUsername                string
 	sortTalkgroups              bool
 	tagsToggle                  bool
 	time12hFormat               bool
+	uploadIpRateLimit           uint
+	uploadRateLimit             uint
+	uploadRateLimitBurst        uint
+	webPushAllowPrivate         bool
 }
 
 var defaults Defaults = Defaults{
@@ -85,7 +104,10 @@ var defaults Defaults = Defaults{
 		usePolling:  false,
 	},
 	downstream: DefaultDownstream{
-		systems: "*",
+		concurrency: 2,
+		overflow:    DownstreamOverflowRetryQueue,
+		queueSize:   100,
+		systems:     "*",
 	},
 	groups: []string{
 		"Air",
@@ -97,22 +119,38 @@ var defaults Defaults = Defaults{
 	},
 	keypadBeeps: "uniden",
 	options: DefaultOptions{
+		alertWebhookAllowList:       "",
 		audioConversion:             AUDIO_CONVERSION_ENABLED,
 		audioBitrate:                24,
 		autoPopulate:                true,
 		dimmerDelay:                 5000,
 		disableDuplicateDetection:   false,
+		downstreamRetryMaxAge:       24,
+		downstreamRetryMaxAttempts:  10,
 		duplicateDetectionTimeFrame: 500,
 		keypadBeeps:                 "uniden",
+		listenerQueueMaxAge:         0,
+		listenerQueueMaxLength:      250,
+		listenerQueuePolicy:         ListenerQueuePolicyDropOldest,
 		maxClients:                  200,
+		motd:                        "",
 		playbackGoesLive:            false,
 		pruneCallDays:               7,
 		pruneLogDays:                7,
 		searchPatchedTalkgroups:     false,
 		showListenersCount:          false,
+		smtpFrom:                    "",
+		smtpHost:                    "",
+		smtpPassword:                "",
+		smtpPort:                    587,
+		smtpUsername:                "",
 		sortTalkgroups:              false,
 		tagsToggle:                  false,
 		time12hFormat:               false,
+		uploadIpRateLimit:           0,
+		uploadRateLimit:             0,
+		uploadRateLimitBurst:        10,
+		webPushAllowPrivate:         false,
 	},
 	systems: []System{},
 	tags: []string{
@@ -179,6 +179,7 @@ func (dirwatch *Dirwatch) ingestDefault(p string) error {
 
 	if strings.EqualFold(path.Ext(p), ext) {
 		call := NewCall()
+		call.IngestSource = dirwatch.getIngestSource()
 
 		call.AudioName = filepath.Base(p)
 		call.AudioType = mime.TypeByExtension(path.Ext(p))
@@ -240,6 +241,7 @@ func (dirwatch *Dirwatch) ingestDSDPlus(p string) error {
 	}
 
 	call := NewCall()
+	call.IngestSource = dirwatch.getIngestSource()
 
 	call.AudioName = filepath.Base(p)
 	call.AudioType = mime.TypeByExtension(path.Ext(p))
@@ -287,6 +289,7 @@ func (dirwatch *Dirwatch) ingestSdrTrunk(p string) error {
 	}
 
 	call := NewCall()
+	call.IngestSource = dirwatch.getIngestSource()
 
 	call.AudioName = filepath.Base(p)
 	call.AudioType = mime.TypeByExtension(path.Ext(p))
@@ -343,6 +346,7 @@ func (dirwatch *Dirwatch) ingestTrunkRecorder(p string) error {
 	audioName := base + ext
 
 	call := NewCall()
+	call.IngestSource = dirwatch.getIngestSource()
 
 	call.AudioName = filepath.Base(audioName)
 	call.AudioType = mime.TypeByExtension(path.Ext(audioName))
@@ -974,6 +978,10 @@ func (dirwatches *Dirwatches) Write(db *Database) error {
 	return nil
 }
 
+func (dirwatch *Dirwatch) getIngestSource() string {
+	return fmt.Sprintf("%s:%v:%s", IngestSourceDirwatch, dirwatch.Id, dirwatch.Directory)
+}
+
 func (dirwatch *Dirwatch) isDir(d string) bool {
 	if fi, err := os.Stat(d); err == nil {
 		if fi.IsDir() {
@@ -17,11 +17,16 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"path"
 	"strings"
@@ -31,16 +36,127 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	DownstreamOverflowDropNewest = "drop-newest"
+	DownstreamOverflowDropOldest = "drop-oldest"
+	DownstreamOverflowRetryQueue = "retry-queue"
+)
+
+const (
+	DownstreamTypeBroadcastify  = "broadcastify"
+	DownstreamTypeOpenMHz       = "openmhz"
+	DownstreamTypeRdioScanner   = "rdio-scanner"
+	DownstreamTypeTrunkRecorder = "trunk-recorder"
+	DownstreamTypeWebhook       = "webhook"
+)
+
+var ErrDownstreamLoop = errors.New("call already seen by downstream")
+
+var downstreamHttpClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		ForceAttemptHTTP2:   true,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
 type Downstream struct {
-	Id       any    `json:"_id"`
-	Apikey   string `json:"apiKey"`
-	Disabled bool   `json:"disabled"`
-	Order    any    `json:"order"`
-	Systems  any    `json:"systems"`
-	Url      string `json:"url"`
+	Id            any               `json:"_id"`
+	Apikey        string            `json:"apiKey"`
+	AttachAudio   bool              `json:"attachAudio"`
+	AutoDisable   any               `json:"autoDisable"`
+	Concurrency   any               `json:"concurrency"`
+	Disabled      bool              `json:"disabled"`
+	Kind          any               `json:"type"`
+	Order         any               `json:"order"`
+	Overflow      any               `json:"overflow"`
+	QueueSize     any               `json:"queueSize"`
+	RemoteSystem  any               `json:"remoteSystem"`
+	Remaps        []*Remap          `json:"remaps"`
+	Sign          bool              `json:"sign"`
+	SigningSecret string            `json:"signingSecret"`
+	Status        *DownstreamStatus `json:"status,omitempty"`
+	Systems       any               `json:"systems"`
+	Template      any               `json:"template"`
+	TlsCa         any               `json:"tlsCa"`
+	TlsCert       any               `json:"tlsCert"`
+	TlsKey        any               `json:"tlsKey"`
+	Url           string            `json:"url"`
+	client        *http.Client
+	clientErr     error
+	clientOnce    sync.Once
+}
+
+type DownstreamStatus struct {
+	ConsecutiveFailures uint
+	Failed              uint
+	LastError           string
+	LastErrorTime       time.Time
+	LastSuccessTime     time.Time
+	Sent                uint
+	latency             time.Duration
+	mutex               sync.Mutex
+}
+
+func (status *DownstreamStatus) MarshalJSON() ([]byte, error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	m := map[string]any{
+		"consecutiveFailures": status.ConsecutiveFailures,
+		"failed":              status.Failed,
+		"sent":                status.Sent,
+	}
+
+	if status.Sent > 0 {
+		m["averageLatency"] = status.latency.Milliseconds() / int64(status.Sent)
+	}
+
+	if len(status.LastError) > 0 {
+		m["lastError"] = status.LastError
+		m["lastErrorTime"] = status.LastErrorTime
+	}
+
+	if !status.LastSuccessTime.IsZero() {
+		m["lastSuccessTime"] = status.LastSuccessTime
+	}
+
+	return json.Marshal(m)
+}
+
+func (status *DownstreamStatus) record(latency time.Duration, err error) (failures uint, changed bool) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	if err == nil {
+		changed = status.ConsecutiveFailures > 0 || status.Sent == 0
+		status.ConsecutiveFailures = 0
+		status.LastSuccessTime = time.Now()
+		status.Sent++
+		status.latency += latency
+
+	} else {
+		changed = status.ConsecutiveFailures == 0
+		status.ConsecutiveFailures++
+		status.Failed++
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now()
+	}
+
+	return status.ConsecutiveFailures, changed
 }
 
 func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
+	// a field sent as null is cleared, a field not sent is kept
+	isNull := func(key string) bool {
+		v, ok := m[key]
+		return ok && v == nil
+	}
+
 	switch v := m["_id"].(type) {
 	case float64:
 		downstream.Id = uint(v)
@@ -51,88 +167,737 @@ func (downstream *Downstream) FromMap(m map[string]any) *Downstream {
 		downstream.Apikey = v
 	}
 
+	switch v := m["attachAudio"].(type) {
+	case bool:
+		downstream.AttachAudio = v
+	}
+
+	switch v := m["autoDisable"].(type) {
+	case float64:
+		if v > 0 {
+			downstream.AutoDisable = uint(v)
+		} else {
+			downstream.AutoDisable = nil
+		}
+	case nil:
+		if isNull("autoDisable") {
+			downstream.AutoDisable = nil
+		}
+	}
+
+	switch v := m["concurrency"].(type) {
+	case float64:
+		if v > 0 {
+			downstream.Concurrency = uint(v)
+		} else {
+			downstream.Concurrency = nil
+		}
+	case nil:
+		if isNull("concurrency") {
+			downstream.Concurrency = nil
+		}
+	}
+
 	switch v := m["disabled"].(type) {
 	case bool:
 		downstream.Disabled = v
 	}
 
-	switch v := m["order"].(type) {
-	case float64:
-		downstream.Order = uint(v)
+	switch v := m["order"].(type) {
+	case float64:
+		downstream.Order = uint(v)
+	}
+
+	switch v := m["overflow"].(type) {
+	case string:
+		switch v {
+		case DownstreamOverflowDropNewest, DownstreamOverflowDropOldest, DownstreamOverflowRetryQueue:
+			downstream.Overflow = v
+		default:
+			downstream.Overflow = nil
+		}
+	case nil:
+		if isNull("overflow") {
+			downstream.Overflow = nil
+		}
+	}
+
+	switch v := m["queueSize"].(type) {
+	case float64:
+		if v > 0 {
+			downstream.QueueSize = uint(v)
+		} else {
+			downstream.QueueSize = nil
+		}
+	case nil:
+		if isNull("queueSize") {
+			downstream.QueueSize = nil
+		}
+	}
+
+	switch v := m["remoteSystem"].(type) {
+	case string:
+		if len(v) > 0 {
+			downstream.RemoteSystem = v
+		} else {
+			downstream.RemoteSystem = nil
+		}
+	case float64:
+		downstream.RemoteSystem = fmt.Sprintf("%v", uint(v))
+	case nil:
+		if isNull("remoteSystem") {
+			downstream.RemoteSystem = nil
+		}
+	}
+
+	switch v := m["remaps"].(type) {
+	case []any:
+		downstream.Remaps = []*Remap{}
+		for _, f := range v {
+			switch m := f.(type) {
+			case map[string]any:
+				downstream.Remaps = append(downstream.Remaps, (&Remap{}).FromMap(m))
+			}
+		}
+	case nil:
+		if downstream.Remaps == nil || isNull("remaps") {
+			downstream.Remaps = []*Remap{}
+		}
+	}
+
+	switch v := m["sign"].(type) {
+	case bool:
+		downstream.Sign = v
+	}
+
+	switch v := m["signingSecret"].(type) {
+	case string:
+		downstream.SigningSecret = v
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			downstream.Systems = string(b)
+		}
+	case string:
+		downstream.Systems = v
+	}
+
+	switch v := m["template"].(type) {
+	case string:
+		if len(v) > 0 {
+			downstream.Template = v
+		} else {
+			downstream.Template = nil
+		}
+	case nil:
+		if isNull("template") {
+			downstream.Template = nil
+		}
+	}
+
+	switch v := m["tlsCa"].(type) {
+	case string:
+		if len(v) > 0 {
+			downstream.TlsCa = v
+		} else {
+			downstream.TlsCa = nil
+		}
+	case nil:
+		if isNull("tlsCa") {
+			downstream.TlsCa = nil
+		}
+	}
+
+	switch v := m["tlsCert"].(type) {
+	case string:
+		if len(v) > 0 {
+			downstream.TlsCert = v
+		} else {
+			downstream.TlsCert = nil
+		}
+	case nil:
+		if isNull("tlsCert") {
+			downstream.TlsCert = nil
+		}
+	}
+
+	switch v := m["tlsKey"].(type) {
+	case string:
+		if len(v) > 0 {
+			downstream.TlsKey = v
+		} else {
+			downstream.TlsKey = nil
+		}
+	case nil:
+		if isNull("tlsKey") {
+			downstream.TlsKey = nil
+		}
+	}
+
+	switch v := m["type"].(type) {
+	case string:
+		downstream.Kind = v
+	}
+
+	switch v := m["url"].(type) {
+	case string:
+		downstream.Url = v
+	}
+
+	return downstream
+}
+
+// settings returns a copy of the configured fields, without the client built from them
+func (downstream *Downstream) settings() *Downstream {
+	return &Downstream{
+		Id:            downstream.Id,
+		Apikey:        downstream.Apikey,
+		AttachAudio:   downstream.AttachAudio,
+		AutoDisable:   downstream.AutoDisable,
+		Concurrency:   downstream.Concurrency,
+		Disabled:      downstream.Disabled,
+		Kind:          downstream.Kind,
+		Order:         downstream.Order,
+		Overflow:      downstream.Overflow,
+		QueueSize:     downstream.QueueSize,
+		RemoteSystem:  downstream.RemoteSystem,
+		Remaps:        downstream.Remaps,
+		Sign:          downstream.Sign,
+		SigningSecret: downstream.SigningSecret,
+		Systems:       downstream.Systems,
+		Template:      downstream.Template,
+		TlsCa:         downstream.TlsCa,
+		TlsCert:       downstream.TlsCert,
+		TlsKey:        downstream.TlsKey,
+		Url:           downstream.Url,
+	}
+}
+
+func (downstream *Downstream) HasAccess(call *Call) bool {
+	return hasSystemsAccess(downstream.Systems, call)
+}
+
+func (downstream *Downstream) GetConcurrency() uint {
+	switch v := downstream.Concurrency.(type) {
+	case uint:
+		if v > 0 {
+			return v
+		}
+	}
+
+	return defaults.downstream.concurrency
+}
+
+func (downstream *Downstream) GetOverflow() string {
+	switch v := downstream.Overflow.(type) {
+	case string:
+		if len(v) > 0 {
+			return v
+		}
+	}
+
+	return defaults.downstream.overflow
+}
+
+func (downstream *Downstream) GetQueueSize() uint {
+	switch v := downstream.QueueSize.(type) {
+	case uint:
+		if v > 0 {
+			return v
+		}
+	}
+
+	return defaults.downstream.queueSize
+}
+
+func (downstream *Downstream) Remap(call *Call) *Call {
+	return remapCall(downstream.Remaps, call)
+}
+
+func (downstream *Downstream) Send(controller *Controller, call *Call) error {
+	var err error
+
+	remoteSystem, remoteTalkgroup := downstream.getRemoteIds(call, controller.Systems)
+
+	// units belong to the local system, look them up before a remap changes it
+	var units []string
+	if downstream.Kind == DownstreamTypeWebhook {
+		units = getCallUnitLabels(call, controller.Systems)
+	}
+
+	call = downstream.Remap(call)
+
+	start := time.Now()
+
+	switch downstream.Kind {
+	case DownstreamTypeBroadcastify:
+		err = downstream.sendBroadcastify(call, remoteSystem, remoteTalkgroup)
+	case DownstreamTypeOpenMHz:
+		err = downstream.sendOpenMHz(call, remoteSystem, remoteTalkgroup)
+	case DownstreamTypeTrunkRecorder:
+		err = downstream.sendTrunkRecorder(call, remoteSystem, remoteTalkgroup)
+	case DownstreamTypeWebhook:
+		err = downstream.sendWebhook(call, units)
+	default:
+		err = downstream.sendRdioScanner(call)
+	}
+
+	label := fmt.Sprintf("%v", downstream.Id)
+
+	metricDownstreamDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+
+	if err == nil {
+		metricDownstreamRequests.WithLabelValues(label, "success").Inc()
+	} else {
+		metricDownstreamRequests.WithLabelValues(label, "failure").Inc()
+	}
+
+	return err
+}
+
+// getRemoteIds resolves the identifiers the aggregator knows a call by, before it is remapped. a remap
+// of the downstream for the call talkgroup or system wins, then the remote ids configured on the system
+// and the talkgroup, then the downstream remote system which gives openmhz its alphanumeric short name
+// when all systems go to the same one, and finally the local ids.
+func (downstream *Downstream) getRemoteIds(call *Call, systems *Systems) (remoteSystem string, remoteTalkgroup uint) {
+	var (
+		systemRemoteId    any
+		talkgroupRemoteId any
+	)
+
+	if systems != nil {
+		if system, ok := systems.GetSystem(call.System); ok {
+			systemRemoteId = system.RemoteId
+			if talkgroup, ok := system.Talkgroups.GetTalkgroup(call.Talkgroup); ok {
+				talkgroupRemoteId = talkgroup.RemoteId
+			}
+		}
+	}
+
+	systemRemap, talkgroupRemap := matchRemaps(downstream.Remaps, call)
+
+	for _, remap := range []*Remap{talkgroupRemap, systemRemap} {
+		if remap == nil {
+			continue
+		}
+
+		if v, ok := remap.RemoteSystem.(uint); ok {
+			remoteSystem = fmt.Sprintf("%v", v)
+			break
+		}
+	}
+
+	if len(remoteSystem) == 0 {
+		if v, ok := systemRemoteId.(string); ok && len(v) > 0 {
+			remoteSystem = v
+		} else if v, ok := downstream.RemoteSystem.(string); ok && len(v) > 0 {
+			remoteSystem = v
+		} else {
+			remoteSystem = fmt.Sprintf("%v", call.System)
+		}
+	}
+
+	if talkgroupRemap != nil && talkgroupRemap.RemoteTalkgroup != nil {
+		remoteTalkgroup = talkgroupRemap.Talkgroup(call.Talkgroup)
+	} else if v, ok := talkgroupRemoteId.(uint); ok {
+		remoteTalkgroup = v
+	} else {
+		remoteTalkgroup = call.Talkgroup
+	}
+
+	return remoteSystem, remoteTalkgroup
+}
+
+func (downstream *Downstream) do(method string, u string, contentType string, body []byte) ([]byte, error) {
+	client, err := downstream.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if downstream.Sign {
+		if len(downstream.SigningSecret) == 0 {
+			return nil, errors.New("no signing secret")
+		}
+
+		if err = SignRequest(req, HashApikey(downstream.Apikey), downstream.SigningSecret, body); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusConflict {
+		return b, fmt.Errorf("%w: %s", ErrDownstreamLoop, res.Status)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return b, fmt.Errorf("bad status: %s", res.Status)
+	}
+
+	return b, nil
+}
+
+func (downstream *Downstream) getClient() (*http.Client, error) {
+	downstream.clientOnce.Do(func() {
+		var (
+			ca, _   = downstream.TlsCa.(string)
+			cert, _ = downstream.TlsCert.(string)
+			key, _  = downstream.TlsKey.(string)
+		)
+
+		if len(ca) == 0 && len(cert) == 0 {
+			downstream.client = downstreamHttpClient
+			return
+		}
+
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				downstream.clientErr = errors.New("invalid ca certificate")
+				return
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if len(cert) > 0 {
+			certificate, err := tls.X509KeyPair([]byte(cert), []byte(key))
+			if err != nil {
+				downstream.clientErr = fmt.Errorf("invalid client certificate: %v", err)
+				return
+			}
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		}
+
+		transport := downstreamHttpClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+
+		downstream.client = &http.Client{
+			Timeout:   downstreamHttpClient.Timeout,
+			Transport: transport,
+		}
+	})
+
+	return downstream.client, downstream.clientErr
+}
+
+func (downstream *Downstream) post(u string, contentType string, body []byte) ([]byte, error) {
+	return downstream.do(http.MethodPost, u, contentType, body)
+}
+
+func (downstream *Downstream) sendBroadcastify(call *Call, remoteSystem string, remoteTalkgroup uint) error {
+	var (
+		audioName string
+		audioType = "application/octet-stream"
+		buf       = bytes.Buffer{}
+		enc       = "mp3"
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendbroadcastify: %w", err)
+	}
+
+	switch v := call.AudioName.(type) {
+	case string:
+		audioName = v
+		if ext := strings.TrimPrefix(path.Ext(v), "."); len(ext) > 0 {
+			enc = ext
+		}
+	}
+
+	switch v := call.AudioType.(type) {
+	case string:
+		if len(v) > 0 {
+			audioType = v
+		}
+	}
+
+	fields := [][2]string{
+		{"apiKey", downstream.Apikey},
+		{"systemId", remoteSystem},
+		{"callDuration", fmt.Sprintf("%.1f", call.getDuration())},
+		{"ts", fmt.Sprintf("%d", call.DateTime.Unix())},
+		{"tg", fmt.Sprintf("%d", remoteTalkgroup)},
+		{"enc", enc},
+	}
+
+	switch v := call.Source.(type) {
+	case uint, int:
+		fields = append(fields, [2]string{"src", fmt.Sprintf("%v", v)})
+	}
+
+	switch v := call.Frequency.(type) {
+	case uint:
+		fields = append(fields, [2]string{"freq", fmt.Sprintf("%.6f", float64(v)/1e6)})
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return formatError(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return formatError(err)
+	}
+
+	b, err := downstream.post(downstream.Url, mw.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return formatError(err)
+	}
+
+	s := strings.SplitN(strings.TrimSpace(string(b)), " ", 2)
+	if len(s) != 2 || s[0] != "0" {
+		return formatError(fmt.Errorf("upload refused: %s, %s", strings.TrimSpace(string(b)), audioName))
+	}
+
+	if _, err := downstream.do(http.MethodPut, strings.TrimSpace(s[1]), audioType, call.Audio); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (downstream *Downstream) sendOpenMHz(call *Call, remoteSystem string, remoteTalkgroup uint) error {
+	var (
+		audioName   string
+		buf         = bytes.Buffer{}
+		duration    = call.getDuration()
+		emergency   = "0"
+		errorCount  uint
+		frequencies = call.getFrequencies()
+		spikeCount  uint
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendopenmhz: %w", err)
+	}
+
+	switch v := call.AudioName.(type) {
+	case string:
+		audioName = v
+	}
+
+	if call.emergency {
+		emergency = "1"
+	}
+
+	for _, f := range frequencies {
+		errorCount += uint(toFloat(f["error_count"]))
+		spikeCount += uint(toFloat(f["spike_count"]))
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	if w, err := mw.CreateFormFile("call", audioName); err == nil {
+		if _, err = w.Write(call.Audio); err != nil {
+			return formatError(err)
+		}
+	} else {
+		return formatError(err)
+	}
+
+	fields := [][2]string{
+		{"api_key", downstream.Apikey},
+		{"call_length", fmt.Sprintf("%.0f", duration)},
+		{"emergency", emergency},
+		{"error_count", fmt.Sprintf("%d", errorCount)},
+		{"spike_count", fmt.Sprintf("%d", spikeCount)},
+		{"start_time", fmt.Sprintf("%d", call.DateTime.Unix())},
+		{"stop_time", fmt.Sprintf("%d", call.DateTime.Add(time.Duration(duration*float64(time.Second))).Unix())},
+		{"talkgroup_num", fmt.Sprintf("%d", remoteTalkgroup)},
+	}
+
+	switch v := call.Frequency.(type) {
+	case uint:
+		fields = append(fields, [2]string{"freq", fmt.Sprintf("%d", v)})
+	}
+
+	if b, err := json.Marshal(frequencies); err == nil {
+		fields = append(fields, [2]string{"freq_list", string(b)})
+	} else {
+		return formatError(err)
+	}
+
+	if b, err := json.Marshal(call.getSources()); err == nil {
+		fields = append(fields, [2]string{"source_list", string(b)})
+	} else {
+		return formatError(err)
+	}
+
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return formatError(err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return formatError(err)
+	}
+
+	u, err := url.Parse(downstream.Url)
+	if err != nil {
+		return formatError(err)
+	}
+
+	u.Path = path.Join(u.Path, remoteSystem, "upload")
+
+	if _, err = downstream.post(u.String(), mw.FormDataContentType(), buf.Bytes()); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (downstream *Downstream) sendTrunkRecorder(call *Call, remoteSystem string, remoteTalkgroup uint) error {
+	var (
+		audioName string
+		buf       = bytes.Buffer{}
+		duration  = call.getDuration()
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendtrunkrecorder: %w", err)
+	}
+
+	switch v := call.AudioName.(type) {
+	case string:
+		audioName = v
+	}
+
+	meta := map[string]any{
+		"call_length": duration,
+		"emergency":   call.emergency,
+		"freqList":    call.getFrequencies(),
+		"srcList":     call.getSources(),
+		"start_time":  call.DateTime.Unix(),
+		"stop_time":   call.DateTime.Add(time.Duration(duration * float64(time.Second))).Unix(),
+		"talkgroup":   remoteTalkgroup,
+	}
+
+	switch v := call.Frequency.(type) {
+	case uint:
+		meta["freq"] = v
+	}
+
+	switch v := call.Patches.(type) {
+	case []uint:
+		meta["patched_talkgroups"] = v
+	}
+
+	switch v := call.talkgroupLabel.(type) {
+	case string:
+		meta["talkgroup_tag"] = v
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	// the key goes first so that the receiver can rate limit before reading the audio, signed
+	// requests identify the key by its hash and do not send it in clear
+	if !downstream.Sign {
+		if w, err := mw.CreateFormField("key"); err == nil {
+			if _, err = w.Write([]byte(downstream.Apikey)); err != nil {
+				return formatError(err)
+			}
+		} else {
+			return formatError(err)
+		}
+	}
+
+	if w, err := mw.CreateFormFile("audio", audioName); err == nil {
+		if _, err = w.Write(call.Audio); err != nil {
+			return formatError(err)
+		}
+	} else {
+		return formatError(err)
+	}
+
+	fields := [][2]string{
+		{"system", remoteSystem},
+	}
+
+	if b, err := json.Marshal(meta); err == nil {
+		fields = append(fields, [2]string{"meta", string(b)})
+	} else {
+		return formatError(err)
 	}
 
-	switch v := m["systems"].(type) {
-	case []any:
+	switch v := call.Provenance.(type) {
+	case []string:
 		if b, err := json.Marshal(v); err == nil {
-			downstream.Systems = string(b)
+			fields = append(fields, [2]string{"provenance", string(b)})
+		} else {
+			return formatError(err)
 		}
-	case string:
-		downstream.Systems = v
 	}
 
-	switch v := m["url"].(type) {
-	case string:
-		downstream.Url = v
+	for name, f := range map[string]any{
+		"audioName":      call.AudioName,
+		"systemLabel":    call.systemLabel,
+		"talkgroupGroup": call.talkgroupGroup,
+		"talkgroupName":  call.talkgroupName,
+		"talkgroupTag":   call.talkgroupTag,
+	} {
+		switch v := f.(type) {
+		case string:
+			fields = append(fields, [2]string{name, v})
+		}
 	}
 
-	return downstream
-}
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return formatError(err)
+		}
+	}
 
-func (downstream *Downstream) HasAccess(call *Call) bool {
-	if downstream.Disabled {
-		return false
+	if err := mw.Close(); err != nil {
+		return formatError(err)
 	}
 
-	switch v := downstream.Systems.(type) {
-	case []any:
-		for _, f := range v {
-			switch v := f.(type) {
-			case map[string]any:
-				switch id := v["id"].(type) {
-				case float64:
-					if id == float64(call.System) {
-						switch tg := v["talkgroups"].(type) {
-						case string:
-							if tg == "*" {
-								return true
-							}
-						case []any:
-							for _, f := range tg {
-								switch tg := f.(type) {
-								case float64:
-									if tg == float64(call.Talkgroup) {
-										return true
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+	u, err := url.Parse(downstream.Url)
+	if err != nil {
+		return formatError(err)
+	}
 
-	case string:
-		if v == "*" {
-			return true
-		}
+	u.Path = path.Join(u.Path, "/api/trunk-recorder-call-upload")
 
+	if _, err = downstream.post(u.String(), mw.FormDataContentType(), buf.Bytes()); err != nil {
+		return formatError(err)
 	}
 
-	return false
+	return nil
 }
 
-func (downstream *Downstream) Send(call *Call) error {
+func (downstream *Downstream) sendRdioScanner(call *Call) error {
 	var (
 		audioName string
 		buf       = bytes.Buffer{}
 	)
 
-	if downstream.Disabled {
-		return nil
-	}
-
 	formatError := func(err error) error {
-		return fmt.Errorf("downstream.send: %s", err.Error())
+		return fmt.Errorf("downstream.send: %w", err)
 	}
 
 	mw := multipart.NewWriter(&buf)
@@ -142,6 +907,17 @@ func (downstream *Downstream) Send(call *Call) error {
 		audioName = v
 	}
 
+	// the key goes first so that the receiver can rate limit before reading the audio
+	if !downstream.Sign {
+		if w, err := mw.CreateFormField("key"); err == nil {
+			if _, err = w.Write([]byte(downstream.Apikey)); err != nil {
+				return formatError(err)
+			}
+		} else {
+			return formatError(err)
+		}
+	}
+
 	if w, err := mw.CreateFormFile("audio", audioName); err == nil {
 		if _, err = w.Write(call.Audio); err != nil {
 			return formatError(err)
@@ -206,17 +982,24 @@ func (downstream *Downstream) Send(call *Call) error {
 		}
 	}
 
-	if w, err := mw.CreateFormField("key"); err == nil {
-		if _, err = w.Write([]byte(downstream.Apikey)); err != nil {
+	switch v := call.Patches.(type) {
+	case []uint:
+		if w, err := mw.CreateFormField("patches"); err == nil {
+			if b, err := json.Marshal(v); err == nil {
+				if _, err = w.Write(b); err != nil {
+					return formatError(err)
+				}
+			} else {
+				return formatError(err)
+			}
+		} else {
 			return formatError(err)
 		}
-	} else {
-		return formatError(err)
 	}
 
-	switch v := call.Patches.(type) {
-	case []uint:
-		if w, err := mw.CreateFormField("patches"); err == nil {
+	switch v := call.Provenance.(type) {
+	case []string:
+		if w, err := mw.CreateFormField("provenance"); err == nil {
 			if b, err := json.Marshal(v); err == nil {
 				if _, err = w.Write(b); err != nil {
 					return formatError(err)
@@ -333,61 +1116,250 @@ func (downstream *Downstream) Send(call *Call) error {
 	if u, err := url.Parse(downstream.Url); err == nil {
 		u.Path = path.Join(u.Path, "/api/call-upload")
 
-		c := http.Client{Timeout: 30 * time.Second}
+		if _, err := downstream.post(u.String(), mw.FormDataContentType(), buf.Bytes()); err != nil {
+			return formatError(err)
+		}
 
-		if res, err := c.Post(u.String(), mw.FormDataContentType(), &buf); err == nil {
-			if res.StatusCode != http.StatusOK {
-				return formatError(fmt.Errorf("bad status: %s", res.Status))
-			}
+	} else {
+		return formatError(err)
+	}
 
-		} else {
+	return nil
+}
+
+// webhooks render the call through a text/template for chat platforms incoming webhooks,
+// with the audio attachment sent as multipart next to a discord style payload_json field
+func (downstream *Downstream) sendWebhook(call *Call, units []string) error {
+	var (
+		body        []byte
+		contentType = "application/json"
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstream.sendwebhook: %w", err)
+	}
+
+	data := getCallTemplateData(call, units)
+
+	switch v := downstream.Template.(type) {
+	case string:
+		s, err := executeTemplate(v, data)
+		if err != nil {
 			return formatError(err)
 		}
+		body = []byte(s)
 
-	} else {
+		// chat platforms only take json, a label with a quote in it would otherwise go out as a broken payload
+		if !json.Valid(body) {
+			return formatError(errors.New("template output is not valid json, quote values with the json function, ie. {{json .talkgroupLabel}}"))
+		}
+
+	default:
+		b, err := json.Marshal(data)
+		if err != nil {
+			return formatError(err)
+		}
+		body = b
+	}
+
+	if downstream.AttachAudio && len(call.Audio) > 0 {
+		buf := bytes.Buffer{}
+		mw := multipart.NewWriter(&buf)
+
+		if err := mw.WriteField("payload_json", string(body)); err != nil {
+			return formatError(err)
+		}
+
+		audioName, audioType := getCallAudioFile(call)
+
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename="%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(audioName))},
+			"Content-Type":        {audioType},
+		})
+		if err != nil {
+			return formatError(err)
+		}
+
+		if _, err = w.Write(call.Audio); err != nil {
+			return formatError(err)
+		}
+
+		if err = mw.Close(); err != nil {
+			return formatError(err)
+		}
+
+		body = buf.Bytes()
+		contentType = mw.FormDataContentType()
+	}
+
+	client, err := downstream.getClient()
+	if err != nil {
+		return formatError(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, downstream.Url, bytes.NewReader(body))
+	if err != nil {
+		return formatError(err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := client.Do(req)
+	if err != nil {
 		return formatError(err)
 	}
 
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	// chat platforms answer with 200, 202 or 204 depending on the vendor
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return formatError(fmt.Errorf("bad status: %s %s", res.Status, strings.TrimSpace(string(b))))
+	}
+
 	return nil
 }
 
 type Downstreams struct {
-	List  []*Downstream
-	mutex sync.Mutex
+	List          []*Downstream
+	broadcast     *time.Timer
+	mutex         sync.Mutex
+	statuses      map[any]*DownstreamStatus
+	statusesMutex sync.Mutex
+	workers       map[any]*DownstreamWorker
+	workersMutex  sync.Mutex
 }
 
 func NewDownstreams() *Downstreams {
 	return &Downstreams{
-		List:  []*Downstream{},
-		mutex: sync.Mutex{},
+		List:          []*Downstream{},
+		mutex:         sync.Mutex{},
+		statuses:      map[any]*DownstreamStatus{},
+		statusesMutex: sync.Mutex{},
+		workers:       map[any]*DownstreamWorker{},
+		workersMutex:  sync.Mutex{},
+	}
+}
+
+// Disable flags a downstream as disabled and persists only that flag, it returns false if it already was.
+func (downstreams *Downstreams) Disable(downstream *Downstream, db *Database) (bool, error) {
+	downstreams.mutex.Lock()
+	defer downstreams.mutex.Unlock()
+
+	if downstream.Disabled {
+		return false, nil
+	}
+
+	downstream.Disabled = true
+
+	q := "update `rdioScannerDownstreams` set `disabled` = ? where `_id` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "update rdioScannerDownstreams set disabled = $1 where _id = $2"
+	}
+	if _, err := db.Sql.Exec(q, true, downstream.Id); err != nil {
+		return true, fmt.Errorf("downstreams.disable: %v", err)
 	}
+
+	return true, nil
 }
 
 func (downstreams *Downstreams) FromMap(f []any) *Downstreams {
 	downstreams.mutex.Lock()
 	defer downstreams.mutex.Unlock()
 
+	// older admin apps do not know about every field, start from the existing
+	// downstream so what is not sent back is kept
+	existing := map[uint]*Downstream{}
+	for _, downstream := range downstreams.List {
+		if id, ok := downstream.Id.(uint); ok {
+			existing[id] = downstream
+		}
+	}
+
 	downstreams.List = []*Downstream{}
 
 	for _, r := range f {
 		switch m := r.(type) {
 		case map[string]any:
 			downstream := &Downstream{}
+			switch id := m["_id"].(type) {
+			case float64:
+				if previous, ok := existing[uint(id)]; ok {
+					downstream = previous.settings()
+				}
+			}
 			downstream.FromMap(m)
 			downstreams.List = append(downstreams.List, downstream)
 		}
 	}
 
+	downstreams.attachStatuses()
+
 	return downstreams
 }
 
+func (downstreams *Downstreams) IsDisabled(downstream *Downstream) bool {
+	downstreams.mutex.Lock()
+	defer downstreams.mutex.Unlock()
+
+	return downstream.Disabled
+}
+
+// GetStatuses returns a snapshot of the downstreams health for the admin
+func (downstreams *Downstreams) GetStatuses() []map[string]any {
+	downstreams.mutex.Lock()
+	defer downstreams.mutex.Unlock()
+
+	statuses := []map[string]any{}
+
+	for _, downstream := range downstreams.List {
+		statuses = append(statuses, map[string]any{
+			"_id":      downstream.Id,
+			"disabled": downstream.Disabled,
+			"status":   downstream.Status,
+			"type":     downstream.Kind,
+			"url":      downstream.Url,
+		})
+	}
+
+	return statuses
+}
+
+func (downstreams *Downstreams) GetDownstream(id uint) (downstream *Downstream, ok bool) {
+	downstreams.mutex.Lock()
+	defer downstreams.mutex.Unlock()
+
+	for _, downstream := range downstreams.List {
+		if downstream.Id == id {
+			return downstream, true
+		}
+	}
+
+	return nil, false
+}
+
 func (downstreams *Downstreams) Read(db *Database) error {
 	var (
-		err     error
-		id      sql.NullFloat64
-		order   sql.NullFloat64
-		rows    *sql.Rows
-		systems string
+		attachAudio   sql.NullBool
+		autoDisable   sql.NullFloat64
+		concurrency   sql.NullFloat64
+		err           error
+		id            sql.NullFloat64
+		kind          sql.NullString
+		order         sql.NullFloat64
+		overflow      sql.NullString
+		queueSize     sql.NullFloat64
+		remaps        sql.NullString
+		remoteSystem  sql.NullString
+		rows          *sql.Rows
+		sign          sql.NullBool
+		signingSecret sql.NullString
+		systems       string
+		template      sql.NullString
+		tlsCa         sql.NullString
+		tlsCert       sql.NullString
+		tlsKey        sql.NullString
 	)
 
 	downstreams.mutex.Lock()
@@ -399,9 +1371,9 @@ func (downstreams *Downstreams) Read(db *Database) error {
 		return fmt.Errorf("downstreams.read: %v", err)
 	}
 
-	q := "select `_id`, `apiKey`, `disabled`, `order`, `systems`, `url` from `rdioScannerDownstreams`"
+	q := "select `_id`, `apiKey`, `attachAudio`, `autoDisable`, `concurrency`, `disabled`, `order`, `overflow`, `queueSize`, `remaps`, `remoteSystem`, `sign`, `signingSecret`, `systems`, `template`, `tlsCa`, `tlsCert`, `tlsKey`, `type`, `url` from `rdioScannerDownstreams`"
 	if db.Config.DbType == DbTypePostgresql {
-		q = "select _id, apiKey, disabled, \"order\", systems, url from rdioScannerDownstreams"
+		q = "select _id, apiKey, attachAudio, autoDisable, concurrency, disabled, \"order\", overflow, queueSize, remaps, remoteSystem, sign, signingSecret, systems, template, tlsCa, tlsCert, tlsKey, type, url from rdioScannerDownstreams"
 	}
 	if rows, err = db.Sql.Query(q); err != nil {
 		return formatError(err)
@@ -410,7 +1382,7 @@ func (downstreams *Downstreams) Read(db *Database) error {
 	for rows.Next() {
 		downstream := &Downstream{}
 
-		if err = rows.Scan(&id, &downstream.Apikey, &downstream.Disabled, &order, &systems, &downstream.Url); err != nil {
+		if err = rows.Scan(&id, &downstream.Apikey, &attachAudio, &autoDisable, &concurrency, &downstream.Disabled, &order, &overflow, &queueSize, &remaps, &remoteSystem, &sign, &signingSecret, &systems, &template, &tlsCa, &tlsCert, &tlsKey, &kind, &downstream.Url); err != nil {
 			break
 		}
 
@@ -422,14 +1394,80 @@ func (downstreams *Downstreams) Read(db *Database) error {
 			downstream.Apikey = uuid.New().String()
 		}
 
+		if attachAudio.Valid {
+			downstream.AttachAudio = attachAudio.Bool
+		}
+
+		if autoDisable.Valid && autoDisable.Float64 > 0 {
+			downstream.AutoDisable = uint(autoDisable.Float64)
+		}
+
+		if concurrency.Valid && concurrency.Float64 > 0 {
+			downstream.Concurrency = uint(concurrency.Float64)
+		}
+
 		if order.Valid && order.Float64 > 0 {
 			downstream.Order = uint(order.Float64)
 		}
 
+		if overflow.Valid && len(overflow.String) > 0 {
+			downstream.Overflow = overflow.String
+		}
+
+		if queueSize.Valid && queueSize.Float64 > 0 {
+			downstream.QueueSize = uint(queueSize.Float64)
+		}
+
+		downstream.Remaps = []*Remap{}
+
+		if remaps.Valid && len(remaps.String) > 0 {
+			var f []any
+			if err := json.Unmarshal([]byte(remaps.String), &f); err == nil {
+				for _, r := range f {
+					switch m := r.(type) {
+					case map[string]any:
+						downstream.Remaps = append(downstream.Remaps, (&Remap{}).FromMap(m))
+					}
+				}
+			}
+		}
+
+		if remoteSystem.Valid && len(remoteSystem.String) > 0 {
+			downstream.RemoteSystem = remoteSystem.String
+		}
+
+		if sign.Valid {
+			downstream.Sign = sign.Bool
+		}
+
+		if signingSecret.Valid {
+			downstream.SigningSecret = signingSecret.String
+		}
+
 		if err = json.Unmarshal([]byte(systems), &downstream.Systems); err != nil {
 			downstream.Systems = []any{}
 		}
 
+		if template.Valid && len(template.String) > 0 {
+			downstream.Template = template.String
+		}
+
+		if tlsCa.Valid && len(tlsCa.String) > 0 {
+			downstream.TlsCa = tlsCa.String
+		}
+
+		if tlsCert.Valid && len(tlsCert.String) > 0 {
+			downstream.TlsCert = tlsCert.String
+		}
+
+		if tlsKey.Valid && len(tlsKey.String) > 0 {
+			downstream.TlsKey = tlsKey.String
+		}
+
+		if kind.Valid && len(kind.String) > 0 {
+			downstream.Kind = kind.String
+		}
+
 		if len(downstream.Url) == 0 {
 			continue
 		}
@@ -439,6 +1477,8 @@ func (downstreams *Downstreams) Read(db *Database) error {
 
 	rows.Close()
 
+	downstreams.attachStatuses()
+
 	if err != nil {
 		return formatError(err)
 	}
@@ -447,25 +1487,108 @@ func (downstreams *Downstreams) Read(db *Database) error {
 }
 
 func (downstreams *Downstreams) Send(controller *Controller, call *Call) {
-	for _, downstream := range downstreams.List {
-		logEvent := func(logLevel string, message string) {
-			controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v %v", call.System, call.Talkgroup, call.AudioName, downstream.Url, message))
+	var (
+		enabled = []*Downstream{}
+		list    []*Downstream
+	)
+
+	downstreams.mutex.Lock()
+	list = append(list, downstreams.List...)
+	for _, downstream := range list {
+		if !downstream.Disabled {
+			enabled = append(enabled, downstream)
 		}
+	}
+	downstreams.mutex.Unlock()
 
-		if downstream.HasAccess(call) {
-			if err := downstream.Send(call); err == nil {
-				logEvent(LogLevelInfo, "success")
-			} else {
-				logEvent(LogLevelError, err.Error())
+	downstreams.workersMutex.Lock()
+	defer downstreams.workersMutex.Unlock()
+
+	for _, downstream := range enabled {
+		if !downstream.HasAccess(call) {
+			continue
+		}
+
+		downstreams.getWorker(controller, downstream).Push(downstream, call)
+	}
+
+	for id, worker := range downstreams.workers {
+		found := false
+		for _, downstream := range list {
+			if downstream.Id == id {
+				found = true
+				break
 			}
 		}
+		if !found {
+			worker.Stop()
+			delete(downstreams.workers, id)
+		}
+	}
+}
+
+// Retry hands a retry queue entry to the downstream worker, it returns false when it could not be taken.
+func (downstreams *Downstreams) Retry(controller *Controller, downstream *Downstream, entry *DownstreamQueueEntry) bool {
+	downstreams.workersMutex.Lock()
+	defer downstreams.workersMutex.Unlock()
+
+	return downstreams.getWorker(controller, downstream).Retry(entry)
+}
+
+func (downstreams *Downstreams) SendTo(controller *Controller, downstream *Downstream, call *Call) error {
+	start := time.Now()
+
+	err := downstream.Send(controller, call)
+
+	downstreams.statusesMutex.Lock()
+	status := downstreams.statuses[downstream.Id]
+	downstreams.statusesMutex.Unlock()
+
+	if status == nil {
+		return err
+	}
+
+	failures, changed := status.record(time.Since(start), err)
+
+	if autoDisable, ok := downstream.AutoDisable.(uint); ok && autoDisable > 0 && failures >= autoDisable {
+		disabled, err := downstreams.Disable(downstream, controller.Database)
+		if err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+
+		if disabled {
+			controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("downstream: %v disabled after %v consecutive failures", downstream.Url, failures))
+
+			status.mutex.Lock()
+			status.ConsecutiveFailures = 0
+			status.mutex.Unlock()
+
+			changed = true
+		}
 	}
+
+	if changed {
+		downstreams.statusesMutex.Lock()
+		if downstreams.broadcast == nil {
+			downstreams.broadcast = time.AfterFunc(2*time.Second, func() {
+				downstreams.statusesMutex.Lock()
+				downstreams.broadcast = nil
+				downstreams.statusesMutex.Unlock()
+
+				controller.Admin.BroadcastConfig()
+			})
+		}
+		downstreams.statusesMutex.Unlock()
+	}
+
+	return err
 }
 
 func (downstreams *Downstreams) Write(db *Database) error {
 	var (
 		count   uint
 		err     error
+		remaps  []byte
 		rows    *sql.Rows
 		rowIds  = []uint{}
 		systems any
@@ -532,6 +1655,10 @@ func (downstreams *Downstreams) Write(db *Database) error {
 			systems = downstream.Systems
 		}
 
+		if remaps, err = json.Marshal(downstream.Remaps); err != nil {
+			break
+		}
+
 		q := "select count(*) from `rdioScannerDownstreams` where `_id` = ?"
 		if db.Config.DbType == DbTypePostgresql {
 			q = "select count(*) from rdioScannerDownstreams where _id = $1"
@@ -541,20 +1668,20 @@ func (downstreams *Downstreams) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			q := "insert into `rdioScannerDownstreams` (`_id`, `apiKey`, `disabled`, `order`, `systems`, `url`) values (?, ?, ?, ?, ?, ?)"
+			q := "insert into `rdioScannerDownstreams` (`_id`, `apiKey`, `attachAudio`, `autoDisable`, `concurrency`, `disabled`, `order`, `overflow`, `queueSize`, `remaps`, `remoteSystem`, `sign`, `signingSecret`, `systems`, `template`, `tlsCa`, `tlsCert`, `tlsKey`, `type`, `url`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "insert into rdioScannerDownstreams (_id, apiKey, disabled, \"order\", systems, url) values ($1, $2, $3, $4, $5, $6)"
+				q = "insert into rdioScannerDownstreams (_id, apiKey, attachAudio, autoDisable, concurrency, disabled, \"order\", overflow, queueSize, remaps, remoteSystem, sign, signingSecret, systems, template, tlsCa, tlsCert, tlsKey, type, url) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)"
 			}
-			if _, err = db.Sql.Exec(q, downstream.Id, downstream.Apikey, downstream.Disabled, downstream.Order, systems, downstream.Url); err != nil {
+			if _, err = db.Sql.Exec(q, downstream.Id, downstream.Apikey, downstream.AttachAudio, downstream.AutoDisable, downstream.Concurrency, downstream.Disabled, downstream.Order, downstream.Overflow, downstream.QueueSize, string(remaps), downstream.RemoteSystem, downstream.Sign, downstream.SigningSecret, systems, downstream.Template, downstream.TlsCa, downstream.TlsCert, downstream.TlsKey, downstream.Kind, downstream.Url); err != nil {
 				break
 			}
 
 		} else {
-			q := "update `rdioScannerDownstreams` set `_id` = ?, `apiKey` = ?, `disabled` = ?, `order` = ?, `systems` = ?, `url` = ? where `_id` = ?"
+			q := "update `rdioScannerDownstreams` set `_id` = ?, `apiKey` = ?, `attachAudio` = ?, `autoDisable` = ?, `concurrency` = ?, `disabled` = ?, `order` = ?, `overflow` = ?, `queueSize` = ?, `remaps` = ?, `remoteSystem` = ?, `sign` = ?, `signingSecret` = ?, `systems` = ?, `template` = ?, `tlsCa` = ?, `tlsCert` = ?, `tlsKey` = ?, `type` = ?, `url` = ? where `_id` = ?"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "update rdioScannerDownstreams set _id = $1, apiKey = $2, disabled = $3, \"order\" = $4, systems = $5, url = $6 where _id = $7"
+				q = "update rdioScannerDownstreams set _id = $1, apiKey = $2, attachAudio = $3, autoDisable = $4, concurrency = $5, disabled = $6, \"order\" = $7, overflow = $8, queueSize = $9, remaps = $10, remoteSystem = $11, sign = $12, signingSecret = $13, systems = $14, template = $15, tlsCa = $16, tlsCert = $17, tlsKey = $18, type = $19, url = $20 where _id = $21"
 			}
-			if _, err = db.Sql.Exec(q, downstream.Id, downstream.Apikey, downstream.Disabled, downstream.Order, systems, downstream.Url, downstream.Id); err != nil {
+			if _, err = db.Sql.Exec(q, downstream.Id, downstream.Apikey, downstream.AttachAudio, downstream.AutoDisable, downstream.Concurrency, downstream.Disabled, downstream.Order, downstream.Overflow, downstream.QueueSize, string(remaps), downstream.RemoteSystem, downstream.Sign, downstream.SigningSecret, systems, downstream.Template, downstream.TlsCa, downstream.TlsCert, downstream.TlsKey, downstream.Kind, downstream.Url, downstream.Id); err != nil {
 				break
 			}
 		}
@@ -566,3 +1693,38 @@ func (downstreams *Downstreams) Write(db *Database) error {
 
 	return nil
 }
+
+// getWorker must be called with the workers mutex held
+func (downstreams *Downstreams) getWorker(controller *Controller, downstream *Downstream) *DownstreamWorker {
+	worker, ok := downstreams.workers[downstream.Id]
+	if ok && (worker.concurrency != downstream.GetConcurrency() || worker.queueSize != downstream.GetQueueSize()) {
+		worker.Stop()
+		ok = false
+	}
+
+	if !ok {
+		worker = NewDownstreamWorker(controller, downstream)
+		downstreams.workers[downstream.Id] = worker
+	}
+
+	return worker
+}
+
+func (downstreams *Downstreams) attachStatuses() {
+	downstreams.statusesMutex.Lock()
+	defer downstreams.statusesMutex.Unlock()
+
+	for _, downstream := range downstreams.List {
+		if downstream.Id == nil {
+			continue
+		}
+
+		status, ok := downstreams.statuses[downstream.Id]
+		if !ok {
+			status = &DownstreamStatus{mutex: sync.Mutex{}}
+			downstreams.statuses[downstream.Id] = status
+		}
+
+		downstream.Status = status
+	}
+}
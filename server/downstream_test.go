@@ -0,0 +1,470 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDownstreamCall() *Call {
+	call := NewCall()
+	call.Audio = []byte("audio")
+	call.AudioName = "call.m4a"
+	call.AudioType = "audio/mp4"
+	call.DateTime = time.Unix(1700000000, 0).UTC()
+	call.System = 1
+	call.Talkgroup = 150
+	return call
+}
+
+func newTestRemaps() []*Remap {
+	return []*Remap{
+		(&Remap{}).FromMap(map[string]any{"system": float64(1), "remoteSystem": float64(7)}),
+		(&Remap{}).FromMap(map[string]any{"system": float64(1), "talkgroupFrom": float64(100), "talkgroupTo": float64(199), "remoteSystem": float64(42), "remoteTalkgroup": float64(1100)}),
+	}
+}
+
+type testDownstreamRequest struct {
+	form   map[string]string
+	method string
+	path   string
+	body   []byte
+}
+
+func newTestDownstreamServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, func() []testDownstreamRequest) {
+	var (
+		mutex    sync.Mutex
+		requests []testDownstreamRequest
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := testDownstreamRequest{form: map[string]string{}, method: r.Method, path: r.URL.Path}
+
+		if r.Method == http.MethodPut {
+			req.body, _ = io.ReadAll(r.Body)
+
+		} else if err := r.ParseMultipartForm(1 << 20); err == nil {
+			for k, v := range r.MultipartForm.Value {
+				req.form[k] = v[0]
+			}
+			for k, v := range r.MultipartForm.File {
+				if f, err := v[0].Open(); err == nil {
+					b, _ := io.ReadAll(f)
+					f.Close()
+					req.form[k] = string(b)
+				}
+			}
+		}
+
+		mutex.Lock()
+		requests = append(requests, req)
+		mutex.Unlock()
+
+		handler(w, r)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server, func() []testDownstreamRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]testDownstreamRequest{}, requests...)
+	}
+}
+
+func TestDownstreamGetRemoteIds(t *testing.T) {
+	call := newTestDownstreamCall()
+
+	system := NewSystem()
+	system.Id = 1
+	system.RemoteId = "sys"
+	system.Talkgroups.List = []*Talkgroup{{Id: 150, RemoteId: uint(9150)}, {Id: 151}}
+
+	systems := NewSystems()
+	systems.List = []*System{system}
+
+	for _, tc := range []struct {
+		name          string
+		remaps        []*Remap
+		remoteSystem  any
+		systems       *Systems
+		talkgroup     uint
+		wantSystem    string
+		wantTalkgroup uint
+	}{
+		{name: "local ids", talkgroup: 150, wantSystem: "1", wantTalkgroup: 150},
+		{name: "downstream default", remoteSystem: "abc", talkgroup: 150, wantSystem: "abc", wantTalkgroup: 150},
+		{name: "configured ids", remoteSystem: "abc", systems: systems, talkgroup: 150, wantSystem: "sys", wantTalkgroup: 9150},
+		{name: "talkgroup without remote id", systems: systems, talkgroup: 151, wantSystem: "sys", wantTalkgroup: 151},
+		{name: "talkgroup remap", remaps: newTestRemaps(), remoteSystem: "abc", systems: systems, talkgroup: 150, wantSystem: "42", wantTalkgroup: 1150},
+		{name: "system remap", remaps: newTestRemaps(), remoteSystem: "abc", systems: systems, talkgroup: 250, wantSystem: "7", wantTalkgroup: 250},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			downstream := &Downstream{Remaps: tc.remaps, RemoteSystem: tc.remoteSystem}
+			c := *call
+			c.Talkgroup = tc.talkgroup
+			if system, talkgroup := downstream.getRemoteIds(&c, tc.systems); system != tc.wantSystem || talkgroup != tc.wantTalkgroup {
+				t.Errorf("got %q/%d, want %q/%d", system, talkgroup, tc.wantSystem, tc.wantTalkgroup)
+			}
+		})
+	}
+}
+
+func TestDownstreamSendBroadcastify(t *testing.T) {
+	var server *httptest.Server
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			fmt.Fprintf(w, "0 %s/upload/slot", server.URL)
+		}
+	})
+
+	downstream := &Downstream{Apikey: "secret", Kind: DownstreamTypeBroadcastify, Remaps: newTestRemaps(), Url: server.URL}
+
+	if err := downstream.Send(&Controller{}, newTestDownstreamCall()); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+
+	for k, v := range map[string]string{"apiKey": "secret", "systemId": "42", "tg": "1150", "ts": "1700000000", "enc": "m4a"} {
+		if reqs[0].form[k] != v {
+			t.Errorf("%s = %q, want %q", k, reqs[0].form[k], v)
+		}
+	}
+
+	if reqs[1].method != http.MethodPut || reqs[1].path != "/upload/slot" || string(reqs[1].body) != "audio" {
+		t.Errorf("unexpected audio upload %+v", reqs[1])
+	}
+}
+
+func TestDownstreamSendOpenMHz(t *testing.T) {
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	call := newTestDownstreamCall()
+	call.emergency = true
+	call.Talkgroup = 250
+
+	downstream := &Downstream{Apikey: "secret", Kind: DownstreamTypeOpenMHz, RemoteSystem: "abc", Url: server.URL}
+
+	if err := downstream.Send(&Controller{}, call); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+
+	if reqs[0].path != "/abc/upload" {
+		t.Errorf("path = %q, want /abc/upload", reqs[0].path)
+	}
+
+	for k, v := range map[string]string{"api_key": "secret", "call": "audio", "emergency": "1", "start_time": "1700000000", "talkgroup_num": "250"} {
+		if reqs[0].form[k] != v {
+			t.Errorf("%s = %q, want %q", k, reqs[0].form[k], v)
+		}
+	}
+}
+
+func TestDownstreamSendConfiguredRemoteIds(t *testing.T) {
+	controller := newTestController(t)
+
+	controller.Systems.FromMap([]any{map[string]any{
+		"id":         float64(1),
+		"label":      "System",
+		"remoteId":   "sys",
+		"talkgroups": []any{map[string]any{"id": float64(150), "label": "TG", "remoteId": float64(9150)}},
+	}})
+
+	if err := controller.Systems.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Systems.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	downstream := &Downstream{Apikey: "secret", Kind: DownstreamTypeOpenMHz, Url: server.URL}
+
+	if err := downstream.Send(controller, newTestDownstreamCall()); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+
+	if reqs[0].path != "/sys/upload" || reqs[0].form["talkgroup_num"] != "9150" {
+		t.Errorf("sent to %s as talkgroup %s", reqs[0].path, reqs[0].form["talkgroup_num"])
+	}
+}
+
+func TestDownstreamSendTrunkRecorder(t *testing.T) {
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	call := newTestDownstreamCall()
+	call.Talkgroup = 250
+
+	downstream := &Downstream{Apikey: "secret", Kind: DownstreamTypeTrunkRecorder, Remaps: newTestRemaps(), Url: server.URL}
+
+	if err := downstream.Send(&Controller{}, call); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+
+	if reqs[0].path != "/api/trunk-recorder-call-upload" {
+		t.Errorf("path = %q", reqs[0].path)
+	}
+
+	if reqs[0].form["key"] != "secret" || reqs[0].form["system"] != "7" || reqs[0].form["audio"] != "audio" {
+		t.Errorf("unexpected form %v", reqs[0].form)
+	}
+
+	meta := map[string]any{}
+	if err := json.Unmarshal([]byte(reqs[0].form["meta"]), &meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if meta["talkgroup"] != float64(250) || meta["emergency"] != false {
+		t.Errorf("unexpected meta %v", meta)
+	}
+}
+
+func TestDownstreamSendWebhook(t *testing.T) {
+	var (
+		body        []byte
+		contentType string
+	)
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	})
+
+	controller := &Controller{Systems: NewSystems()}
+
+	system := NewSystem()
+	system.Id = 1
+	system.Units.Add(4242, "Engine 1")
+	controller.Systems.List = append(controller.Systems.List, system)
+
+	// as read back from the retry queue, and remapped to another system
+	call := newTestDownstreamCall()
+	call.Sources = []any{map[string]any{"pos": float64(0), "src": float64(4242)}}
+	call.talkgroupLabel = `Fire "Main"`
+
+	downstream := &Downstream{
+		Kind:     DownstreamTypeWebhook,
+		Remaps:   newTestRemaps(),
+		Template: `{"content":{{json (printf "%s %v %s" .talkgroupLabel .system (join .units ","))}}}`,
+		Url:      server.URL,
+	}
+
+	if err := downstream.Send(controller, call); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]string{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" || payload["content"] != `Fire "Main" 42 Engine 1` {
+		t.Errorf("unexpected webhook %s %s", contentType, body)
+	}
+
+	downstream = &Downstream{AttachAudio: true, Kind: DownstreamTypeWebhook, Url: server.URL}
+
+	if err := downstream.Send(controller, call); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+
+	data := map[string]any{}
+	if err := json.Unmarshal([]byte(reqs[1].form["payload_json"]), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if reqs[1].form["file"] != "audio" || data["talkgroup"] != float64(150) || fmt.Sprint(data["units"]) != "[Engine 1]" {
+		t.Errorf("unexpected attachment %+v", reqs[1].form)
+	}
+}
+
+func TestDownstreamSendWebhookInvalidJson(t *testing.T) {
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	call := newTestDownstreamCall()
+	call.talkgroupLabel = `Fire "Main"`
+
+	downstream := &Downstream{Kind: DownstreamTypeWebhook, Template: `{"content":"{{.talkgroupLabel}}"}`, Url: server.URL}
+
+	if err := downstream.Send(&Controller{Systems: NewSystems()}, call); err == nil || !strings.Contains(err.Error(), "not valid json") {
+		t.Fatalf("expected a template error, got %v", err)
+	}
+
+	if n := len(requests()); n != 0 {
+		t.Errorf("got %d requests, want none", n)
+	}
+}
+
+func TestDownstreamAutoDisableKeepsInflightCalls(t *testing.T) {
+	controller := newTestController(t)
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	controller.Downstreams.FromMap([]any{map[string]any{
+		"_id":         float64(1),
+		"autoDisable": float64(2),
+		"concurrency": float64(1),
+		"systems":     "*",
+		"url":         server.URL,
+	}})
+
+	if err := controller.Downstreams.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Downstreams.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		call := newTestQueuedCall(t, controller, i)
+		call.Talkgroup = uint(10 + i)
+		controller.Downstreams.Send(controller, call)
+	}
+
+	waitTestQueue(t, controller, 3)
+
+	if n := len(requests()); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+
+	downstream, _ := controller.Downstreams.GetDownstream(1)
+	if !controller.Downstreams.IsDisabled(downstream) {
+		t.Fatal("downstream not disabled")
+	}
+
+	// only the flag is persisted
+	if err := controller.Downstreams.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if downstream, _ = controller.Downstreams.GetDownstream(1); !downstream.Disabled || downstream.AutoDisable != uint(2) {
+		t.Errorf("unexpected downstream after reload %+v", downstream)
+	}
+}
+
+func TestDownstreamsKeepSettingsOnAdminSave(t *testing.T) {
+	controller := newTestController(t)
+
+	controller.Downstreams.FromMap([]any{map[string]any{
+		"_id":           float64(1),
+		"apiKey":        "key",
+		"attachAudio":   true,
+		"autoDisable":   float64(5),
+		"concurrency":   float64(2),
+		"overflow":      DownstreamOverflowRetryQueue,
+		"queueSize":     float64(50),
+		"remaps":        []any{map[string]any{"system": float64(1), "remoteSystem": float64(7)}},
+		"remoteSystem":  "abc",
+		"sign":          true,
+		"signingSecret": "shh",
+		"systems":       "*",
+		"template":      `{"id": {{json .Id}}}`,
+		"type":          DownstreamTypeWebhook,
+		"url":           "https://hooks.example.com",
+	}})
+
+	if err := controller.Downstreams.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Downstreams.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	// what the stock admin app sends back
+	controller.Downstreams.FromMap([]any{map[string]any{
+		"_id":      float64(1),
+		"apiKey":   "key",
+		"disabled": false,
+		"order":    float64(1),
+		"systems":  "*",
+		"url":      "https://hooks.example.com/new",
+	}})
+
+	if err := controller.Downstreams.Write(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Downstreams.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	downstream, ok := controller.Downstreams.GetDownstream(1)
+	if !ok {
+		t.Fatal("downstream lost after an admin save")
+	}
+
+	if downstream.Url != "https://hooks.example.com/new" {
+		t.Errorf("url not updated, got %s", downstream.Url)
+	}
+	if downstream.Kind != DownstreamTypeWebhook {
+		t.Errorf("type changed to %v", downstream.Kind)
+	}
+	if !downstream.AttachAudio || !downstream.Sign || downstream.SigningSecret != "shh" {
+		t.Errorf("flags changed, %+v", downstream)
+	}
+	if downstream.AutoDisable != uint(5) || downstream.GetConcurrency() != 2 || downstream.GetOverflow() != DownstreamOverflowRetryQueue || downstream.GetQueueSize() != 50 {
+		t.Errorf("limits changed, %+v", downstream)
+	}
+	if downstream.RemoteSystem != "abc" || len(downstream.Remaps) != 1 || downstream.Template == nil {
+		t.Errorf("remote settings changed, %+v", downstream)
+	}
+
+	// fields that are sent empty are cleared
+	controller.Downstreams.FromMap([]any{map[string]any{
+		"_id":          float64(1),
+		"remaps":       []any{},
+		"remoteSystem": "",
+		"template":     nil,
+	}})
+
+	if downstream, _ = controller.Downstreams.GetDownstream(1); downstream.RemoteSystem != nil || len(downstream.Remaps) != 0 || downstream.Template != nil {
+		t.Errorf("remote settings not cleared, %+v", downstream)
+	}
+}
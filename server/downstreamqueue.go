@@ -0,0 +1,479 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	downstreamQueueBackoffBase = 30 * time.Second
+	downstreamQueueBackoffMax  = time.Hour
+	downstreamQueueBatchSize   = 1000
+	downstreamQueueDeferSize   = 1024
+	downstreamQueueInterval    = 10 * time.Second
+)
+
+type DownstreamQueueEntry struct {
+	Id           uint      `json:"_id"`
+	Attempts     uint      `json:"attempts"`
+	CallId       uint      `json:"callId"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Dead         bool      `json:"dead"`
+	DownstreamId uint      `json:"downstreamId"`
+	Error        string    `json:"error"`
+	NextAttempt  time.Time `json:"nextAttempt"`
+	System       uint      `json:"system"`
+	Talkgroup    uint      `json:"talkgroup"`
+}
+
+type DownstreamQueue struct {
+	Controller    *Controller
+	Ticker        *time.Ticker
+	deferred      chan *downstreamDeferred
+	inflight      map[uint]bool
+	inflightMutex sync.Mutex
+	mutex         sync.Mutex
+	started       bool
+}
+
+type downstreamDeferred struct {
+	call       *Call
+	downstream *Downstream
+}
+
+func NewDownstreamQueue(controller *Controller) *DownstreamQueue {
+	queue := &DownstreamQueue{
+		Controller:    controller,
+		deferred:      make(chan *downstreamDeferred, downstreamQueueDeferSize),
+		inflight:      map[uint]bool{},
+		inflightMutex: sync.Mutex{},
+		mutex:         sync.Mutex{},
+	}
+
+	go func() {
+		for d := range queue.deferred {
+			if err := queue.Add(d.downstream, d.call, 0, nil); err != nil {
+				controller.Logs.LogEvent(LogLevelError, err.Error())
+			}
+		}
+	}()
+
+	return queue
+}
+
+func (queue *DownstreamQueue) Add(downstream *Downstream, call *Call, attempts uint, sendErr error) error {
+	var (
+		db           = queue.Controller.Database
+		downstreamId uint
+		message      string
+		now          = time.Now().UTC()
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstreamqueue.add: %v", err)
+	}
+
+	switch v := downstream.Id.(type) {
+	case uint:
+		downstreamId = v
+	default:
+		return formatError(errors.New("downstream has no id"))
+	}
+
+	switch v := call.Id.(type) {
+	case uint:
+	default:
+		return formatError(fmt.Errorf("invalid call id %v", v))
+	}
+
+	if sendErr != nil {
+		message = sendErr.Error()
+	}
+
+	q := "insert into `rdioScannerDownstreamQueue` (`attempts`, `callId`, `createdAt`, `dead`, `downstreamId`, `error`, `nextAttempt`, `system`, `talkgroup`) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "insert into rdioScannerDownstreamQueue (attempts, callId, createdAt, dead, downstreamId, error, nextAttempt, system, talkgroup) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	}
+	if _, err := db.Sql.Exec(q, attempts, call.Id, now, false, downstreamId, message, now.Add(queue.getBackoff(attempts)), call.System, call.Talkgroup); err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+// Defer adds a call to the retry queue in the background, it returns false when too many are waiting.
+func (queue *DownstreamQueue) Defer(downstream *Downstream, call *Call) bool {
+	select {
+	case queue.deferred <- &downstreamDeferred{call: call, downstream: downstream}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (queue *DownstreamQueue) HasPending(downstream *Downstream, call *Call) bool {
+	return queue.hasPending(downstream.Id, call.System, call.Talkgroup, 0)
+}
+
+// List returns up to limit entries with an id greater than after, in queue order.
+func (queue *DownstreamQueue) List(dead any, after uint, limit uint) ([]*DownstreamQueueEntry, error) {
+	var (
+		db    = queue.Controller.Database
+		err   error
+		rows  *sql.Rows
+		where = "true"
+	)
+
+	formatError := func(err error) error {
+		return fmt.Errorf("downstreamqueue.list: %v", err)
+	}
+
+	if limit == 0 || limit > downstreamQueueBatchSize {
+		limit = downstreamQueueBatchSize
+	}
+
+	switch v := dead.(type) {
+	case bool:
+		if db.Config.DbType == DbTypePostgresql {
+			where = fmt.Sprintf("dead = %v", v)
+		} else if v {
+			where = "`dead` = 1"
+		} else {
+			where = "`dead` = 0"
+		}
+	}
+
+	q := fmt.Sprintf("select `_id`, `attempts`, `callId`, `createdAt`, `dead`, `downstreamId`, `error`, `nextAttempt`, `system`, `talkgroup` from `rdioScannerDownstreamQueue` where %s and `_id` > %d order by `_id` asc limit %d", where, after, limit)
+	if db.Config.DbType == DbTypePostgresql {
+		q = fmt.Sprintf("select _id, attempts, callId, createdAt, dead, downstreamId, error, nextAttempt, system, talkgroup from rdioScannerDownstreamQueue where %s and _id > %d order by _id asc limit %d", where, after, limit)
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return nil, formatError(err)
+	}
+
+	entries := []*DownstreamQueueEntry{}
+
+	for rows.Next() {
+		var (
+			createdAt   any
+			message     sql.NullString
+			nextAttempt any
+		)
+
+		entry := &DownstreamQueueEntry{}
+
+		if err = rows.Scan(&entry.Id, &entry.Attempts, &entry.CallId, &createdAt, &entry.Dead, &entry.DownstreamId, &message, &nextAttempt, &entry.System, &entry.Talkgroup); err != nil {
+			break
+		}
+
+		if t, err := db.ParseDateTime(createdAt); err == nil {
+			entry.CreatedAt = t
+		}
+
+		if t, err := db.ParseDateTime(nextAttempt); err == nil {
+			entry.NextAttempt = t
+		}
+
+		if message.Valid {
+			entry.Error = message.String
+		}
+
+		entries = append(entries, entry)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return nil, formatError(err)
+	}
+
+	return entries, nil
+}
+
+func (queue *DownstreamQueue) Purge() error {
+	db := queue.Controller.Database
+
+	q := "delete from `rdioScannerDownstreamQueue` where `dead` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "delete from rdioScannerDownstreamQueue where dead = $1"
+	}
+	if _, err := db.Sql.Exec(q, true); err != nil {
+		return fmt.Errorf("downstreamqueue.purge: %v", err)
+	}
+
+	return nil
+}
+
+// RetryAll revives every entry as if it had just been queued, so that the age limit which killed
+// most of them after a long outage does not kill them again on the next run.
+func (queue *DownstreamQueue) RetryAll() error {
+	db := queue.Controller.Database
+
+	now := time.Now().UTC()
+
+	q := "update `rdioScannerDownstreamQueue` set `attempts` = 0, `createdAt` = ?, `dead` = ?, `nextAttempt` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "update rdioScannerDownstreamQueue set attempts = 0, createdAt = $1, dead = $2, nextAttempt = $3"
+	}
+	if _, err := db.Sql.Exec(q, now, false, now); err != nil {
+		return fmt.Errorf("downstreamqueue.retryall: %v", err)
+	}
+
+	go queue.run()
+
+	return nil
+}
+
+func (queue *DownstreamQueue) Start() error {
+	if queue.started {
+		return errors.New("downstream queue already started")
+	} else {
+		queue.started = true
+	}
+
+	queue.Ticker = time.NewTicker(downstreamQueueInterval)
+
+	go func() {
+		for range queue.Ticker.C {
+			queue.run()
+		}
+	}()
+
+	return nil
+}
+
+func (queue *DownstreamQueue) getBackoff(attempts uint) time.Duration {
+	if attempts == 0 {
+		return 0
+	}
+
+	d := time.Duration(float64(downstreamQueueBackoffBase) * math.Pow(2, float64(attempts-1)))
+	if d <= 0 || d > downstreamQueueBackoffMax {
+		d = downstreamQueueBackoffMax
+	}
+
+	return d
+}
+
+func (queue *DownstreamQueue) acquire(entry *DownstreamQueueEntry) bool {
+	queue.inflightMutex.Lock()
+	defer queue.inflightMutex.Unlock()
+
+	if queue.inflight[entry.Id] {
+		return false
+	}
+
+	queue.inflight[entry.Id] = true
+
+	return true
+}
+
+// hasPending tells if live entries are waiting for a talkgroup, only those older than before when set.
+func (queue *DownstreamQueue) hasPending(downstreamId any, system uint, talkgroup uint, before uint) bool {
+	var (
+		count uint
+		db    = queue.Controller.Database
+	)
+
+	q := "select count(*) from `rdioScannerDownstreamQueue` where `downstreamId` = ? and `system` = ? and `talkgroup` = ? and `dead` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select count(*) from rdioScannerDownstreamQueue where downstreamId = $1 and system = $2 and talkgroup = $3 and dead = $4"
+	}
+
+	args := []any{downstreamId, system, talkgroup, false}
+
+	if before > 0 {
+		if db.Config.DbType == DbTypePostgresql {
+			q += " and _id < $5"
+		} else {
+			q += " and `_id` < ?"
+		}
+		args = append(args, before)
+	}
+
+	if err := db.Sql.QueryRow(q, args...).Scan(&count); err != nil {
+		return false
+	}
+
+	return count > 0
+}
+
+func (queue *DownstreamQueue) release(entry *DownstreamQueueEntry) {
+	queue.inflightMutex.Lock()
+	delete(queue.inflight, entry.Id)
+	queue.inflightMutex.Unlock()
+}
+
+func (queue *DownstreamQueue) remove(entry *DownstreamQueueEntry) error {
+	db := queue.Controller.Database
+
+	q := "delete from `rdioScannerDownstreamQueue` where `_id` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "delete from rdioScannerDownstreamQueue where _id = $1"
+	}
+	_, err := db.Sql.Exec(q, entry.Id)
+
+	return err
+}
+
+// run hands the due entries over to the downstream workers, through the same shard as the new calls
+// of their talkgroup so that a talkgroup is always sent in order.
+func (queue *DownstreamQueue) run() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var (
+		after      uint
+		blocked    = map[string]bool{}
+		controller = queue.Controller
+		now        = time.Now().UTC()
+		options    = controller.Options
+	)
+
+	logError := func(err error) {
+		controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("downstreamqueue.run: %s", err.Error()))
+	}
+
+	for {
+		entries, err := queue.List(false, after, downstreamQueueBatchSize)
+		if err != nil {
+			logError(err)
+			return
+		}
+
+		for _, entry := range entries {
+			key := fmt.Sprintf("%d:%d:%d", entry.DownstreamId, entry.System, entry.Talkgroup)
+
+			if blocked[key] {
+				continue
+			}
+
+			if (options.DownstreamRetryMaxAttempts > 0 && entry.Attempts >= options.DownstreamRetryMaxAttempts) ||
+				(options.DownstreamRetryMaxAge > 0 && now.Sub(entry.CreatedAt) > time.Duration(options.DownstreamRetryMaxAge)*time.Hour) {
+				entry.Dead = true
+				if err = queue.update(entry); err != nil {
+					logError(err)
+				}
+				controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("downstream: system=%v talkgroup=%v call=%v to downstream %v given up after %v attempts", entry.System, entry.Talkgroup, entry.CallId, entry.DownstreamId, entry.Attempts))
+				continue
+			}
+
+			if now.Before(entry.NextAttempt) {
+				blocked[key] = true
+				continue
+			}
+
+			downstream, ok := controller.Downstreams.GetDownstream(entry.DownstreamId)
+			if !ok {
+				if err = queue.remove(entry); err != nil {
+					logError(err)
+				}
+				continue
+			}
+
+			if controller.Downstreams.IsDisabled(downstream) {
+				blocked[key] = true
+				continue
+			}
+
+			if !queue.acquire(entry) {
+				blocked[key] = true
+				continue
+			}
+
+			if !controller.Downstreams.Retry(controller, downstream, entry) {
+				queue.release(entry)
+				blocked[key] = true
+			}
+		}
+
+		if len(entries) < downstreamQueueBatchSize {
+			return
+		}
+
+		after = entries[len(entries)-1].Id
+	}
+}
+
+// retry runs on the worker shard of the entry talkgroup. an entry is only sent once the older entries of
+// its talkgroup are gone, a failed one pushes back its successors until its next attempt.
+func (queue *DownstreamQueue) retry(downstream *Downstream, entry *DownstreamQueueEntry) {
+	controller := queue.Controller
+
+	defer queue.release(entry)
+
+	logError := func(err error) {
+		controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("downstreamqueue.retry: %s", err.Error()))
+	}
+
+	if queue.hasPending(downstream.Id, entry.System, entry.Talkgroup, entry.Id) {
+		return
+	}
+
+	call, err := controller.Calls.GetCall(entry.CallId, controller.Database)
+	if err != nil || call.System == 0 {
+		if err = queue.remove(entry); err != nil {
+			logError(err)
+		}
+		return
+	}
+
+	controller.populateCall(call)
+
+	logEvent := func(logLevel string, message string) {
+		controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v %v", call.System, call.Talkgroup, call.AudioName, downstream.Url, message))
+	}
+
+	if err := controller.Downstreams.SendTo(controller, downstream, call); err == nil {
+		logEvent(LogLevelInfo, fmt.Sprintf("success after %v attempts", entry.Attempts+1))
+		if err = queue.remove(entry); err != nil {
+			logError(err)
+		}
+
+	} else if errors.Is(err, ErrDownstreamLoop) {
+		logEvent(LogLevelWarn, err.Error())
+		if err = queue.remove(entry); err != nil {
+			logError(err)
+		}
+
+	} else {
+		entry.Attempts++
+		entry.Error = err.Error()
+		entry.NextAttempt = time.Now().UTC().Add(queue.getBackoff(entry.Attempts))
+		if err = queue.update(entry); err != nil {
+			logError(err)
+		}
+		logEvent(LogLevelError, fmt.Sprintf("%s, retry %v", entry.Error, entry.Attempts))
+	}
+}
+
+func (queue *DownstreamQueue) update(entry *DownstreamQueueEntry) error {
+	db := queue.Controller.Database
+
+	q := "update `rdioScannerDownstreamQueue` set `attempts` = ?, `dead` = ?, `error` = ?, `nextAttempt` = ? where `_id` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "update rdioScannerDownstreamQueue set attempts = $1, dead = $2, error = $3, nextAttempt = $4 where _id = $5"
+	}
+	_, err := db.Sql.Exec(q, entry.Attempts, entry.Dead, entry.Error, entry.NextAttempt, entry.Id)
+
+	return err
+}
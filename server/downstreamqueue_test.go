@@ -0,0 +1,208 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestQueuedCall(t *testing.T, controller *Controller, n int) *Call {
+	t.Helper()
+
+	call := newTestDownstreamCall()
+	call.AudioName = fmt.Sprintf("call-%d.m4a", n)
+	call.DateTime = call.DateTime.Add(time.Duration(n) * time.Second)
+
+	id, err := controller.Calls.WriteCall(call, controller.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call.Id = id
+
+	return call
+}
+
+func waitTestQueue(t *testing.T, controller *Controller, want int) []*DownstreamQueueEntry {
+	t.Helper()
+
+	for i := 0; i < 200; i++ {
+		controller.DownstreamQueue.inflightMutex.Lock()
+		inflight := len(controller.DownstreamQueue.inflight)
+		controller.DownstreamQueue.inflightMutex.Unlock()
+
+		entries, err := controller.DownstreamQueue.List(false, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if inflight == 0 && len(entries) == want {
+			return entries
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("retry queue did not settle to %d entries", want)
+
+	return nil
+}
+
+func TestDownstreamQueueKeepsTalkgroupOrder(t *testing.T) {
+	var failing atomic.Bool
+
+	controller := newTestController(t)
+
+	failing.Store(true)
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	downstream := &Downstream{Id: uint(1), Concurrency: uint(2), Systems: "*", Url: server.URL}
+	controller.Downstreams.List = []*Downstream{downstream}
+
+	first := newTestQueuedCall(t, controller, 1)
+	if err := controller.DownstreamQueue.Add(downstream, first, 0, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new call of the same talkgroup queues up behind the pending one
+	controller.Downstreams.Send(controller, newTestQueuedCall(t, controller, 2))
+	waitTestQueue(t, controller, 2)
+
+	// failures push the whole talkgroup back
+	controller.DownstreamQueue.run()
+	entries := waitTestQueue(t, controller, 2)
+	if entries[0].Attempts != 1 || entries[1].Attempts != 0 {
+		t.Fatalf("unexpected attempts %d, %d", entries[0].Attempts, entries[1].Attempts)
+	}
+
+	failing.Store(false)
+	for _, entry := range entries {
+		entry.NextAttempt = time.Now().UTC()
+		if err := controller.DownstreamQueue.update(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controller.Downstreams.Send(controller, newTestQueuedCall(t, controller, 3))
+	waitTestQueue(t, controller, 3)
+
+	// one run drains the talkgroup in order
+	controller.DownstreamQueue.run()
+	waitTestQueue(t, controller, 0)
+
+	sent := []string{}
+	for _, req := range requests() {
+		if len(req.form["audioName"]) > 0 {
+			sent = append(sent, req.form["audioName"])
+		}
+	}
+
+	want := []string{"call-1.m4a", "call-1.m4a", "call-2.m4a", "call-3.m4a"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Fatalf("sent %v, want %v", sent, want)
+	}
+}
+
+func TestDownstreamQueueListPages(t *testing.T) {
+	controller := newTestController(t)
+	downstream := &Downstream{Id: uint(1)}
+
+	for i := 0; i < downstreamQueueBatchSize+5; i++ {
+		call := newTestDownstreamCall()
+		call.Id = uint(i + 1)
+		if err := controller.DownstreamQueue.Add(downstream, call, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var after, total uint
+
+	for {
+		entries, err := controller.DownstreamQueue.List(false, after, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		total += uint(len(entries))
+		after = entries[len(entries)-1].Id
+	}
+
+	if total != downstreamQueueBatchSize+5 {
+		t.Fatalf("listed %d entries, want %d", total, downstreamQueueBatchSize+5)
+	}
+}
+
+func TestDownstreamQueueRetryAllRevivesOldEntries(t *testing.T) {
+	controller := newTestController(t)
+	controller.Options.DownstreamRetryMaxAge = 24
+
+	server, requests := newTestDownstreamServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	downstream := &Downstream{Id: uint(1), Systems: "*", Url: server.URL}
+	controller.Downstreams.List = []*Downstream{downstream}
+
+	if err := controller.DownstreamQueue.Add(downstream, newTestQueuedCall(t, controller, 1), 0, errors.New("down")); err != nil {
+		t.Fatal(err)
+	}
+
+	// queued during an outage longer than the age limit
+	q := "update `rdioScannerDownstreamQueue` set `createdAt` = ?"
+	if _, err := controller.Database.Sql.Exec(q, time.Now().UTC().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	controller.DownstreamQueue.run()
+	waitTestQueue(t, controller, 0)
+
+	dead, err := controller.DownstreamQueue.List(true, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead entries, want 1", len(dead))
+	}
+
+	if err = controller.DownstreamQueue.RetryAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200 && len(requests()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitTestQueue(t, controller, 0)
+
+	if n := len(requests()); n != 1 {
+		t.Fatalf("got %d requests, want 1", n)
+	}
+
+	if dead, err = controller.DownstreamQueue.List(nil, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 0 {
+		t.Fatalf("entry left in the queue, %+v", dead[0])
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type DownstreamWorker struct {
+	Controller  *Controller
+	Id          any
+	concurrency uint
+	label       string
+	queueSize   uint
+	shards      []chan *downstreamJob
+}
+
+// a job is either a new call or a retry queue entry
+type downstreamJob struct {
+	call  *Call
+	entry *DownstreamQueueEntry
+}
+
+func NewDownstreamWorker(controller *Controller, downstream *Downstream) *DownstreamWorker {
+	worker := &DownstreamWorker{
+		Controller:  controller,
+		Id:          downstream.Id,
+		concurrency: downstream.GetConcurrency(),
+		label:       fmt.Sprintf("%v", downstream.Id),
+		queueSize:   downstream.GetQueueSize(),
+	}
+
+	size := worker.queueSize / worker.concurrency
+	if size == 0 {
+		size = 1
+	}
+
+	worker.shards = make([]chan *downstreamJob, worker.concurrency)
+
+	for i := range worker.shards {
+		worker.shards[i] = make(chan *downstreamJob, size)
+
+		go worker.run(worker.shards[i])
+	}
+
+	return worker
+}
+
+func (worker *DownstreamWorker) Push(downstream *Downstream, call *Call) {
+	var (
+		job   = &downstreamJob{call: call}
+		shard = worker.getShard(call.System, call.Talkgroup)
+	)
+
+	logEvent := func(logLevel string, message string) {
+		worker.Controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v %v", call.System, call.Talkgroup, call.AudioName, downstream.Url, message))
+	}
+
+	if worker.offer(shard, job) {
+		return
+	}
+
+	metricDownstreamDropped.WithLabelValues(worker.label).Inc()
+
+	switch downstream.GetOverflow() {
+	case DownstreamOverflowDropNewest:
+		logEvent(LogLevelWarn, "queue full, call dropped")
+
+	case DownstreamOverflowDropOldest:
+		select {
+		case oldest := <-shard:
+			metricDownstreamQueueDepth.WithLabelValues(worker.label).Dec()
+			// a dropped retry stays in the retry queue for the next run
+			if oldest.entry != nil {
+				worker.Controller.DownstreamQueue.release(oldest.entry)
+			} else {
+				worker.Controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v queue full, call dropped", oldest.call.System, oldest.call.Talkgroup, oldest.call.AudioName, downstream.Url))
+			}
+		default:
+		}
+
+		if !worker.offer(shard, job) {
+			logEvent(LogLevelWarn, "queue full, call dropped")
+		}
+
+	default:
+		// the retry queue is written from its own goroutine to keep database writes off the ingest path
+		if worker.Controller.DownstreamQueue.Defer(downstream, call) {
+			logEvent(LogLevelWarn, "queue full, call moved to retry queue")
+		} else {
+			logEvent(LogLevelWarn, "queue full, retry queue busy, call dropped")
+		}
+	}
+}
+
+// Retry hands a retry queue entry to the shard of its talkgroup, it returns false when the shard is full.
+func (worker *DownstreamWorker) Retry(entry *DownstreamQueueEntry) bool {
+	return worker.offer(worker.getShard(entry.System, entry.Talkgroup), &downstreamJob{entry: entry})
+}
+
+func (worker *DownstreamWorker) Stop() {
+	for _, shard := range worker.shards {
+		close(shard)
+	}
+}
+
+// calls of the same talkgroup always go through the same shard to keep them in order
+func (worker *DownstreamWorker) getShard(system uint, talkgroup uint) chan *downstreamJob {
+	return worker.shards[(system*31+talkgroup)%uint(len(worker.shards))]
+}
+
+// offer counts the job before it is handed over so that the shard runner never decrements the gauge first
+func (worker *DownstreamWorker) offer(shard chan *downstreamJob, job *downstreamJob) bool {
+	metricDownstreamQueueDepth.WithLabelValues(worker.label).Inc()
+
+	select {
+	case shard <- job:
+		return true
+	default:
+		metricDownstreamQueueDepth.WithLabelValues(worker.label).Dec()
+		return false
+	}
+}
+
+func (worker *DownstreamWorker) run(shard chan *downstreamJob) {
+	for job := range shard {
+		metricDownstreamQueueDepth.WithLabelValues(worker.label).Dec()
+
+		if job.entry != nil {
+			worker.retry(job.entry)
+		} else {
+			worker.send(job.call)
+		}
+	}
+}
+
+func (worker *DownstreamWorker) retry(entry *DownstreamQueueEntry) {
+	var (
+		controller = worker.Controller
+		downstream *Downstream
+		ok         bool
+	)
+
+	switch v := worker.Id.(type) {
+	case uint:
+		downstream, ok = controller.Downstreams.GetDownstream(v)
+	}
+
+	if !ok || controller.Downstreams.IsDisabled(downstream) {
+		controller.DownstreamQueue.release(entry)
+		return
+	}
+
+	controller.DownstreamQueue.retry(downstream, entry)
+}
+
+func (worker *DownstreamWorker) send(call *Call) {
+	var (
+		controller = worker.Controller
+		downstream *Downstream
+		ok         bool
+	)
+
+	switch v := worker.Id.(type) {
+	case uint:
+		downstream, ok = controller.Downstreams.GetDownstream(v)
+	}
+
+	if !ok || !downstream.HasAccess(call) {
+		return
+	}
+
+	logEvent := func(logLevel string, message string) {
+		controller.Logs.LogEvent(logLevel, fmt.Sprintf("downstream: system=%v talkgroup=%v file=%v to %v %v", call.System, call.Talkgroup, call.AudioName, downstream.Url, message))
+	}
+
+	// calls still in the shard when the downstream got disabled are kept for when it is enabled again
+	if controller.Downstreams.IsDisabled(downstream) {
+		if err := controller.DownstreamQueue.Add(downstream, call, 0, nil); err == nil {
+			logEvent(LogLevelWarn, "downstream disabled, call moved to retry queue")
+		} else {
+			logEvent(LogLevelError, err.Error())
+		}
+		return
+	}
+
+	if controller.DownstreamQueue.HasPending(downstream, call) {
+		if err := controller.DownstreamQueue.Add(downstream, call, 0, nil); err == nil {
+			logEvent(LogLevelInfo, "queued behind pending calls")
+		} else {
+			logEvent(LogLevelError, err.Error())
+		}
+		return
+	}
+
+	if err := controller.Downstreams.SendTo(controller, downstream, call); err == nil {
+		logEvent(LogLevelInfo, "success")
+
+	} else if errors.Is(err, ErrDownstreamLoop) {
+		logEvent(LogLevelWarn, err.Error())
+
+	} else {
+		logEvent(LogLevelError, err.Error())
+
+		if err := controller.DownstreamQueue.Add(downstream, call, 1, err); err == nil {
+			logEvent(LogLevelInfo, "queued for retry")
+		} else {
+			logEvent(LogLevelError, err.Error())
+		}
+	}
+}
@@ -17,6 +17,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os/exec"
@@ -130,3 +131,32 @@ func (ffmpeg *FFMpeg) Convert(call *Call, systems *Systems, tags *Tags, mode uin
 
 	return nil
 }
+
+// DecodePcm decodes the audio to mono samples normalized to [-1, 1]
+func (ffmpeg *FFMpeg) DecodePcm(audio []byte, sampleRate uint) ([]float64, error) {
+	if !ffmpeg.available {
+		return nil, errors.New("ffmpeg is not available")
+	}
+
+	cmd := exec.Command("ffmpeg", "-i", "-", "-f", "s16le", "-ac", "1", "-ar", fmt.Sprintf("%d", sampleRate), "-")
+	cmd.Stdin = bytes.NewReader(audio)
+
+	stdout := bytes.NewBuffer([]byte(nil))
+	cmd.Stdout = stdout
+
+	stderr := bytes.NewBuffer([]byte(nil))
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v, %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	b := stdout.Bytes()
+	samples := make([]float64, len(b)/2)
+
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(b[i*2:]))) / 32768
+	}
+
+	return samples, nil
+}
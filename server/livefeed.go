@@ -16,6 +16,7 @@
 package main
 
 import (
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -67,6 +68,29 @@ func (livefeed *Livefeed) FromMap(f any) *Livefeed {
 	return livefeed
 }
 
+func (livefeed *Livefeed) GetTalkgroups() map[uint][]uint {
+	livefeed.mutex.Lock()
+	defer livefeed.mutex.Unlock()
+
+	talkgroups := map[uint][]uint{}
+
+	for sysId, sys := range livefeed.Matrix {
+		for tgId, enabled := range sys {
+			if enabled {
+				talkgroups[sysId] = append(talkgroups[sysId], tgId)
+			}
+		}
+	}
+
+	for sysId := range talkgroups {
+		sort.Slice(talkgroups[sysId], func(i int, j int) bool {
+			return talkgroups[sysId][i] < talkgroups[sysId][j]
+		})
+	}
+
+	return talkgroups
+}
+
 func (livefeed *Livefeed) IsAllOff() bool {
 	livefeed.mutex.Lock()
 	defer livefeed.mutex.Unlock()
@@ -28,7 +28,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -91,8 +90,22 @@ func main() {
 		addr = defaultAddr
 	}
 
+	http.HandleFunc("/api/admin/apikey-add", controller.Admin.ApikeyAddHandler)
+
+	http.HandleFunc("/api/admin/apikey-secret", controller.Admin.ApikeySecretHandler)
+
+	http.HandleFunc("/api/admin/broadcast", controller.Admin.BroadcastHandler)
+
 	http.HandleFunc("/api/admin/config", controller.Admin.ConfigHandler)
 
+	http.HandleFunc("/api/admin/downstream-queue", controller.Admin.DownstreamQueueHandler)
+
+	http.HandleFunc("/api/admin/downstreams-status", controller.Admin.DownstreamsStatusHandler)
+
+	http.HandleFunc("/api/admin/ingest-sources", controller.Admin.IngestSourcesHandler)
+
+	http.HandleFunc("/api/admin/listeners", controller.Admin.ListenersHandler)
+
 	http.HandleFunc("/api/admin/login", controller.Admin.LoginHandler)
 
 	http.HandleFunc("/api/admin/logout", controller.Admin.LogoutHandler)
@@ -105,20 +118,25 @@ func main() {
 
 	http.HandleFunc("/api/admin/user-remove", controller.Admin.UserRemoveHandler)
 
+	http.HandleFunc("/api/broadcastify-call-upload", controller.Api.BroadcastifyCallUploadHandler)
+
+	http.HandleFunc("/api/broadcastify-call-upload/", controller.Api.BroadcastifyCallUploadHandler)
+
 	http.HandleFunc("/api/call-upload", controller.Api.CallUploadHandler)
 
+	http.HandleFunc("/api/calls", controller.Api.CallsHandler)
+
+	http.HandleFunc("/api/calls/", controller.Api.CallsHandler)
+
 	http.HandleFunc("/api/trunk-recorder-call-upload", controller.Api.TrunkRecorderCallUploadHandler)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		url := r.URL.Path[1:]
 
 		if strings.EqualFold(r.Header.Get("upgrade"), "websocket") {
-			upgrader := websocket.Upgrader{
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-				ReadBufferSize:  1024,
-				WriteBufferSize: 1024,
+			upgrader := config.NewWebsocketUpgrader()
+			upgrader.CheckOrigin = func(r *http.Request) bool {
+				return true
 			}
 
 			conn, err := upgrader.Upgrade(w, r, nil)
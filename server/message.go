@@ -22,6 +22,7 @@ import (
 const (
 	MessageCommandCall           = "CAL"
 	MessageCommandConfig         = "CFG"
+	MessageCommandConfigDiff     = "CFD"
 	MessageCommandExpired        = "XPR"
 	MessageCommandIOS            = "IOS"
 	MessageCommandListCall       = "LCL"
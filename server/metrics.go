@@ -4,9 +4,49 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var (
+	metricDownstreamDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdio_scanner_downstream_dropped_total",
+		Help: "Calls that did not fit in the downstream queue",
+	}, []string{"downstream"})
+
+	metricDownstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rdio_scanner_downstream_duration_seconds",
+		Help:    "Time taken to send a call to a downstream",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"downstream"})
+
+	metricDownstreamQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rdio_scanner_downstream_queue_depth",
+		Help: "Calls waiting to be sent to a downstream",
+	}, []string{"downstream"})
+
+	metricDownstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdio_scanner_downstream_requests_total",
+		Help: "Calls sent to a downstream by status",
+	}, []string{"downstream", "status"})
+
+	metricListenerDisconnected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rdio_scanner_listener_slow_disconnected_total",
+		Help: "Listeners disconnected for not keeping up with their send queue",
+	})
+
+	metricListenerDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rdio_scanner_listener_dropped_total",
+		Help: "Calls dropped from listener send queues by reason",
+	}, []string{"reason"})
+
+	metricListenerQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rdio_scanner_listener_queue_depth",
+		Help: "Calls waiting to be sent to listeners",
+	})
+)
+
 func CreateMetricsServer(config *Config) {
 	port := config.MetricsPort
 	if port != 0 {
@@ -21,32 +21,52 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Options struct {
 	AfsSystems                  string `json:"afsSystems"`
+	AlertWebhookAllowList       string `json:"alertWebhookAllowList"`
 	AudioConversion             uint   `json:"audioConversion"`
 	AudioBitrate                uint   `json:"audioBitrate"`
 	AutoPopulate                bool   `json:"autoPopulate"`
 	Branding                    string `json:"branding"`
 	DimmerDelay                 uint   `json:"dimmerDelay"`
 	DisableDuplicateDetection   bool   `json:"disableDuplicateDetection"`
+	DownstreamRetryMaxAge       uint   `json:"downstreamRetryMaxAge"`
+	DownstreamRetryMaxAttempts  uint   `json:"downstreamRetryMaxAttempts"`
 	DuplicateDetectionTimeFrame uint   `json:"duplicateDetectionTimeFrame"`
 	KeypadBeeps                 string `json:"keypadBeeps"`
+	ListenerQueueMaxAge         uint   `json:"listenerQueueMaxAge"`
+	ListenerQueueMaxLength      uint   `json:"listenerQueueMaxLength"`
+	ListenerQueuePolicy         string `json:"listenerQueuePolicy"`
 	MaxClients                  uint   `json:"maxClients"`
+	Motd                        string `json:"motd"`
 	PlaybackGoesLive            bool   `json:"playbackGoesLive"`
 	PruneCallDays               uint   `json:"pruneCallDays"`
 	PruneLogDays                uint   `json:"pruneLogDays"`
 	SearchPatchedTalkgroups     bool   `json:"searchPatchedTalkgroups"`
 	ShowListenersCount          bool   `json:"showListenersCount"`
+	SmtpFrom                    string `json:"smtpFrom"`
+	SmtpHost                    string `json:"smtpHost"`
+	SmtpPassword                string `json:"smtpPassword"`
+	SmtpPort                    uint   `json:"smtpPort"`
+	SmtpUsername                string `json:"smtpUsername"`
 	SortTalkgroups              bool   `json:"sortTalkgroups"`
 	TagsToggle                  bool   `json:"tagsToggle"`
 	Time12hFormat               bool   `json:"time12hFormat"`
+	UploadIpRateLimit           uint   `json:"uploadIpRateLimit"`
+	UploadRateLimit             uint   `json:"uploadRateLimit"`
+	UploadRateLimitBurst        uint   `json:"uploadRateLimitBurst"`
+	WebPushAllowPrivate         bool   `json:"webPushAllowPrivate"`
 	adminPassword               string
+	alertExecDir                string
 	adminPasswordNeedChange     bool
 	mutex                       sync.Mutex
 	secret                      string
+	serverId                    string
+	vapidPrivateKey             string
 }
 
 const (
@@ -71,6 +91,13 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.AfsSystems = v
 	}
 
+	switch v := m["alertWebhookAllowList"].(type) {
+	case string:
+		options.AlertWebhookAllowList = v
+	default:
+		options.AlertWebhookAllowList = defaults.options.alertWebhookAllowList
+	}
+
 	switch v := m["audioConversion"].(type) {
 	case float64:
 		options.AudioConversion = uint(v)
@@ -128,6 +155,20 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.DisableDuplicateDetection = defaults.options.disableDuplicateDetection
 	}
 
+	switch v := m["downstreamRetryMaxAge"].(type) {
+	case float64:
+		options.DownstreamRetryMaxAge = uint(v)
+	default:
+		options.DownstreamRetryMaxAge = defaults.options.downstreamRetryMaxAge
+	}
+
+	switch v := m["downstreamRetryMaxAttempts"].(type) {
+	case float64:
+		options.DownstreamRetryMaxAttempts = uint(v)
+	default:
+		options.DownstreamRetryMaxAttempts = defaults.options.downstreamRetryMaxAttempts
+	}
+
 	switch v := m["duplicateDetectionTimeFrame"].(type) {
 	case float64:
 		options.DuplicateDetectionTimeFrame = uint(v)
@@ -142,6 +183,32 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.KeypadBeeps = defaults.options.keypadBeeps
 	}
 
+	switch v := m["listenerQueueMaxAge"].(type) {
+	case float64:
+		options.ListenerQueueMaxAge = uint(v)
+	default:
+		options.ListenerQueueMaxAge = defaults.options.listenerQueueMaxAge
+	}
+
+	switch v := m["listenerQueueMaxLength"].(type) {
+	case float64:
+		options.ListenerQueueMaxLength = uint(v)
+	default:
+		options.ListenerQueueMaxLength = defaults.options.listenerQueueMaxLength
+	}
+
+	switch v := m["listenerQueuePolicy"].(type) {
+	case string:
+		switch v {
+		case ListenerQueuePolicyCoalesce, ListenerQueuePolicyDisconnect, ListenerQueuePolicyDropOldest:
+			options.ListenerQueuePolicy = v
+		default:
+			options.ListenerQueuePolicy = defaults.options.listenerQueuePolicy
+		}
+	default:
+		options.ListenerQueuePolicy = defaults.options.listenerQueuePolicy
+	}
+
 	switch v := m["maxClients"].(type) {
 	case float64:
 		options.MaxClients = uint(v)
@@ -149,6 +216,13 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.MaxClients = defaults.options.maxClients
 	}
 
+	switch v := m["motd"].(type) {
+	case string:
+		options.Motd = v
+	default:
+		options.Motd = defaults.options.motd
+	}
+
 	switch v := m["playbackGoesLive"].(type) {
 	case bool:
 		options.PlaybackGoesLive = v
@@ -182,6 +256,41 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.ShowListenersCount = defaults.options.showListenersCount
 	}
 
+	switch v := m["smtpFrom"].(type) {
+	case string:
+		options.SmtpFrom = v
+	default:
+		options.SmtpFrom = defaults.options.smtpFrom
+	}
+
+	switch v := m["smtpHost"].(type) {
+	case string:
+		options.SmtpHost = v
+	default:
+		options.SmtpHost = defaults.options.smtpHost
+	}
+
+	switch v := m["smtpPassword"].(type) {
+	case string:
+		options.SmtpPassword = v
+	default:
+		options.SmtpPassword = defaults.options.smtpPassword
+	}
+
+	switch v := m["smtpPort"].(type) {
+	case float64:
+		options.SmtpPort = uint(v)
+	default:
+		options.SmtpPort = defaults.options.smtpPort
+	}
+
+	switch v := m["smtpUsername"].(type) {
+	case string:
+		options.SmtpUsername = v
+	default:
+		options.SmtpUsername = defaults.options.smtpUsername
+	}
+
 	switch v := m["sortTalkgroups"].(type) {
 	case bool:
 		options.SortTalkgroups = v
@@ -203,6 +312,34 @@ func (options *Options) FromMap(m map[string]any) *Options {
 		options.Time12hFormat = defaults.options.time12hFormat
 	}
 
+	switch v := m["uploadIpRateLimit"].(type) {
+	case float64:
+		options.UploadIpRateLimit = uint(v)
+	default:
+		options.UploadIpRateLimit = defaults.options.uploadIpRateLimit
+	}
+
+	switch v := m["uploadRateLimit"].(type) {
+	case float64:
+		options.UploadRateLimit = uint(v)
+	default:
+		options.UploadRateLimit = defaults.options.uploadRateLimit
+	}
+
+	switch v := m["uploadRateLimitBurst"].(type) {
+	case float64:
+		options.UploadRateLimitBurst = uint(v)
+	default:
+		options.UploadRateLimitBurst = defaults.options.uploadRateLimitBurst
+	}
+
+	switch v := m["webPushAllowPrivate"].(type) {
+	case bool:
+		options.WebPushAllowPrivate = v
+	default:
+		options.WebPushAllowPrivate = defaults.options.webPushAllowPrivate
+	}
+
 	return options
 }
 
@@ -220,21 +357,37 @@ func (options *Options) Read(db *Database) error {
 
 	options.adminPassword = string(defaultPassword)
 	options.adminPasswordNeedChange = defaults.adminPasswordNeedChange
+	options.AlertWebhookAllowList = defaults.options.alertWebhookAllowList
 	options.AudioConversion = defaults.options.audioConversion
 	options.AudioBitrate = defaults.options.audioBitrate
 	options.AutoPopulate = defaults.options.autoPopulate
 	options.DimmerDelay = defaults.options.dimmerDelay
 	options.DisableDuplicateDetection = defaults.options.disableDuplicateDetection
+	options.DownstreamRetryMaxAge = defaults.options.downstreamRetryMaxAge
+	options.DownstreamRetryMaxAttempts = defaults.options.downstreamRetryMaxAttempts
 	options.DuplicateDetectionTimeFrame = defaults.options.duplicateDetectionTimeFrame
 	options.KeypadBeeps = defaults.options.keypadBeeps
+	options.ListenerQueueMaxAge = defaults.options.listenerQueueMaxAge
+	options.ListenerQueueMaxLength = defaults.options.listenerQueueMaxLength
+	options.ListenerQueuePolicy = defaults.options.listenerQueuePolicy
 	options.MaxClients = defaults.options.maxClients
+	options.Motd = defaults.options.motd
 	options.PlaybackGoesLive = defaults.options.playbackGoesLive
 	options.PruneCallDays = defaults.options.pruneCallDays
 	options.PruneLogDays = defaults.options.pruneLogDays
 	options.SearchPatchedTalkgroups = defaults.options.searchPatchedTalkgroups
 	options.ShowListenersCount = defaults.options.showListenersCount
+	options.SmtpFrom = defaults.options.smtpFrom
+	options.SmtpHost = defaults.options.smtpHost
+	options.SmtpPassword = defaults.options.smtpPassword
+	options.SmtpPort = defaults.options.smtpPort
+	options.SmtpUsername = defaults.options.smtpUsername
 	options.SortTalkgroups = defaults.options.sortTalkgroups
 	options.TagsToggle = defaults.options.tagsToggle
+	options.UploadIpRateLimit = defaults.options.uploadIpRateLimit
+	options.UploadRateLimit = defaults.options.uploadRateLimit
+	options.UploadRateLimitBurst = defaults.options.uploadRateLimitBurst
+	options.WebPushAllowPrivate = defaults.options.webPushAllowPrivate
 
 	q := "select `val` from `rdioScannerConfigs` where `key` = 'adminPassword'"
 	if db.Config.DbType == DbTypePostgresql {
@@ -273,6 +426,11 @@ func (options *Options) Read(db *Database) error {
 				options.AfsSystems = v
 			}
 
+			switch v := m["alertWebhookAllowList"].(type) {
+			case string:
+				options.AlertWebhookAllowList = v
+			}
+
 			switch v := m["audioConversion"].(type) {
 			case float64:
 				options.AudioConversion = uint(v)
@@ -305,6 +463,16 @@ func (options *Options) Read(db *Database) error {
 				options.DisableDuplicateDetection = v
 			}
 
+			switch v := m["downstreamRetryMaxAge"].(type) {
+			case float64:
+				options.DownstreamRetryMaxAge = uint(v)
+			}
+
+			switch v := m["downstreamRetryMaxAttempts"].(type) {
+			case float64:
+				options.DownstreamRetryMaxAttempts = uint(v)
+			}
+
 			switch v := m["duplicateDetectionTimeFrame"].(type) {
 			case float64:
 				options.DuplicateDetectionTimeFrame = uint(v)
@@ -315,11 +483,31 @@ func (options *Options) Read(db *Database) error {
 				options.KeypadBeeps = v
 			}
 
+			switch v := m["listenerQueueMaxAge"].(type) {
+			case float64:
+				options.ListenerQueueMaxAge = uint(v)
+			}
+
+			switch v := m["listenerQueueMaxLength"].(type) {
+			case float64:
+				options.ListenerQueueMaxLength = uint(v)
+			}
+
+			switch v := m["listenerQueuePolicy"].(type) {
+			case string:
+				options.ListenerQueuePolicy = v
+			}
+
 			switch v := m["maxClients"].(type) {
 			case float64:
 				options.MaxClients = uint(v)
 			}
 
+			switch v := m["motd"].(type) {
+			case string:
+				options.Motd = v
+			}
+
 			switch v := m["playbackGoesLive"].(type) {
 			case bool:
 				options.PlaybackGoesLive = v
@@ -345,6 +533,31 @@ func (options *Options) Read(db *Database) error {
 				options.ShowListenersCount = v
 			}
 
+			switch v := m["smtpFrom"].(type) {
+			case string:
+				options.SmtpFrom = v
+			}
+
+			switch v := m["smtpHost"].(type) {
+			case string:
+				options.SmtpHost = v
+			}
+
+			switch v := m["smtpPassword"].(type) {
+			case string:
+				options.SmtpPassword = v
+			}
+
+			switch v := m["smtpPort"].(type) {
+			case float64:
+				options.SmtpPort = uint(v)
+			}
+
+			switch v := m["smtpUsername"].(type) {
+			case string:
+				options.SmtpUsername = v
+			}
+
 			switch v := m["sortTalkgroups"].(type) {
 			case bool:
 				options.SortTalkgroups = v
@@ -359,6 +572,26 @@ func (options *Options) Read(db *Database) error {
 			case bool:
 				options.Time12hFormat = v
 			}
+
+			switch v := m["uploadIpRateLimit"].(type) {
+			case float64:
+				options.UploadIpRateLimit = uint(v)
+			}
+
+			switch v := m["uploadRateLimit"].(type) {
+			case float64:
+				options.UploadRateLimit = uint(v)
+			}
+
+			switch v := m["uploadRateLimitBurst"].(type) {
+			case float64:
+				options.UploadRateLimitBurst = uint(v)
+			}
+
+			switch v := m["webPushAllowPrivate"].(type) {
+			case bool:
+				options.WebPushAllowPrivate = v
+			}
 		}
 	}
 
@@ -373,9 +606,77 @@ func (options *Options) Read(db *Database) error {
 		}
 	}
 
+	q = "select `val` from `rdioScannerConfigs` where `key` = 'serverId'"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select val from rdioScannerConfigs where key = 'serverId'"
+	}
+	err = db.Sql.QueryRow(q).Scan(&s)
+	if err == nil {
+		if err = json.Unmarshal([]byte(s), &s); err == nil {
+			options.serverId = s
+		}
+	}
+
+	if len(options.serverId) == 0 {
+		options.serverId = uuid.New().String()
+
+		if b, err := json.Marshal(options.serverId); err == nil {
+			q = "insert into `rdioScannerConfigs` (`key`, `val`) values (?, ?)"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "insert into rdioScannerConfigs (key, val) values ($1, $2)"
+			}
+			if _, err = db.Sql.Exec(q, "serverId", string(b)); err != nil {
+				return fmt.Errorf("options.read: %v", err)
+			}
+		}
+	}
+
+	q = "select `val` from `rdioScannerConfigs` where `key` = 'vapidPrivateKey'"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select val from rdioScannerConfigs where key = 'vapidPrivateKey'"
+	}
+	err = db.Sql.QueryRow(q).Scan(&s)
+	if err == nil {
+		if err = json.Unmarshal([]byte(s), &s); err == nil {
+			options.vapidPrivateKey = s
+		}
+	}
+
+	if len(options.vapidPrivateKey) == 0 {
+		if options.vapidPrivateKey, err = NewVapidPrivateKey(); err != nil {
+			return fmt.Errorf("options.read: %v", err)
+		}
+
+		if b, err := json.Marshal(options.vapidPrivateKey); err == nil {
+			q = "insert into `rdioScannerConfigs` (`key`, `val`) values (?, ?)"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "insert into rdioScannerConfigs (key, val) values ($1, $2)"
+			}
+			if _, err = db.Sql.Exec(q, "vapidPrivateKey", string(b)); err != nil {
+				return fmt.Errorf("options.read: %v", err)
+			}
+		}
+	}
+
 	return nil
 }
 
+// GetMotd returns the message of the day, it can change while the config is sent to listeners.
+func (options *Options) GetMotd() string {
+	options.mutex.Lock()
+	defer options.mutex.Unlock()
+
+	return options.Motd
+}
+
+// SetMotd replaces the message of the day without going through a full admin config save.
+func (options *Options) SetMotd(motd string) {
+	options.mutex.Lock()
+	defer options.mutex.Unlock()
+
+	options.Motd = motd
+}
+
 func (options *Options) Write(db *Database) error {
 	var (
 		b   []byte
@@ -433,23 +734,39 @@ func (options *Options) Write(db *Database) error {
 
 	if b, err = json.Marshal(map[string]any{
 		"afsSystems":                  options.AfsSystems,
+		"alertWebhookAllowList":       options.AlertWebhookAllowList,
 		"audioConversion":             options.AudioConversion,
 		"audioBitrate":                options.AudioBitrate,
 		"autoPopulate":                options.AutoPopulate,
 		"branding":                    options.Branding,
 		"dimmerDelay":                 options.DimmerDelay,
 		"disableDuplicateDetection":   options.DisableDuplicateDetection,
+		"downstreamRetryMaxAge":       options.DownstreamRetryMaxAge,
+		"downstreamRetryMaxAttempts":  options.DownstreamRetryMaxAttempts,
 		"duplicateDetectionTimeFrame": options.DuplicateDetectionTimeFrame,
 		"keypadBeeps":                 options.KeypadBeeps,
+		"listenerQueueMaxAge":         options.ListenerQueueMaxAge,
+		"listenerQueueMaxLength":      options.ListenerQueueMaxLength,
+		"listenerQueuePolicy":         options.ListenerQueuePolicy,
 		"maxClients":                  options.MaxClients,
+		"motd":                        options.Motd,
 		"playbackGoesLive":            options.PlaybackGoesLive,
 		"pruneLogDays":                options.PruneLogDays,
 		"pruneCallDays":               options.PruneCallDays,
 		"searchPatchedTalkgroups":     options.SearchPatchedTalkgroups,
 		"showListenersCount":          options.ShowListenersCount,
+		"smtpFrom":                    options.SmtpFrom,
+		"smtpHost":                    options.SmtpHost,
+		"smtpPassword":                options.SmtpPassword,
+		"smtpPort":                    options.SmtpPort,
+		"smtpUsername":                options.SmtpUsername,
 		"sortTalkgroups":              options.SortTalkgroups,
 		"tagsToggle":                  options.TagsToggle,
 		"time12hFormat":               options.Time12hFormat,
+		"uploadIpRateLimit":           options.UploadIpRateLimit,
+		"uploadRateLimit":             options.UploadRateLimit,
+		"uploadRateLimitBurst":        options.UploadRateLimitBurst,
+		"webPushAllowPrivate":         options.WebPushAllowPrivate,
 	}); err != nil {
 		return formatError(err)
 	}
@@ -0,0 +1,47 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"testing"
+)
+
+func TestOptionsReadDefaultsMatchFromMap(t *testing.T) {
+	controller := newTestController(t)
+
+	// options saved before the upload limits existed
+	q := "insert into `rdioScannerConfigs` (`key`, `val`) values ('options', ?)"
+	if _, err := controller.Database.Sql.Exec(q, `{"maxClients":100}`); err != nil {
+		t.Fatal(err)
+	}
+
+	read := &Options{UploadRateLimitBurst: 99, WebPushAllowPrivate: true, AlertWebhookAllowList: "*"}
+	if err := read.Read(controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	fromMap := (&Options{}).FromMap(map[string]any{"maxClients": float64(100)})
+
+	if read.UploadRateLimitBurst != fromMap.UploadRateLimitBurst || read.UploadRateLimitBurst != defaults.options.uploadRateLimitBurst {
+		t.Errorf("burst is %d after read and %d from a map", read.UploadRateLimitBurst, fromMap.UploadRateLimitBurst)
+	}
+	if read.UploadRateLimit != fromMap.UploadRateLimit || read.UploadIpRateLimit != fromMap.UploadIpRateLimit {
+		t.Errorf("rate limits differ, %d/%d after read and %d/%d from a map", read.UploadRateLimit, read.UploadIpRateLimit, fromMap.UploadRateLimit, fromMap.UploadIpRateLimit)
+	}
+	if read.WebPushAllowPrivate != fromMap.WebPushAllowPrivate || read.AlertWebhookAllowList != fromMap.AlertWebhookAllowList {
+		t.Errorf("network options differ, %v/%q after read and %v/%q from a map", read.WebPushAllowPrivate, read.AlertWebhookAllowList, fromMap.WebPushAllowPrivate, fromMap.AlertWebhookAllowList)
+	}
+}
@@ -235,11 +235,15 @@ func ParseSdrTrunkMeta(call *Call, controller *Controller) error {
 }
 
 func ParseMultipartContent(call *Call, p *multipart.Part, b []byte) {
-	switch p.FormName() {
+	ParseFormContent(call, p.FormName(), p.FileName(), b)
+}
+
+func ParseFormContent(call *Call, name string, fileName string, b []byte) {
+	switch name {
 	case "audio":
 		if call.AudioUrl == "" {
 			call.Audio = b
-			call.AudioName = p.FileName()
+			call.AudioName = fileName
 		}
 	case "audioName":
 		call.AudioName = string(b)
@@ -256,6 +260,11 @@ func ParseMultipartContent(call *Call, p *multipart.Part, b []byte) {
 			call.DateTime = call.DateTime.UTC()
 		}
 
+	case "emergency":
+		if v, err := strconv.ParseBool(string(b)); err == nil {
+			call.emergency = v
+		}
+
 	case "frequencies":
 		var f any
 		if err := json.Unmarshal(b, &f); err == nil {
@@ -328,6 +337,12 @@ func ParseMultipartContent(call *Call, p *multipart.Part, b []byte) {
 			call.Patches = patches
 		}
 
+	case "provenance":
+		var hops []string
+		if err := json.Unmarshal(b, &hops); err == nil {
+			call.Provenance = hops
+		}
+
 	case "source":
 		if i, err := strconv.Atoi(string(b)); err == nil {
 			call.Source = int(i)
@@ -418,6 +433,13 @@ func ParseTrunkRecorderMeta(call *Call, b []byte) error {
 		return err
 	}
 
+	switch v := m["emergency"].(type) {
+	case bool:
+		call.emergency = v
+	case float64:
+		call.emergency = v != 0
+	}
+
 	switch v := m["freq"].(type) {
 	case float64:
 		if v > 0 {
@@ -502,6 +524,12 @@ func ParseTrunkRecorderMeta(call *Call, b []byte) error {
 						source["pos"] = uint(v)
 					}
 				}
+				switch e := v["emergency"].(type) {
+				case float64:
+					if e != 0 {
+						call.emergency = true
+					}
+				}
 				switch s := v["src"].(type) {
 				case float64:
 					if s > 0 {
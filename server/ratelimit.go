@@ -0,0 +1,103 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimiterIdleTimeout = 10 * time.Minute
+	rateLimiterWindow      = time.Minute
+)
+
+type RateLimiter struct {
+	buckets  map[string]*rateLimiterBucket
+	mutex    sync.Mutex
+	prunedAt time.Time
+}
+
+type rateLimiterBucket struct {
+	loggedAt  time.Time
+	throttled uint
+	tokens    float64
+	updatedAt time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:  map[string]*rateLimiterBucket{},
+		mutex:    sync.Mutex{},
+		prunedAt: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key, refilled at rate tokens per minute
+// up to burst. Throttling is reported for logging at most once per window.
+func (limiter *RateLimiter) Allow(key string, rate uint, burst uint) (ok bool, retryAfter time.Duration, log bool, throttled uint) {
+	if rate == 0 {
+		return true, 0, false, 0
+	}
+
+	if burst == 0 {
+		burst = 1
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(limiter.prunedAt) > rateLimiterWindow {
+		for k, bucket := range limiter.buckets {
+			if now.Sub(bucket.updatedAt) > rateLimiterIdleTimeout {
+				delete(limiter.buckets, k)
+			}
+		}
+		limiter.prunedAt = now
+	}
+
+	perSecond := float64(rate) / rateLimiterWindow.Seconds()
+
+	bucket, found := limiter.buckets[key]
+	if !found {
+		bucket = &rateLimiterBucket{tokens: float64(burst), updatedAt: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*perSecond)
+	bucket.updatedAt = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0, false, 0
+	}
+
+	bucket.throttled++
+
+	retryAfter = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+
+	if now.Sub(bucket.loggedAt) >= rateLimiterWindow {
+		log = true
+		throttled = bucket.throttled
+		bucket.loggedAt = now
+		bucket.throttled = 0
+	}
+
+	return false, retryAfter, log, throttled
+}
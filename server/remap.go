@@ -0,0 +1,242 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+type Remap struct {
+	RemoteSystem    any  `json:"remoteSystem"`
+	RemoteTalkgroup any  `json:"remoteTalkgroup"`
+	System          uint `json:"system"`
+	SystemLabel     any  `json:"systemLabel"`
+	TalkgroupFrom   any  `json:"talkgroupFrom"`
+	TalkgroupLabel  any  `json:"talkgroupLabel"`
+	TalkgroupName   any  `json:"talkgroupName"`
+	TalkgroupTo     any  `json:"talkgroupTo"`
+}
+
+func (remap *Remap) FromMap(m map[string]any) *Remap {
+	toUint := func(f any) any {
+		switch v := f.(type) {
+		case float64:
+			if v >= 0 {
+				return uint(v)
+			}
+		}
+		return nil
+	}
+
+	toString := func(f any) any {
+		switch v := f.(type) {
+		case string:
+			if len(v) > 0 {
+				return v
+			}
+		}
+		return nil
+	}
+
+	switch v := m["system"].(type) {
+	case float64:
+		remap.System = uint(v)
+	}
+
+	remap.RemoteSystem = toUint(m["remoteSystem"])
+	remap.RemoteTalkgroup = toUint(m["remoteTalkgroup"])
+	remap.SystemLabel = toString(m["systemLabel"])
+	remap.TalkgroupFrom = toUint(m["talkgroupFrom"])
+	remap.TalkgroupLabel = toString(m["talkgroupLabel"])
+	remap.TalkgroupName = toString(m["talkgroupName"])
+	remap.TalkgroupTo = toUint(m["talkgroupTo"])
+
+	if remap.TalkgroupFrom != nil && remap.TalkgroupTo == nil {
+		remap.TalkgroupTo = remap.TalkgroupFrom
+	}
+
+	return remap
+}
+
+func (remap *Remap) Match(system uint, talkgroup any) bool {
+	if remap.System != system {
+		return false
+	}
+
+	from, ok := remap.TalkgroupFrom.(uint)
+	if !ok {
+		return talkgroup == nil
+	}
+
+	to, _ := remap.TalkgroupTo.(uint)
+
+	switch v := talkgroup.(type) {
+	case uint:
+		return v >= from && v <= to
+	}
+
+	return false
+}
+
+func (remap *Remap) Talkgroup(talkgroup uint) uint {
+	remote, ok := remap.RemoteTalkgroup.(uint)
+	if !ok {
+		return talkgroup
+	}
+
+	if from, ok := remap.TalkgroupFrom.(uint); ok {
+		return remote + talkgroup - from
+	}
+
+	return talkgroup
+}
+
+func (remap *Remap) Reverse() *Remap {
+	reversed := &Remap{
+		RemoteSystem:   remap.System,
+		SystemLabel:    remap.SystemLabel,
+		TalkgroupLabel: remap.TalkgroupLabel,
+		TalkgroupName:  remap.TalkgroupName,
+	}
+
+	if v, ok := remap.RemoteSystem.(uint); ok {
+		reversed.System = v
+	} else {
+		reversed.System = remap.System
+	}
+
+	from, ok := remap.TalkgroupFrom.(uint)
+	if !ok {
+		return reversed
+	}
+
+	to, _ := remap.TalkgroupTo.(uint)
+
+	if remote, ok := remap.RemoteTalkgroup.(uint); ok {
+		reversed.RemoteTalkgroup = from
+		reversed.TalkgroupFrom = remote
+		reversed.TalkgroupTo = remote + to - from
+	} else {
+		reversed.TalkgroupFrom = from
+		reversed.TalkgroupTo = to
+	}
+
+	return reversed
+}
+
+func matchRemaps(remaps []*Remap, call *Call) (systemRemap *Remap, talkgroupRemap *Remap) {
+	for _, remap := range remaps {
+		if talkgroupRemap == nil && remap.Match(call.System, call.Talkgroup) {
+			talkgroupRemap = remap
+		} else if systemRemap == nil && remap.Match(call.System, nil) {
+			systemRemap = remap
+		}
+	}
+
+	return systemRemap, talkgroupRemap
+}
+
+func remapCall(remaps []*Remap, call *Call) *Call {
+	if len(remaps) == 0 {
+		return call
+	}
+
+	systemRemap, talkgroupRemap := matchRemaps(remaps, call)
+
+	if systemRemap == nil && talkgroupRemap == nil {
+		return call
+	}
+
+	remapped := *call
+
+	for _, remap := range []*Remap{systemRemap, talkgroupRemap} {
+		if remap == nil {
+			continue
+		}
+
+		if v, ok := remap.RemoteSystem.(uint); ok {
+			remapped.System = v
+		}
+
+		if v, ok := remap.SystemLabel.(string); ok {
+			remapped.systemLabel = v
+		}
+	}
+
+	if talkgroupRemap != nil {
+		remapped.Talkgroup = talkgroupRemap.Talkgroup(call.Talkgroup)
+
+		if v, ok := talkgroupRemap.TalkgroupLabel.(string); ok {
+			remapped.talkgroupLabel = v
+		}
+
+		if v, ok := talkgroupRemap.TalkgroupName.(string); ok {
+			remapped.talkgroupName = v
+		}
+	}
+
+	switch v := call.Patches.(type) {
+	case []uint:
+		patches := []uint{}
+		for _, patch := range v {
+			for _, remap := range remaps {
+				if remap.Match(call.System, patch) {
+					patch = remap.Talkgroup(patch)
+					break
+				}
+			}
+			patches = append(patches, patch)
+		}
+		remapped.Patches = patches
+	}
+
+	return &remapped
+}
+
+func hasSystemsAccess(systems any, call *Call) bool {
+	switch v := systems.(type) {
+	case []any:
+		for _, f := range v {
+			switch v := f.(type) {
+			case map[string]any:
+				switch id := v["id"].(type) {
+				case float64:
+					if id == float64(call.System) {
+						switch tg := v["talkgroups"].(type) {
+						case string:
+							if tg == "*" {
+								return true
+							}
+						case []any:
+							for _, f := range tg {
+								switch tg := f.(type) {
+								case float64:
+									if tg == float64(call.Talkgroup) {
+										return true
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+
+	case string:
+		if v == "*" {
+			return true
+		}
+
+	}
+
+	return false
+}
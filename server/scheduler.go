@@ -80,6 +80,12 @@ func (scheduler *Scheduler) run() {
 	if err := scheduler.pruneLogDatabase(); err != nil {
 		logError(err)
 	}
+
+	if err := scheduler.Controller.WebPush.Prune(scheduler.Controller.Database); err != nil {
+		logError(err)
+	}
+
+	scheduler.Controller.Alerts.Prune()
 }
 
 func (scheduler *Scheduler) Start() error {
@@ -0,0 +1,207 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader          = "X-Rdio-Signature"
+	SignatureDigestHeader    = "X-Rdio-Content-Sha256"
+	SignatureKeyIdHeader     = "X-Rdio-Key-Id"
+	SignatureNonceHeader     = "X-Rdio-Nonce"
+	SignatureTimestampHeader = "X-Rdio-Timestamp"
+	audioTokenTtl            = 15 * time.Minute
+	signatureMaxSkew         = 5 * time.Minute
+)
+
+type SignatureNonces struct {
+	nonces    map[string]time.Time
+	mutex     sync.Mutex
+	lastSweep time.Time
+}
+
+func NewSignatureNonces() *SignatureNonces {
+	return &SignatureNonces{
+		nonces: map[string]time.Time{},
+		mutex:  sync.Mutex{},
+	}
+}
+
+// Use records a nonce and reports whether it was seen before. Nonces are kept as long as their
+// timestamp is within the accepted skew, after which the timestamp check rejects them anyway.
+func (nonces *SignatureNonces) Use(keyId string, nonce string, timestamp time.Time) bool {
+	nonces.mutex.Lock()
+	defer nonces.mutex.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(nonces.lastSweep) > time.Minute {
+		for k, t := range nonces.nonces {
+			if now.Sub(t) > signatureMaxSkew {
+				delete(nonces.nonces, k)
+			}
+		}
+		nonces.lastSweep = now
+	}
+
+	k := keyId + "\n" + nonce
+
+	if _, ok := nonces.nonces[k]; ok {
+		return false
+	}
+
+	nonces.nonces[k] = timestamp
+
+	return true
+}
+
+// GetSigningSecret derives the request signing secret of an api key from its stored hash and the
+// server secret, so that neither the database hash nor the admin config is enough to sign uploads.
+func GetSigningSecret(serverSecret string, keyHash string) string {
+	mac := hmac.New(sha256.New, []byte(serverSecret))
+	mac.Write([]byte("signing\n"))
+	mac.Write([]byte(keyHash))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func SignRequest(req *http.Request, keyId string, secret string, body []byte) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(SignatureDigestHeader, hex.EncodeToString(digest[:]))
+	req.Header.Set(SignatureKeyIdHeader, keyId)
+	req.Header.Set(SignatureNonceHeader, nonce)
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, getSignature(secret, keyId, timestamp, nonce, hex.EncodeToString(digest[:])))
+
+	return nil
+}
+
+func VerifySignature(header http.Header, secret string, digest []byte, nonces *SignatureNonces) error {
+	keyId := header.Get(SignatureKeyIdHeader)
+	nonce := header.Get(SignatureNonceHeader)
+	signature := header.Get(SignatureHeader)
+	timestamp := header.Get(SignatureTimestampHeader)
+
+	if len(nonce) < 16 {
+		return errors.New("invalid signature nonce")
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+
+	if skew := time.Since(time.Unix(t, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return fmt.Errorf("signature timestamp out of range by %v", skew.Round(time.Second))
+	}
+
+	if header.Get(SignatureDigestHeader) != hex.EncodeToString(digest) {
+		return errors.New("content digest mismatch")
+	}
+
+	expected, err := hex.DecodeString(getSignature(secret, keyId, timestamp, nonce, hex.EncodeToString(digest)))
+	if err != nil {
+		return err
+	}
+
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, provided) {
+		return errors.New("signature mismatch")
+	}
+
+	// only a valid signature consumes the nonce, otherwise anyone could burn it
+	if !nonces.Use(keyId, nonce, time.Unix(t, 0)) {
+		return errors.New("signature replayed")
+	}
+
+	return nil
+}
+
+func getSignature(secret string, keyId string, timestamp string, nonce string, digest string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(keyId))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(digest))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func GetAudioToken(secret string, id uint, expiration time.Time) string {
+	timestamp := strconv.FormatInt(expiration.Unix(), 10)
+
+	return fmt.Sprintf("%s.%s", timestamp, getAudioSignature(secret, id, timestamp))
+}
+
+func VerifyAudioToken(secret string, id uint, token string) error {
+	timestamp, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("invalid audio token")
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid audio token")
+	}
+
+	if time.Now().After(time.Unix(t, 0)) {
+		return errors.New("audio token expired")
+	}
+
+	expected, err := hex.DecodeString(getAudioSignature(secret, id, timestamp))
+	if err != nil {
+		return err
+	}
+
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, provided) {
+		return errors.New("invalid audio token")
+	}
+
+	return nil
+}
+
+func getAudioSignature(secret string, id uint, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatUint(uint64(id), 10)))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(timestamp))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestSignedRequest(t *testing.T, keyId string, secret string, body []byte) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/call-upload", bytes.NewReader(body))
+	if err := SignRequest(req, keyId, secret, body); err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte("payload")
+	digest := sha256.Sum256(body)
+	keyId := HashApikey("key")
+	secret := GetSigningSecret("server secret", keyId)
+
+	if strings.Contains(keyId, secret) || secret == GetSigningSecret("other secret", keyId) {
+		t.Fatal("signing secret not bound to the server secret")
+	}
+
+	nonces := NewSignatureNonces()
+
+	req := newTestSignedRequest(t, keyId, secret, body)
+	if err := VerifySignature(req.Header, secret, digest[:], nonces); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifySignature(req.Header, secret, digest[:], nonces); err == nil || err.Error() != "signature replayed" {
+		t.Fatalf("replay accepted: %v", err)
+	}
+
+	// the stored hash alone cannot sign
+	req = newTestSignedRequest(t, keyId, strings.TrimPrefix(keyId, apikeyHashPrefix), body)
+	if err := VerifySignature(req.Header, secret, digest[:], nonces); err == nil {
+		t.Fatal("signature with the key hash accepted")
+	}
+
+	req = newTestSignedRequest(t, keyId, secret, body)
+	tampered := sha256.Sum256([]byte("tampered"))
+	if err := VerifySignature(req.Header, secret, tampered[:], nonces); err == nil {
+		t.Fatal("tampered body accepted")
+	}
+
+	req = newTestSignedRequest(t, keyId, secret, body)
+	req.Header.Set(SignatureKeyIdHeader, HashApikey("other"))
+	if err := VerifySignature(req.Header, secret, digest[:], nonces); err == nil {
+		t.Fatal("altered key id accepted")
+	}
+}
+
+func TestSignedCallUpload(t *testing.T) {
+	controller := newTestController(t)
+	controller.Options.secret = "server secret"
+
+	apikey := &Apikey{Id: uint(1), Ident: "remote", Key: HashApikey("key"), RequireSignature: true, Systems: "*"}
+	controller.Apikeys.List = []*Apikey{apikey}
+
+	api := NewApi(controller)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(b))
+
+		if len(r.Header.Get(SignatureHeader)) > 0 && strings.Contains(string(b), `name="key"`) {
+			t.Error("api key sent in clear")
+		}
+
+		api.CallUploadHandler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	call := newTestDownstreamCall()
+	call.Audio = bytes.Repeat([]byte("audio"), 20)
+
+	downstream := &Downstream{Apikey: "key", Sign: true, Url: server.URL}
+
+	if err := downstream.Send(controller, newTestDownstreamCall()); err == nil {
+		t.Fatal("sent without a signing secret")
+	}
+
+	downstream = &Downstream{Apikey: "key", Sign: true, SigningSecret: GetSigningSecret("server secret", apikey.Key), Url: server.URL}
+
+	if err := downstream.Send(controller, call); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(controller.Ingest) != 1 {
+		t.Fatalf("got %d ingested calls, want 1", len(controller.Ingest))
+	}
+
+	// an unsigned upload is refused for a key that requires signatures
+	unsigned := &Downstream{Apikey: "key", Url: server.URL}
+	if err := unsigned.Send(controller, call); err == nil {
+		t.Fatal("unsigned upload accepted")
+	}
+
+	if len(controller.Ingest) != 1 {
+		t.Fatalf("got %d ingested calls, want 1", len(controller.Ingest))
+	}
+}
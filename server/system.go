@@ -32,6 +32,7 @@ type System struct {
 	Label        string      `json:"label"`
 	Led          any         `json:"led"`
 	Order        uint        `json:"order"`
+	RemoteId     any         `json:"remoteId"`
 	RowId        any         `json:"_id"`
 	Talkgroups   *Talkgroups `json:"talkgroups"`
 	Units        *Units      `json:"units"`
@@ -80,6 +81,15 @@ func (system *System) FromMap(m map[string]any) *System {
 		system.Order = uint(v)
 	}
 
+	switch v := m["remoteId"].(type) {
+	case string:
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			system.RemoteId = v
+		}
+	case float64:
+		system.RemoteId = fmt.Sprintf("%v", uint(v))
+	}
+
 	switch v := m["talkgroups"].(type) {
 	case []any:
 		system.Talkgroups.FromMap(v)
@@ -168,13 +178,15 @@ func (systems *Systems) GetScopedSystems(client *Client, groups *Groups, tags *T
 		systemsMap = SystemsMap{}
 	)
 
-	if client.Access == nil {
+	access := client.GetAccess()
+
+	if access == nil {
 		for _, system := range systems.List {
 			rawSystems = append(rawSystems, *system)
 		}
 
 	} else {
-		switch v := client.Access.Systems.(type) {
+		switch v := access.Systems.(type) {
 		case nil:
 			for _, system := range systems.List {
 				rawSystems = append(rawSystems, *system)
@@ -326,6 +338,7 @@ func (systems *Systems) Read(db *Database) error {
 		err        error
 		led        sql.NullString
 		order      sql.NullFloat64
+		remoteId   sql.NullString
 		rowId      sql.NullFloat64
 		rows       *sql.Rows
 	)
@@ -339,9 +352,9 @@ func (systems *Systems) Read(db *Database) error {
 		return fmt.Errorf("systems.read: %v", err)
 	}
 
-	q := "select `_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order` from `rdioScannerSystems`"
+	q := "select `_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`, `remoteId` from `rdioScannerSystems`"
 	if db.Config.DbType == DbTypePostgresql {
-		q = "select _id, autoPopulate, blacklists, id, label, led, \"order\" from rdioScannerSystems"
+		q = "select _id, autoPopulate, blacklists, id, label, led, \"order\", remoteId from rdioScannerSystems"
 	}
 	if rows, err = db.Sql.Query(q); err != nil {
 		return formatError(err)
@@ -353,7 +366,7 @@ func (systems *Systems) Read(db *Database) error {
 			Units:      NewUnits(),
 		}
 
-		if err = rows.Scan(&rowId, &system.AutoPopulate, &blacklists, &system.Id, &system.Label, &led, &order); err != nil {
+		if err = rows.Scan(&rowId, &system.AutoPopulate, &blacklists, &system.Id, &system.Label, &led, &order, &remoteId); err != nil {
 			break
 		}
 
@@ -375,6 +388,10 @@ func (systems *Systems) Read(db *Database) error {
 			system.Order = uint(order.Float64)
 		}
 
+		if remoteId.Valid && len(remoteId.String) > 0 {
+			system.RemoteId = remoteId.String
+		}
+
 		if err = system.Talkgroups.Read(db, system.Id); err != nil {
 			return err
 		}
@@ -502,20 +519,20 @@ func (systems *Systems) Write(db *Database) error {
 		}
 
 		if count == 0 {
-			q = "insert into `rdioScannerSystems` (`_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`) values (?, ?, ?, ?, ?, ?, ?)"
+			q = "insert into `rdioScannerSystems` (`_id`, `autoPopulate`, `blacklists`, `id`, `label`, `led`, `order`, `remoteId`) values (?, ?, ?, ?, ?, ?, ?, ?)"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "insert into rdioScannerSystems (_id, autoPopulate, blacklists, id, label, led, \"order\") values ($1, $2, $3, $4, $5, $6, $7)"
+				q = "insert into rdioScannerSystems (_id, autoPopulate, blacklists, id, label, led, \"order\", remoteId) values ($1, $2, $3, $4, $5, $6, $7, $8)"
 			}
-			if _, err = db.Sql.Exec(q, system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order); err != nil {
+			if _, err = db.Sql.Exec(q, system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.RemoteId); err != nil {
 				break
 			}
 
 		} else {
-			q = "update `rdioScannerSystems` set `_id` = ?, `autoPopulate` = ?, `blacklists` = ?, `id` = ?, `label` = ?, `led` = ?, `order` = ? where `_id` = ?"
+			q = "update `rdioScannerSystems` set `_id` = ?, `autoPopulate` = ?, `blacklists` = ?, `id` = ?, `label` = ?, `led` = ?, `order` = ?, `remoteId` = ? where `_id` = ?"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "update rdioScannerSystems set _id = $1, autoPopulate = $2, blacklists = $3, id = $4, label = $5, led = $6, \"order\" = $7 where _id = $8"
+				q = "update rdioScannerSystems set _id = $1, autoPopulate = $2, blacklists = $3, id = $4, label = $5, led = $6, \"order\" = $7, remoteId = $8 where _id = $9"
 			}
-			if _, err = db.Sql.Exec(q, system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.RowId); err != nil {
+			if _, err = db.Sql.Exec(q, system.RowId, system.AutoPopulate, blacklists, system.Id, system.Label, system.Led, system.Order, system.RemoteId, system.RowId); err != nil {
 				break
 			}
 		}
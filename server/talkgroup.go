@@ -33,6 +33,7 @@ type Talkgroup struct {
 	Led       any    `json:"led"`
 	Name      string `json:"name"`
 	Order     uint   `json:"order"`
+	RemoteId  any    `json:"remoteId"`
 	TagId     uint   `json:"tagId"`
 	tag       string
 }
@@ -78,6 +79,13 @@ func (talkgroup *Talkgroup) FromMap(m map[string]any) *Talkgroup {
 		talkgroup.Order = uint(v)
 	}
 
+	switch v := m["remoteId"].(type) {
+	case float64:
+		if v > 0 {
+			talkgroup.RemoteId = uint(v)
+		}
+	}
+
 	switch v := m["tag"].(type) {
 	case string:
 		talkgroup.tag = v
@@ -150,6 +158,7 @@ func (talkgroups *Talkgroups) Read(db *Database, systemId uint) error {
 		err       error
 		frequency sql.NullFloat64
 		led       sql.NullString
+		remoteId  sql.NullFloat64
 		rows      *sql.Rows
 	)
 
@@ -162,9 +171,9 @@ func (talkgroups *Talkgroups) Read(db *Database, systemId uint) error {
 		return fmt.Errorf("talkgroups.read: %v", err)
 	}
 
-	q := "select `frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `tagId` from `rdioScannerTalkgroups` where `systemId` = ?"
+	q := "select `frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `remoteId`, `tagId` from `rdioScannerTalkgroups` where `systemId` = ?"
 	if db.Config.DbType == DbTypePostgresql {
-		q = "select frequency, groupId, id, label, led, name, \"order\", tagId from rdioScannerTalkgroups where systemId = $1"
+		q = "select frequency, groupId, id, label, led, name, \"order\", remoteId, tagId from rdioScannerTalkgroups where systemId = $1"
 	}
 	if rows, err = db.Sql.Query(q, systemId); err != nil {
 		return formatError(err)
@@ -173,7 +182,7 @@ func (talkgroups *Talkgroups) Read(db *Database, systemId uint) error {
 	for rows.Next() {
 		talkgroup := &Talkgroup{}
 
-		if err = rows.Scan(&frequency, &talkgroup.GroupId, &talkgroup.Id, &talkgroup.Label, &led, &talkgroup.Name, &talkgroup.Order, &talkgroup.TagId); err != nil {
+		if err = rows.Scan(&frequency, &talkgroup.GroupId, &talkgroup.Id, &talkgroup.Label, &led, &talkgroup.Name, &talkgroup.Order, &remoteId, &talkgroup.TagId); err != nil {
 			break
 		}
 
@@ -185,6 +194,10 @@ func (talkgroups *Talkgroups) Read(db *Database, systemId uint) error {
 			talkgroup.Led = led.String
 		}
 
+		if remoteId.Valid && remoteId.Float64 > 0 {
+			talkgroup.RemoteId = uint(remoteId.Float64)
+		}
+
 		talkgroups.List = append(talkgroups.List, talkgroup)
 	}
 
@@ -272,20 +285,20 @@ func (talkgroups *Talkgroups) Write(db *Database, systemId uint) error {
 		}
 
 		if count == 0 {
-			q = "insert into `rdioScannerTalkgroups` (`frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `systemId`, `tagId`) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+			q = "insert into `rdioScannerTalkgroups` (`frequency`, `groupId`, `id`, `label`, `led`, `name`, `order`, `remoteId`, `systemId`, `tagId`) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "insert into rdioScannerTalkgroups (frequency, groupId, id, label, led, name, \"order\", systemId, tagId) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+				q = "insert into rdioScannerTalkgroups (frequency, groupId, id, label, led, name, \"order\", remoteId, systemId, tagId) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
 			}
-			if _, err = db.Sql.Exec(q, talkgroup.Frequency, talkgroup.GroupId, talkgroup.Id, talkgroup.Label, talkgroup.Led, talkgroup.Name, talkgroup.Order, systemId, talkgroup.TagId); err != nil {
+			if _, err = db.Sql.Exec(q, talkgroup.Frequency, talkgroup.GroupId, talkgroup.Id, talkgroup.Label, talkgroup.Led, talkgroup.Name, talkgroup.Order, talkgroup.RemoteId, systemId, talkgroup.TagId); err != nil {
 				break
 			}
 
 		} else {
-			q = "update `rdioScannerTalkgroups` set `frequency` = ?, `groupId` = ?, `label` = ?, `led` = ?, `name` = ?, `order` = ?, `tagId` = ? where `id` = ? and `systemId` = ?"
+			q = "update `rdioScannerTalkgroups` set `frequency` = ?, `groupId` = ?, `label` = ?, `led` = ?, `name` = ?, `order` = ?, `remoteId` = ?, `tagId` = ? where `id` = ? and `systemId` = ?"
 			if db.Config.DbType == DbTypePostgresql {
-				q = "update rdioScannerTalkgroups set frequency = $1, groupId = $2, label = $3, led = $4, name = $5, \"order\" = $6, tagId = $7 where id = $8 and systemId = $9"
+				q = "update rdioScannerTalkgroups set frequency = $1, groupId = $2, label = $3, led = $4, name = $5, \"order\" = $6, remoteId = $7, tagId = $8 where id = $9 and systemId = $10"
 			}
-			if _, err = db.Sql.Exec(q, talkgroup.Frequency, talkgroup.GroupId, talkgroup.Label, talkgroup.Led, talkgroup.Name, talkgroup.Order, talkgroup.TagId, talkgroup.Id, systemId); err != nil {
+			if _, err = db.Sql.Exec(q, talkgroup.Frequency, talkgroup.GroupId, talkgroup.Label, talkgroup.Led, talkgroup.Name, talkgroup.Order, talkgroup.RemoteId, talkgroup.TagId, talkgroup.Id, systemId); err != nil {
 				break
 			}
 		}
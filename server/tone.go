@@ -0,0 +1,563 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	toneDetectQueueSize = 64
+	toneDetectWorkers   = 2
+	toneFrameSize       = 800 // 100ms at toneSampleRate
+	toneFrameStep       = 400
+	toneMaxGap          = 3 // frames between the a and b tones
+	toneMinDurationA    = 0.6
+	toneMinDurationB    = 0.6
+	toneMinDurationOne  = 2.0
+	toneMinLevel        = 1e-4
+	toneSampleRate      = 8000
+	toneThreshold       = 0.6
+	toneTolerance       = 0.01
+)
+
+type CallTone struct {
+	A         float64 `json:"a"`
+	B         float64 `json:"b,omitempty"`
+	Label     string  `json:"label"`
+	ToneSetId uint    `json:"toneSetId"`
+}
+
+type ToneSet struct {
+	Id      any     `json:"_id"`
+	A       float64 `json:"a"`
+	B       float64 `json:"b"`
+	Label   string  `json:"label"`
+	Order   any     `json:"order"`
+	Systems any     `json:"systems"`
+}
+
+func (toneSet *ToneSet) FromMap(m map[string]any) *ToneSet {
+	switch v := m["_id"].(type) {
+	case float64:
+		toneSet.Id = uint(v)
+	}
+
+	switch v := m["a"].(type) {
+	case float64:
+		toneSet.A = v
+	}
+
+	switch v := m["b"].(type) {
+	case float64:
+		toneSet.B = v
+	}
+
+	switch v := m["label"].(type) {
+	case string:
+		toneSet.Label = v
+	}
+
+	switch v := m["order"].(type) {
+	case float64:
+		toneSet.Order = uint(v)
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			toneSet.Systems = string(b)
+		}
+	case string:
+		toneSet.Systems = v
+	}
+
+	return toneSet
+}
+
+func (toneSet *ToneSet) HasAccess(call *Call) bool {
+	if toneSet.A <= 0 {
+		return false
+	}
+
+	return hasSystemsAccess(toneSet.Systems, call)
+}
+
+type ToneSets struct {
+	List  []*ToneSet
+	jobs  chan *toneJob
+	mutex sync.Mutex
+}
+
+type toneJob struct {
+	audio      []byte
+	call       *Call
+	controller *Controller
+}
+
+func NewToneSets() *ToneSets {
+	toneSets := &ToneSets{
+		List:  []*ToneSet{},
+		jobs:  make(chan *toneJob, toneDetectQueueSize),
+		mutex: sync.Mutex{},
+	}
+
+	for i := 0; i < toneDetectWorkers; i++ {
+		go func() {
+			for job := range toneSets.jobs {
+				toneSets.detect(job)
+			}
+		}()
+	}
+
+	return toneSets
+}
+
+// Detect decodes the audio and matches the tone sets configured for the talkgroup of the call
+func (toneSets *ToneSets) Detect(call *Call, audio []byte, ffmpeg *FFMpeg) ([]*CallTone, error) {
+	candidates := toneSets.getToneSets(call)
+	if len(candidates) == 0 || len(audio) == 0 {
+		return nil, nil
+	}
+
+	samples, err := ffmpeg.DecodePcm(audio, toneSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("tonesets.detect: %v", err)
+	}
+
+	return matchToneSets(detectToneSegments(samples, getToneFrequencies(candidates)), candidates), nil
+}
+
+// Enqueue detects the tones of a written call off the ingest path, the tones are then
+// recorded on the call and the alerts on tone sets are run. audio is the call audio as
+// received, before any conversion.
+func (toneSets *ToneSets) Enqueue(call *Call, audio []byte, controller *Controller) bool {
+	select {
+	case toneSets.jobs <- &toneJob{audio: audio, call: call, controller: controller}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (toneSets *ToneSets) FromMap(f []any) *ToneSets {
+	toneSets.mutex.Lock()
+	defer toneSets.mutex.Unlock()
+
+	toneSets.List = []*ToneSet{}
+
+	for _, r := range f {
+		switch m := r.(type) {
+		case map[string]any:
+			toneSets.List = append(toneSets.List, (&ToneSet{}).FromMap(m))
+		}
+	}
+
+	return toneSets
+}
+
+func (toneSets *ToneSets) HasAccess(call *Call) bool {
+	return len(toneSets.getToneSets(call)) > 0
+}
+
+func (toneSets *ToneSets) Read(db *Database) error {
+	var (
+		err     error
+		id      sql.NullFloat64
+		order   sql.NullFloat64
+		rows    *sql.Rows
+		systems string
+	)
+
+	toneSets.mutex.Lock()
+	defer toneSets.mutex.Unlock()
+
+	toneSets.List = []*ToneSet{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("tonesets.read: %v", err)
+	}
+
+	q := "select `_id`, `a`, `b`, `label`, `order`, `systems` from `rdioScannerToneSets`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id, a, b, label, \"order\", systems from rdioScannerToneSets"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		toneSet := &ToneSet{}
+
+		if err = rows.Scan(&id, &toneSet.A, &toneSet.B, &toneSet.Label, &order, &systems); err != nil {
+			break
+		}
+
+		if id.Valid && id.Float64 > 0 {
+			toneSet.Id = uint(id.Float64)
+		}
+
+		if order.Valid && order.Float64 > 0 {
+			toneSet.Order = uint(order.Float64)
+		}
+
+		if err = json.Unmarshal([]byte(systems), &toneSet.Systems); err != nil {
+			toneSet.Systems = []any{}
+		}
+
+		toneSets.List = append(toneSets.List, toneSet)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (toneSets *ToneSets) Write(db *Database) error {
+	var (
+		count   uint
+		err     error
+		rows    *sql.Rows
+		rowIds  = []uint{}
+		systems any
+	)
+
+	toneSets.mutex.Lock()
+	defer toneSets.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("tonesets.write: %v", err)
+	}
+
+	q := "select `_id` from `rdioScannerToneSets`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id from rdioScannerToneSets"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var rowId uint
+		if err = rows.Scan(&rowId); err != nil {
+			break
+		}
+		remove := true
+		for _, toneSet := range toneSets.List {
+			if toneSet.Id == nil || toneSet.Id == rowId {
+				remove = false
+				break
+			}
+		}
+		if remove {
+			rowIds = append(rowIds, rowId)
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	if len(rowIds) > 0 {
+		if b, err := json.Marshal(rowIds); err == nil {
+			s := string(b)
+			s = strings.ReplaceAll(s, "[", "(")
+			s = strings.ReplaceAll(s, "]", ")")
+			q := fmt.Sprintf("delete from `rdioScannerToneSets` where `_id` in %v", s)
+			if db.Config.DbType == DbTypePostgresql {
+				q = fmt.Sprintf("delete from rdioScannerToneSets where _id in %v", s)
+			}
+			if _, err = db.Sql.Exec(q); err != nil {
+				return formatError(err)
+			}
+		}
+	}
+
+	for _, toneSet := range toneSets.List {
+		switch toneSet.Systems {
+		case nil:
+			systems = `"*"`
+		case "*":
+			systems = `"*"`
+		default:
+			systems = toneSet.Systems
+		}
+
+		q := "select count(*) from `rdioScannerToneSets` where `_id` = ?"
+		if db.Config.DbType == DbTypePostgresql {
+			q = "select count(*) from rdioScannerToneSets where _id = $1"
+		}
+		if err = db.Sql.QueryRow(q, toneSet.Id).Scan(&count); err != nil {
+			break
+		}
+
+		if count == 0 {
+			q := "insert into `rdioScannerToneSets` (`_id`, `a`, `b`, `label`, `order`, `systems`) values (?, ?, ?, ?, ?, ?)"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "insert into rdioScannerToneSets (_id, a, b, label, \"order\", systems) values ($1, $2, $3, $4, $5, $6)"
+			}
+			if _, err = db.Sql.Exec(q, toneSet.Id, toneSet.A, toneSet.B, toneSet.Label, toneSet.Order, systems); err != nil {
+				break
+			}
+
+		} else {
+			q := "update `rdioScannerToneSets` set `_id` = ?, `a` = ?, `b` = ?, `label` = ?, `order` = ?, `systems` = ? where `_id` = ?"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "update rdioScannerToneSets set _id = $1, a = $2, b = $3, label = $4, \"order\" = $5, systems = $6 where _id = $7"
+			}
+			if _, err = db.Sql.Exec(q, toneSet.Id, toneSet.A, toneSet.B, toneSet.Label, toneSet.Order, systems, toneSet.Id); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (toneSets *ToneSets) detect(job *toneJob) {
+	controller := job.controller
+
+	tones, err := toneSets.Detect(job.call, job.audio, controller.FFMpeg)
+	if err != nil {
+		controller.Logs.LogEvent(LogLevelWarn, err.Error())
+		return
+	}
+
+	if len(tones) == 0 {
+		return
+	}
+
+	id, _ := job.call.Id.(uint)
+
+	if err = controller.Calls.UpdateTones(id, tones, controller.Database); err != nil {
+		controller.Logs.LogEvent(LogLevelError, err.Error())
+		return
+	}
+
+	labels := []string{}
+	for _, tone := range tones {
+		labels = append(labels, tone.Label)
+	}
+
+	controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("newcall: system=%v talkgroup=%v id=%v tones detected: %s", job.call.System, job.call.Talkgroup, id, strings.Join(labels, ", ")))
+
+	// listeners and downstreams may still be reading the ingested call, alert on a copy
+	call := *job.call
+	call.Tones = tones
+
+	controller.Alerts.ProcessTones(&call, controller)
+}
+
+func (toneSets *ToneSets) getToneSets(call *Call) []*ToneSet {
+	toneSets.mutex.Lock()
+	defer toneSets.mutex.Unlock()
+
+	list := []*ToneSet{}
+
+	for _, toneSet := range toneSets.List {
+		if toneSet.HasAccess(call) {
+			list = append(list, toneSet)
+		}
+	}
+
+	return list
+}
+
+type toneSegment struct {
+	duration  float64
+	frequency float64
+}
+
+// detectToneSegments runs goertzel filters on hann windowed frames for each candidate frequency,
+// probing a few points within the tolerance to absorb encoder drift. consecutive frames dominated
+// by the same frequency are merged into segments, silence or voice frames are returned with a 0 frequency.
+func detectToneSegments(samples []float64, frequencies []float64) []toneSegment {
+	var (
+		segments = []toneSegment{}
+		window   = make([]float64, toneFrameSize)
+		frame    = make([]float64, toneFrameSize)
+		windowSq float64
+		windowSu float64
+	)
+
+	if len(frequencies) == 0 {
+		return segments
+	}
+
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(toneFrameSize-1))
+		windowSq += window[i] * window[i]
+		windowSu += window[i]
+	}
+
+	frameDuration := float64(toneFrameStep) / toneSampleRate
+
+	for start := 0; start+toneFrameSize <= len(samples); start += toneFrameStep {
+		var (
+			best      float64
+			energy    float64
+			frequency float64
+		)
+
+		for i := range frame {
+			frame[i] = samples[start+i] * window[i]
+			energy += frame[i] * frame[i]
+		}
+
+		if energy/windowSq > toneMinLevel {
+			for _, f := range frequencies {
+				step := math.Max(2, f*toneTolerance/3)
+
+				for probe := f * (1 - toneTolerance); probe <= f*(1+toneTolerance); probe += step {
+					// normalized so that a pure sine scores 1
+					if r := 2 * goertzel(frame, probe) * windowSq / (energy * windowSu * windowSu); r > best {
+						best = r
+						frequency = f
+					}
+				}
+			}
+		}
+
+		if best < toneThreshold {
+			frequency = 0
+		}
+
+		n := len(segments)
+
+		switch {
+		case n > 0 && segments[n-1].frequency == frequency:
+			segments[n-1].duration += frameDuration
+
+		// a single frame drop out within a tone is merged back into it
+		case n > 1 && frequency > 0 && segments[n-2].frequency == frequency && segments[n-1].duration <= frameDuration:
+			segments[n-2].duration += segments[n-1].duration + frameDuration
+			segments = segments[:n-1]
+
+		default:
+			segments = append(segments, toneSegment{duration: frameDuration, frequency: frequency})
+		}
+	}
+
+	return segments
+}
+
+func getToneFrequencies(toneSets []*ToneSet) []float64 {
+	frequencies := []float64{}
+
+	for _, toneSet := range toneSets {
+		for _, f := range []float64{toneSet.A, toneSet.B} {
+			if f > 0 && f < toneSampleRate/2 {
+				frequencies = append(frequencies, f)
+			}
+		}
+	}
+
+	sort.Float64s(frequencies)
+
+	return frequencies
+}
+
+// the ids are stored comma delimited with leading and trailing commas, ie. ,3,7, so that
+// searches can match a single id with like '%,3,%'
+func getToneSetIds(tones []*CallTone) any {
+	if len(tones) == 0 {
+		return nil
+	}
+
+	ids := []string{}
+	for _, tone := range tones {
+		ids = append(ids, fmt.Sprintf("%d", tone.ToneSetId))
+	}
+
+	return fmt.Sprintf(",%s,", strings.Join(ids, ","))
+}
+
+func goertzel(frame []float64, frequency float64) float64 {
+	var (
+		coeff = 2 * math.Cos(2*math.Pi*frequency/toneSampleRate)
+		s1    float64
+		s2    float64
+	)
+
+	for _, x := range frame {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+func matchToneSets(segments []toneSegment, toneSets []*ToneSet) []*CallTone {
+	var (
+		gap     = float64(toneMaxGap*toneFrameStep) / toneSampleRate
+		matches = []*CallTone{}
+	)
+
+	seen := map[any]bool{}
+
+	for i, segment := range segments {
+		if segment.frequency == 0 {
+			continue
+		}
+
+		for _, toneSet := range toneSets {
+			if seen[toneSet.Id] || segment.frequency != toneSet.A {
+				continue
+			}
+
+			if toneSet.B <= 0 {
+				if segment.duration >= toneMinDurationOne {
+					seen[toneSet.Id] = true
+				}
+
+			} else if segment.duration >= toneMinDurationA {
+				// the b tone may follow after a short drop out
+				for j, silence := i+1, 0.0; j < len(segments) && silence <= gap; j++ {
+					if segments[j].frequency == 0 {
+						silence += segments[j].duration
+						continue
+					}
+					if segments[j].frequency == toneSet.B && segments[j].duration >= toneMinDurationB {
+						seen[toneSet.Id] = true
+					}
+					break
+				}
+			}
+
+			if seen[toneSet.Id] {
+				id, _ := toneSet.Id.(uint)
+				matches = append(matches, &CallTone{A: toneSet.A, B: toneSet.B, Label: toneSet.Label, ToneSetId: id})
+			}
+		}
+	}
+
+	return matches
+}
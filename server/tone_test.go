@@ -0,0 +1,173 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type testTone struct {
+	frequency float64
+	duration  float64
+}
+
+// sines at toneSampleRate with a little noise, a 0 frequency is silence
+func newTestTonePcm(tones ...testTone) []float64 {
+	var (
+		rnd     = rand.New(rand.NewSource(1))
+		samples = []float64{}
+	)
+
+	for _, tone := range tones {
+		n := int(tone.duration * toneSampleRate)
+		for i := 0; i < n; i++ {
+			s := 0.01 * (rnd.Float64() - 0.5)
+			if tone.frequency > 0 {
+				s += 0.5 * math.Sin(2*math.Pi*tone.frequency*float64(i)/toneSampleRate)
+			}
+			samples = append(samples, s)
+		}
+	}
+
+	return samples
+}
+
+func TestDetectToneSegments(t *testing.T) {
+	frequencies := []float64{600.9, 832.5, 1092.4}
+
+	samples := newTestTonePcm(testTone{0, 0.5}, testTone{600.9, 1}, testTone{832.5, 3}, testTone{0, 0.5})
+
+	durations := map[float64]float64{}
+	for _, segment := range detectToneSegments(samples, frequencies) {
+		durations[segment.frequency] += segment.duration
+	}
+
+	for f, want := range map[float64]float64{600.9: 1, 832.5: 3} {
+		if got := durations[f]; math.Abs(got-want) > 0.2 {
+			t.Errorf("%v hz lasted %.2fs, want about %.2fs", f, got, want)
+		}
+	}
+
+	if durations[1092.4] != 0 {
+		t.Errorf("1092.4 hz detected for %.2fs", durations[1092.4])
+	}
+
+	// a tone slightly off its nominal frequency still counts
+	drifted := newTestTonePcm(testTone{600.9 * 1.005, 1})
+	if segments := detectToneSegments(drifted, frequencies); len(segments) == 0 || segments[0].frequency != 600.9 {
+		t.Errorf("drifted tone not detected, %+v", segments)
+	}
+
+	if segments := detectToneSegments(newTestTonePcm(testTone{0, 2}), frequencies); len(segments) != 1 || segments[0].frequency != 0 {
+		t.Errorf("silence detected as tones, %+v", segments)
+	}
+}
+
+func TestMatchToneSets(t *testing.T) {
+	toneSets := []*ToneSet{
+		{Id: uint(1), A: 600.9, B: 832.5, Label: "Station 1"},
+		{Id: uint(2), A: 600.9, B: 1092.4, Label: "Station 2"},
+		{Id: uint(3), A: 1092.4, Label: "All Call"},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		tones []testTone
+		want  []uint
+	}{
+		{name: "two tone", tones: []testTone{{600.9, 1}, {832.5, 3}}, want: []uint{1}},
+		{name: "other b tone", tones: []testTone{{600.9, 1}, {1092.4, 1}}, want: []uint{2}},
+		{name: "long b tone", tones: []testTone{{600.9, 1}, {1092.4, 3}}, want: []uint{2, 3}},
+		{name: "short gap", tones: []testTone{{600.9, 1}, {0, 0.05}, {832.5, 3}}, want: []uint{1}},
+		{name: "long gap", tones: []testTone{{600.9, 1}, {0, 1}, {832.5, 3}}},
+		{name: "short a tone", tones: []testTone{{600.9, 0.3}, {832.5, 3}}},
+		{name: "short b tone", tones: []testTone{{600.9, 1}, {832.5, 0.3}}},
+		{name: "swapped tones", tones: []testTone{{832.5, 1}, {600.9, 3}}},
+		{name: "single tone", tones: []testTone{{1092.4, 3}}, want: []uint{3}},
+		{name: "short single tone", tones: []testTone{{1092.4, 1}}},
+		{name: "silence", tones: []testTone{{0, 3}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := newTestTonePcm(append(append([]testTone{{0, 0.5}}, tc.tones...), testTone{0, 0.5})...)
+
+			matches := matchToneSets(detectToneSegments(samples, getToneFrequencies(toneSets)), toneSets)
+
+			got := []uint{}
+			for _, match := range matches {
+				got = append(got, match.ToneSetId)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("matched %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("matched %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCallsUpdateTones(t *testing.T) {
+	controller := newTestController(t)
+
+	client := &Client{Controller: controller}
+	client.SetAccess(NewAccess())
+
+	for i, tones := range [][]*CallTone{
+		{{A: 600.9, B: 832.5, Label: "Station 3", ToneSetId: 3}},
+		{{A: 600.9, B: 832.5, Label: "Station 30", ToneSetId: 30}, {A: 1092.4, Label: "All Call", ToneSetId: 13}},
+		nil,
+	} {
+		call := newTestQueuedCall(t, controller, i)
+		if tones != nil {
+			if err := controller.Calls.UpdateTones(call.Id.(uint), tones, controller.Database); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		toneSet any
+		tones   any
+		want    uint
+	}{
+		{toneSet: uint(3), want: 1},
+		{toneSet: uint(30), want: 1},
+		{toneSet: uint(13), want: 1},
+		{toneSet: uint(1), want: 0},
+		{tones: true, want: 2},
+	} {
+		results, err := controller.Calls.Search(&CallsSearchOptions{ToneSet: tc.toneSet, Tones: tc.tones}, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.Count != tc.want {
+			t.Errorf("tone set %v matched %d calls, want %d", tc.toneSet, results.Count, tc.want)
+		}
+	}
+
+	call, err := controller.Calls.GetCall(2, controller.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tones, ok := call.Tones.([]*CallTone); !ok || len(tones) != 2 || tones[1].Label != "All Call" {
+		t.Errorf("unexpected tones %+v", call.Tones)
+	}
+}
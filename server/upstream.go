@@ -0,0 +1,873 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	upstreamBackoffMax = time.Minute
+	upstreamBackoffMin = time.Second
+	upstreamListLimit  = 500
+	upstreamPongWait   = 60 * time.Second
+	upstreamSeenSize   = 1000
+	upstreamWriteWait  = 10 * time.Second
+)
+
+type Upstream struct {
+	Id           any      `json:"_id"`
+	AccessCode   string   `json:"accessCode"`
+	Disabled     bool     `json:"disabled"`
+	Order        any      `json:"order"`
+	Remaps       []*Remap `json:"remaps"`
+	Systems      any      `json:"systems"`
+	Url          string   `json:"url"`
+	conn         *websocket.Conn
+	controller   *Controller
+	lastDateTime time.Time
+	mutex        sync.Mutex
+	remaps       []*Remap
+	seen         map[uint]bool
+	seenList     []uint
+	stop         chan struct{}
+	systemsMap   map[uint]map[string]any
+}
+
+func NewUpstream() *Upstream {
+	return &Upstream{
+		Remaps:     []*Remap{},
+		mutex:      sync.Mutex{},
+		seen:       map[uint]bool{},
+		seenList:   []uint{},
+		systemsMap: map[uint]map[string]any{},
+	}
+}
+
+func (upstream *Upstream) FromMap(m map[string]any) *Upstream {
+	switch v := m["_id"].(type) {
+	case float64:
+		upstream.Id = uint(v)
+	}
+
+	switch v := m["accessCode"].(type) {
+	case string:
+		upstream.AccessCode = v
+	}
+
+	switch v := m["disabled"].(type) {
+	case bool:
+		upstream.Disabled = v
+	}
+
+	switch v := m["order"].(type) {
+	case float64:
+		upstream.Order = uint(v)
+	}
+
+	upstream.Remaps = []*Remap{}
+
+	switch v := m["remaps"].(type) {
+	case []any:
+		for _, r := range v {
+			switch m := r.(type) {
+			case map[string]any:
+				upstream.Remaps = append(upstream.Remaps, (&Remap{}).FromMap(m))
+			}
+		}
+	}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		if b, err := json.Marshal(v); err == nil {
+			upstream.Systems = string(b)
+		}
+	case string:
+		upstream.Systems = v
+	}
+
+	switch v := m["url"].(type) {
+	case string:
+		upstream.Url = v
+	}
+
+	return upstream
+}
+
+func (upstream *Upstream) HasAccess(call *Call) bool {
+	return hasSystemsAccess(upstream.Systems, call)
+}
+
+func (upstream *Upstream) Start(controller *Controller) error {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	if upstream.Disabled {
+		return nil
+	}
+
+	if upstream.stop != nil {
+		return errors.New("upstream.start: already started")
+	}
+
+	upstream.controller = controller
+	upstream.remaps = []*Remap{}
+	upstream.stop = make(chan struct{})
+
+	for _, remap := range upstream.Remaps {
+		upstream.remaps = append(upstream.remaps, remap.Reverse())
+	}
+
+	go upstream.run(upstream.stop)
+
+	return nil
+}
+
+func (upstream *Upstream) Stop() {
+	upstream.mutex.Lock()
+	defer upstream.mutex.Unlock()
+
+	if upstream.stop != nil {
+		close(upstream.stop)
+		upstream.stop = nil
+	}
+
+	if upstream.conn != nil {
+		upstream.conn.Close()
+	}
+}
+
+func (upstream *Upstream) connect(stop chan struct{}) error {
+	var (
+		catchUp       bool
+		catchUpDate   time.Time
+		catchUpOffset uint
+		pinSent       bool
+	)
+
+	u, err := upstream.getWebsocketUrl()
+	if err != nil {
+		return err
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 15 * time.Second,
+		Proxy:            http.ProxyFromEnvironment,
+	}
+
+	conn, _, err := dialer.Dial(u, nil)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	upstream.mutex.Lock()
+	select {
+	case <-stop:
+		upstream.mutex.Unlock()
+		return nil
+	default:
+		upstream.conn = conn
+	}
+	upstream.mutex.Unlock()
+
+	defer func() {
+		upstream.mutex.Lock()
+		upstream.conn = nil
+		upstream.mutex.Unlock()
+	}()
+
+	upstream.controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("upstream: connected to %s", upstream.Url))
+
+	send := func(message *Message) error {
+		b, err := message.ToJson()
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(upstreamWriteWait))
+		return conn.WriteMessage(websocket.TextMessage, b)
+	}
+
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(upstreamPongWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(upstreamWriteWait))
+	})
+
+	if err = send(&Message{Command: MessageCommandVersion}); err != nil {
+		return err
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(upstreamPongWait))
+
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		message := &Message{}
+		if err = message.FromJson(b); err != nil {
+			continue
+		}
+
+		switch message.Command {
+		case MessageCommandVersion:
+			if len(upstream.AccessCode) > 0 {
+				pinSent = true
+				err = send(&Message{Command: MessageCommandPin, Payload: base64.StdEncoding.EncodeToString([]byte(upstream.AccessCode))})
+			} else {
+				err = send(&Message{Command: MessageCommandConfig})
+			}
+
+		case MessageCommandPin:
+			if pinSent {
+				return errors.New("access code rejected")
+			} else if len(upstream.AccessCode) > 0 {
+				pinSent = true
+				err = send(&Message{Command: MessageCommandPin, Payload: base64.StdEncoding.EncodeToString([]byte(upstream.AccessCode))})
+			} else {
+				return errors.New("access code required")
+			}
+
+		case MessageCommandExpired:
+			return errors.New("access code expired")
+
+		case MessageCommandMax:
+			return errors.New("too many concurrent connections")
+
+		case MessageCommandConfig:
+			switch v := message.Payload.(type) {
+			case map[string]any:
+				upstream.parseConfig(v)
+			}
+
+			if err = send(&Message{Command: MessageCommandLivefeedMap, Payload: upstream.getLivefeedMap()}); err != nil {
+				return err
+			}
+
+			if !catchUp && !upstream.lastDateTime.IsZero() {
+				catchUp = true
+				catchUpDate = upstream.lastDateTime
+				err = send(upstream.getListCallMessage(catchUpDate, catchUpOffset))
+			}
+
+		case MessageCommandListCall:
+			switch v := message.Payload.(type) {
+			case map[string]any:
+				ids, count := upstream.parseListCall(v, catchUpDate)
+
+				for _, id := range ids {
+					if err = send(&Message{Command: MessageCommandCall, Payload: id}); err != nil {
+						break
+					}
+				}
+
+				if err == nil && count == upstreamListLimit {
+					catchUpOffset += upstreamListLimit
+					err = send(upstream.getListCallMessage(catchUpDate, catchUpOffset))
+				}
+			}
+
+		case MessageCommandCall:
+			switch v := message.Payload.(type) {
+			case map[string]any:
+				upstream.ingest(v)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (upstream *Upstream) getListCallMessage(date time.Time, offset uint) *Message {
+	return &Message{Command: MessageCommandListCall, Payload: map[string]any{
+		"date":   date.Format(time.RFC3339),
+		"limit":  upstreamListLimit,
+		"offset": offset,
+		"sort":   1,
+	}}
+}
+
+func (upstream *Upstream) getLivefeedMap() map[string]map[string]bool {
+	lfm := map[string]map[string]bool{}
+
+	for systemId, system := range upstream.systemsMap {
+		switch talkgroups := system["talkgroups"].(type) {
+		case map[uint]map[string]any:
+			for talkgroupId := range talkgroups {
+				enabled := upstream.HasAccess(&Call{System: systemId, Talkgroup: talkgroupId})
+
+				sId := strconv.FormatUint(uint64(systemId), 10)
+				if lfm[sId] == nil {
+					lfm[sId] = map[string]bool{}
+				}
+				lfm[sId][strconv.FormatUint(uint64(talkgroupId), 10)] = enabled
+			}
+		}
+	}
+
+	return lfm
+}
+
+func (upstream *Upstream) getWebsocketUrl() (string, error) {
+	u, err := url.Parse(upstream.Url)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported url scheme %s", u.Scheme)
+	}
+
+	return u.String(), nil
+}
+
+func (upstream *Upstream) ingest(m map[string]any) {
+	var call = NewCall()
+
+	call.IngestSource = fmt.Sprintf("%s:%v:%s", IngestSourceUpstream, upstream.Id, upstream.Url)
+
+	controller := upstream.controller
+
+	switch v := m["id"].(type) {
+	case float64:
+		id := uint(v)
+
+		if upstream.seen[id] {
+			return
+		}
+
+		upstream.seen[id] = true
+		upstream.seenList = append(upstream.seenList, id)
+
+		if len(upstream.seenList) > upstreamSeenSize {
+			delete(upstream.seen, upstream.seenList[0])
+			upstream.seenList = upstream.seenList[1:]
+		}
+	}
+
+	switch v := m["audio"].(type) {
+	case map[string]any:
+		switch data := v["data"].(type) {
+		case []any:
+			call.Audio = make([]byte, 0, len(data))
+			for _, f := range data {
+				switch b := f.(type) {
+				case float64:
+					call.Audio = append(call.Audio, byte(b))
+				}
+			}
+		}
+	}
+
+	switch v := m["audioName"].(type) {
+	case string:
+		call.AudioName = v
+	}
+
+	switch v := m["audioType"].(type) {
+	case string:
+		call.AudioType = v
+	}
+
+	switch v := m["dateTime"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			call.DateTime = t.UTC()
+		}
+	}
+
+	switch v := m["frequencies"].(type) {
+	case []any:
+		frequencies := []map[string]any{}
+		for _, f := range v {
+			switch f := f.(type) {
+			case map[string]any:
+				frequencies = append(frequencies, f)
+			}
+		}
+		call.Frequencies = frequencies
+	}
+
+	switch v := m["frequency"].(type) {
+	case float64:
+		call.Frequency = uint(v)
+	}
+
+	switch v := m["patches"].(type) {
+	case []any:
+		patches := []uint{}
+		for _, f := range v {
+			switch patch := f.(type) {
+			case float64:
+				patches = append(patches, uint(patch))
+			}
+		}
+		call.Patches = patches
+	}
+
+	switch v := m["provenance"].(type) {
+	case []any:
+		provenance := []string{}
+		for _, f := range v {
+			switch s := f.(type) {
+			case string:
+				if s == controller.Options.serverId {
+					controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("upstream: %s call loop detected, call ignored", upstream.Url))
+					return
+				}
+				provenance = append(provenance, s)
+			}
+		}
+		call.Provenance = provenance
+	}
+
+	switch v := m["source"].(type) {
+	case float64:
+		call.Source = uint(v)
+	}
+
+	switch v := m["sources"].(type) {
+	case []any:
+		sources := []map[string]any{}
+		for _, f := range v {
+			switch s := f.(type) {
+			case map[string]any:
+				sources = append(sources, s)
+			}
+		}
+		call.Sources = sources
+	}
+
+	switch v := m["system"].(type) {
+	case float64:
+		call.System = uint(v)
+	}
+
+	switch v := m["talkgroup"].(type) {
+	case float64:
+		call.Talkgroup = uint(v)
+	}
+
+	upstream.mutex.Lock()
+	if call.DateTime.After(upstream.lastDateTime) {
+		upstream.lastDateTime = call.DateTime
+	}
+	upstream.mutex.Unlock()
+
+	if !upstream.HasAccess(call) {
+		return
+	}
+
+	if system, ok := upstream.systemsMap[call.System]; ok {
+		call.systemLabel = system["label"]
+
+		switch talkgroups := system["talkgroups"].(type) {
+		case map[uint]map[string]any:
+			if talkgroup, ok := talkgroups[call.Talkgroup]; ok {
+				call.talkgroupGroup = talkgroup["group"]
+				call.talkgroupLabel = talkgroup["label"]
+				call.talkgroupName = talkgroup["name"]
+				call.talkgroupTag = talkgroup["tag"]
+			}
+		}
+	}
+
+	controller.Ingest <- remapCall(upstream.remaps, call)
+}
+
+func (upstream *Upstream) parseConfig(m map[string]any) {
+	systemsMap := map[uint]map[string]any{}
+
+	switch v := m["systems"].(type) {
+	case []any:
+		for _, f := range v {
+			switch s := f.(type) {
+			case map[string]any:
+				id, ok := s["id"].(float64)
+				if !ok {
+					continue
+				}
+
+				talkgroups := map[uint]map[string]any{}
+
+				switch v := s["talkgroups"].(type) {
+				case []any:
+					for _, f := range v {
+						switch t := f.(type) {
+						case map[string]any:
+							if id, ok := t["id"].(float64); ok {
+								talkgroups[uint(id)] = t
+							}
+						}
+					}
+				}
+
+				systemsMap[uint(id)] = map[string]any{
+					"label":      s["label"],
+					"talkgroups": talkgroups,
+				}
+			}
+		}
+	}
+
+	upstream.systemsMap = systemsMap
+}
+
+// parseListCall returns the ids of the calls to fetch from a catch up page. live calls received meanwhile
+// move lastDateTime forward, so results are compared to the date the catch up started from and
+// the calls already received are left out by the seen set.
+func (upstream *Upstream) parseListCall(m map[string]any, since time.Time) (ids []uint, count int) {
+	var results []any
+
+	ids = []uint{}
+
+	switch v := m["results"].(type) {
+	case []any:
+		results = v
+	}
+
+	for _, f := range results {
+		switch r := f.(type) {
+		case map[string]any:
+			id, ok := r["id"].(float64)
+			if !ok || upstream.seen[uint(id)] {
+				continue
+			}
+
+			if s, ok := r["dateTime"].(string); ok {
+				if t, err := time.Parse(time.RFC3339, s); err != nil || !t.After(since) {
+					continue
+				}
+			}
+
+			system, _ := r["system"].(float64)
+			talkgroup, _ := r["talkgroup"].(float64)
+
+			if upstream.HasAccess(&Call{System: uint(system), Talkgroup: uint(talkgroup)}) {
+				ids = append(ids, uint(id))
+			}
+		}
+	}
+
+	return ids, len(results)
+}
+
+func (upstream *Upstream) run(stop chan struct{}) {
+	backoff := upstreamBackoffMin
+
+	for {
+		started := time.Now()
+
+		err := upstream.connect(stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if time.Since(started) > upstreamBackoffMax {
+			backoff = upstreamBackoffMin
+		}
+
+		if err != nil {
+			upstream.controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("upstream: %s %v, reconnecting in %v", upstream.Url, err, backoff))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > upstreamBackoffMax {
+			backoff = upstreamBackoffMax
+		}
+	}
+}
+
+type Upstreams struct {
+	List  []*Upstream
+	mutex sync.Mutex
+}
+
+func NewUpstreams() *Upstreams {
+	return &Upstreams{
+		List:  []*Upstream{},
+		mutex: sync.Mutex{},
+	}
+}
+
+func (upstreams *Upstreams) FromMap(f []any) *Upstreams {
+	upstreams.mutex.Lock()
+	defer upstreams.mutex.Unlock()
+
+	lastDateTimes := upstreams.stop()
+
+	upstreams.List = []*Upstream{}
+
+	for _, r := range f {
+		switch m := r.(type) {
+		case map[string]any:
+			upstream := NewUpstream().FromMap(m)
+			upstream.lastDateTime = lastDateTimes[upstream.Id]
+			upstreams.List = append(upstreams.List, upstream)
+		}
+	}
+
+	return upstreams
+}
+
+func (upstreams *Upstreams) Read(db *Database) error {
+	var (
+		err     error
+		id      sql.NullFloat64
+		order   sql.NullFloat64
+		remaps  sql.NullString
+		rows    *sql.Rows
+		systems string
+	)
+
+	upstreams.mutex.Lock()
+	defer upstreams.mutex.Unlock()
+
+	lastDateTimes := upstreams.stop()
+
+	upstreams.List = []*Upstream{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("upstreams.read: %v", err)
+	}
+
+	q := "select `_id`, `accessCode`, `disabled`, `order`, `remaps`, `systems`, `url` from `rdioScannerUpstreams`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id, accessCode, disabled, \"order\", remaps, systems, url from rdioScannerUpstreams"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		upstream := NewUpstream()
+
+		if err = rows.Scan(&id, &upstream.AccessCode, &upstream.Disabled, &order, &remaps, &systems, &upstream.Url); err != nil {
+			break
+		}
+
+		if id.Valid && id.Float64 > 0 {
+			upstream.Id = uint(id.Float64)
+		}
+
+		if order.Valid && order.Float64 > 0 {
+			upstream.Order = uint(order.Float64)
+		}
+
+		if remaps.Valid && len(remaps.String) > 0 {
+			var f []any
+			if err := json.Unmarshal([]byte(remaps.String), &f); err == nil {
+				for _, r := range f {
+					switch m := r.(type) {
+					case map[string]any:
+						upstream.Remaps = append(upstream.Remaps, (&Remap{}).FromMap(m))
+					}
+				}
+			}
+		}
+
+		if err = json.Unmarshal([]byte(systems), &upstream.Systems); err != nil {
+			upstream.Systems = []any{}
+		}
+
+		if len(upstream.Url) == 0 {
+			continue
+		}
+
+		upstream.lastDateTime = lastDateTimes[upstream.Id]
+
+		upstreams.List = append(upstreams.List, upstream)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (upstreams *Upstreams) Start(controller *Controller) {
+	upstreams.mutex.Lock()
+	defer upstreams.mutex.Unlock()
+
+	for _, upstream := range upstreams.List {
+		if err := upstream.Start(controller); err != nil {
+			controller.Logs.LogEvent(LogLevelError, fmt.Sprintf("upstreams.start: %s", err.Error()))
+		}
+	}
+}
+
+func (upstreams *Upstreams) Stop() {
+	upstreams.mutex.Lock()
+	defer upstreams.mutex.Unlock()
+
+	upstreams.stop()
+}
+
+func (upstreams *Upstreams) Write(db *Database) error {
+	var (
+		count   uint
+		err     error
+		remaps  []byte
+		rows    *sql.Rows
+		rowIds  = []uint{}
+		systems any
+	)
+
+	upstreams.mutex.Lock()
+	defer upstreams.mutex.Unlock()
+
+	formatError := func(err error) error {
+		return fmt.Errorf("upstreams.write: %v", err)
+	}
+
+	q := "select `_id` from `rdioScannerUpstreams`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id from rdioScannerUpstreams"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var rowId uint
+		if err = rows.Scan(&rowId); err != nil {
+			break
+		}
+		remove := true
+		for _, upstream := range upstreams.List {
+			if upstream.Id == nil || upstream.Id == rowId {
+				remove = false
+				break
+			}
+		}
+		if remove {
+			rowIds = append(rowIds, rowId)
+		}
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	if len(rowIds) > 0 {
+		if b, err := json.Marshal(rowIds); err == nil {
+			s := string(b)
+			s = strings.ReplaceAll(s, "[", "(")
+			s = strings.ReplaceAll(s, "]", ")")
+			q := fmt.Sprintf("delete from `rdioScannerUpstreams` where `_id` in %v", s)
+			if db.Config.DbType == DbTypePostgresql {
+				q = fmt.Sprintf("delete from rdioScannerUpstreams where _id in %v", s)
+			}
+			if _, err = db.Sql.Exec(q); err != nil {
+				return formatError(err)
+			}
+		}
+	}
+
+	for _, upstream := range upstreams.List {
+		switch upstream.Systems {
+		case "*":
+			systems = `"*"`
+		default:
+			systems = upstream.Systems
+		}
+
+		if remaps, err = json.Marshal(upstream.Remaps); err != nil {
+			break
+		}
+
+		q := "select count(*) from `rdioScannerUpstreams` where `_id` = ?"
+		if db.Config.DbType == DbTypePostgresql {
+			q = "select count(*) from rdioScannerUpstreams where _id = $1"
+		}
+		if err = db.Sql.QueryRow(q, upstream.Id).Scan(&count); err != nil {
+			break
+		}
+
+		if count == 0 {
+			q := "insert into `rdioScannerUpstreams` (`_id`, `accessCode`, `disabled`, `order`, `remaps`, `systems`, `url`) values (?, ?, ?, ?, ?, ?, ?)"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "insert into rdioScannerUpstreams (_id, accessCode, disabled, \"order\", remaps, systems, url) values ($1, $2, $3, $4, $5, $6, $7)"
+			}
+			if _, err = db.Sql.Exec(q, upstream.Id, upstream.AccessCode, upstream.Disabled, upstream.Order, string(remaps), systems, upstream.Url); err != nil {
+				break
+			}
+
+		} else {
+			q := "update `rdioScannerUpstreams` set `_id` = ?, `accessCode` = ?, `disabled` = ?, `order` = ?, `remaps` = ?, `systems` = ?, `url` = ? where `_id` = ?"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "update rdioScannerUpstreams set _id = $1, accessCode = $2, disabled = $3, \"order\" = $4, remaps = $5, systems = $6, url = $7 where _id = $8"
+			}
+			if _, err = db.Sql.Exec(q, upstream.Id, upstream.AccessCode, upstream.Disabled, upstream.Order, string(remaps), systems, upstream.Url, upstream.Id); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (upstreams *Upstreams) stop() map[any]time.Time {
+	lastDateTimes := map[any]time.Time{}
+
+	for _, upstream := range upstreams.List {
+		upstream.Stop()
+
+		upstream.mutex.Lock()
+		lastDateTimes[upstream.Id] = upstream.lastDateTime
+		upstream.mutex.Unlock()
+	}
+
+	return lastDateTimes
+}
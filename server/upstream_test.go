@@ -0,0 +1,358 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var testUpstreamEpoch = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// testUpstreamServer stands in for a remote rdio-scanner, it answers the listener protocol from an
+// archive of calls where each call is identified by its single audio byte
+type testUpstreamServer struct {
+	*httptest.Server
+	accessCode string
+	archive    map[uint]time.Time
+	closeAfter int
+	live       []uint
+	mutex      sync.Mutex
+	received   [][]*Message
+}
+
+func newTestUpstreamServer(t *testing.T, accessCode string, archive map[uint]time.Time) *testUpstreamServer {
+	t.Helper()
+
+	server := &testUpstreamServer{accessCode: accessCode, archive: archive}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		server.mutex.Lock()
+		index := len(server.received)
+		server.received = append(server.received, []*Message{})
+		server.mutex.Unlock()
+
+		send := func(message *Message) {
+			if b, err := message.ToJson(); err == nil {
+				conn.WriteMessage(websocket.TextMessage, b)
+			}
+		}
+
+		sent := 0
+
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			message := &Message{}
+			if err = message.FromJson(b); err != nil {
+				continue
+			}
+
+			server.mutex.Lock()
+			server.received[index] = append(server.received[index], message)
+			server.mutex.Unlock()
+
+			switch message.Command {
+			case MessageCommandVersion:
+				send(&Message{Command: MessageCommandVersion, Payload: map[string]any{"version": "test"}})
+
+			case MessageCommandPin:
+				if code, _ := base64.StdEncoding.DecodeString(message.Payload.(string)); string(code) == server.accessCode {
+					send(server.config())
+				} else {
+					send(&Message{Command: MessageCommandPin})
+				}
+
+			case MessageCommandConfig:
+				if len(server.accessCode) > 0 {
+					send(&Message{Command: MessageCommandPin})
+				} else {
+					send(server.config())
+				}
+
+			case MessageCommandLivefeedMap:
+				// live calls go out as soon as the livefeed is set, before any catch up reply
+				server.mutex.Lock()
+				live, closeAfter := server.live, server.closeAfter
+				server.live = nil
+				server.mutex.Unlock()
+
+				for _, id := range live {
+					send(server.call(id))
+					if sent++; sent == closeAfter {
+						return
+					}
+				}
+
+			case MessageCommandListCall:
+				send(server.listCall(message.Payload.(map[string]any)))
+
+			case MessageCommandCall:
+				send(server.call(uint(message.Payload.(float64))))
+			}
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (server *testUpstreamServer) config() *Message {
+	return &Message{Command: MessageCommandConfig, Payload: map[string]any{
+		"systems": []any{map[string]any{
+			"id":         1,
+			"label":      "Remote System",
+			"talkgroups": []any{map[string]any{"id": 150, "label": "TG 150", "name": "Talkgroup 150"}},
+		}},
+	}}
+}
+
+func (server *testUpstreamServer) call(id uint) *Message {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return &Message{Command: MessageCommandCall, Payload: map[string]any{
+		"audio":     map[string]any{"data": []any{id}},
+		"dateTime":  server.archive[id].Format(time.RFC3339),
+		"id":        id,
+		"system":    1,
+		"talkgroup": 150,
+	}}
+}
+
+func (server *testUpstreamServer) listCall(m map[string]any) *Message {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	date, _ := time.Parse(time.RFC3339, m["date"].(string))
+
+	ids := []uint{}
+	for id, t := range server.archive {
+		if !t.Before(date) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i int, j int) bool { return server.archive[ids[i]].Before(server.archive[ids[j]]) })
+
+	results := []any{}
+	for _, id := range ids {
+		results = append(results, map[string]any{"id": id, "dateTime": server.archive[id].Format(time.RFC3339), "system": 1, "talkgroup": 150})
+	}
+
+	return &Message{Command: MessageCommandListCall, Payload: map[string]any{"count": len(results), "results": results}}
+}
+
+func (server *testUpstreamServer) commands(connection int) []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	commands := []string{}
+	if connection < len(server.received) {
+		for _, message := range server.received[connection] {
+			commands = append(commands, message.Command.(string))
+		}
+	}
+
+	return commands
+}
+
+func (server *testUpstreamServer) connections() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return len(server.received)
+}
+
+func newTestUpstream(t *testing.T, controller *Controller, server *testUpstreamServer, accessCode string) *Upstream {
+	t.Helper()
+
+	upstream := NewUpstream()
+	upstream.AccessCode = accessCode
+	upstream.Id = uint(1)
+	upstream.Systems = "*"
+	upstream.Url = server.URL
+	upstream.controller = controller
+
+	return upstream
+}
+
+// readTestIngest returns the ids of the calls that reached the ingest channel
+func readTestIngest(t *testing.T, controller *Controller, n int) []uint {
+	t.Helper()
+
+	ids := []uint{}
+
+	for len(ids) < n {
+		select {
+		case call := <-controller.Ingest:
+			ids = append(ids, uint(call.Audio[0]))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got calls %v, want %d", ids, n)
+		}
+	}
+
+	select {
+	case call := <-controller.Ingest:
+		t.Fatalf("unexpected call %d after %v", call.Audio[0], ids)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	return ids
+}
+
+func TestUpstreamHandshake(t *testing.T) {
+	controller := newTestController(t)
+
+	server := newTestUpstreamServer(t, "", map[uint]time.Time{1: testUpstreamEpoch})
+	server.live = []uint{1}
+	server.closeAfter = 1
+
+	upstream := newTestUpstream(t, controller, server, "")
+
+	if err := upstream.connect(make(chan struct{})); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	if got := strings.Join(server.commands(0), ","); got != "VER,CFG,LFM" {
+		t.Errorf("sent %s", got)
+	}
+
+	select {
+	case call := <-controller.Ingest:
+		if call.System != 1 || call.Talkgroup != 150 || call.systemLabel != "Remote System" || call.talkgroupLabel != "TG 150" {
+			t.Errorf("unexpected call %+v", call)
+		}
+		if source, _ := call.IngestSource.(string); !strings.HasPrefix(source, IngestSourceUpstream) {
+			t.Errorf("ingest source is %s", call.IngestSource)
+		}
+	default:
+		t.Fatal("live call not ingested")
+	}
+}
+
+func TestUpstreamAccessCode(t *testing.T) {
+	controller := newTestController(t)
+
+	server := newTestUpstreamServer(t, "1234", map[uint]time.Time{1: testUpstreamEpoch})
+	server.live = []uint{1}
+	server.closeAfter = 1
+
+	if err := newTestUpstream(t, controller, server, "").connect(make(chan struct{})); err == nil || err.Error() != "access code required" {
+		t.Errorf("without a code got %v", err)
+	}
+
+	if err := newTestUpstream(t, controller, server, "4321").connect(make(chan struct{})); err == nil || err.Error() != "access code rejected" {
+		t.Errorf("with a wrong code got %v", err)
+	}
+
+	newTestUpstream(t, controller, server, "1234").connect(make(chan struct{}))
+
+	if got := strings.Join(server.commands(2), ","); got != "VER,PIN,LFM" {
+		t.Errorf("sent %s", got)
+	}
+
+	if ids := readTestIngest(t, controller, 1); ids[0] != 1 {
+		t.Errorf("got calls %v", ids)
+	}
+}
+
+func TestUpstreamCatchUp(t *testing.T) {
+	controller := newTestController(t)
+
+	server := newTestUpstreamServer(t, "", map[uint]time.Time{
+		1: testUpstreamEpoch.Add(-time.Minute),
+		2: testUpstreamEpoch,
+		3: testUpstreamEpoch.Add(time.Minute),
+		4: testUpstreamEpoch.Add(2 * time.Minute),
+		5: testUpstreamEpoch.Add(10 * time.Minute),
+	})
+
+	// a live call arrives before the catch up reply and moves lastDateTime past the missed calls
+	server.live = []uint{5}
+
+	upstream := newTestUpstream(t, controller, server, "")
+	upstream.lastDateTime = testUpstreamEpoch
+	upstream.seen[2] = true
+
+	go upstream.connect(make(chan struct{}))
+	t.Cleanup(func() { upstream.Stop() })
+
+	ids := readTestIngest(t, controller, 3)
+
+	sort.Slice(ids, func(i int, j int) bool { return ids[i] < ids[j] })
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 4 || ids[2] != 5 {
+		t.Errorf("got calls %v, want [3 4 5]", ids)
+	}
+
+	if commands := server.commands(0); len(commands) < 4 || commands[3] != MessageCommandListCall {
+		t.Errorf("sent %v", commands)
+	}
+}
+
+func TestUpstreamReconnect(t *testing.T) {
+	controller := newTestController(t)
+
+	server := newTestUpstreamServer(t, "", map[uint]time.Time{1: testUpstreamEpoch})
+	server.live = []uint{1}
+	server.closeAfter = 1
+
+	upstream := newTestUpstream(t, controller, server, "")
+
+	if err := upstream.Start(controller); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { upstream.Stop() })
+
+	if ids := readTestIngest(t, controller, 1); ids[0] != 1 {
+		t.Fatalf("got calls %v", ids)
+	}
+
+	// a call made while the upstream was disconnected
+	server.mutex.Lock()
+	server.archive[2] = testUpstreamEpoch.Add(time.Minute)
+	server.closeAfter = 0
+	server.mutex.Unlock()
+
+	if ids := readTestIngest(t, controller, 1); ids[0] != 2 {
+		t.Fatalf("got calls %v after reconnect", ids)
+	}
+
+	if n := server.connections(); n != 2 {
+		t.Errorf("got %d connections, want 2", n)
+	}
+
+	if commands := server.commands(1); strings.Join(commands, ",") != "VER,CFG,LFM,LCL,CAL" {
+		t.Errorf("sent %v after reconnect", commands)
+	}
+}
@@ -0,0 +1,719 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	webPushQueueSize        = 256
+	webPushRecordSize       = 4096
+	webPushSubject          = "https://github.com/USA-RedDragon/rdio-scanner"
+	webPushSubscriptionsMax = 10
+	webPushTimeout          = 10 * time.Second
+	webPushTtl              = 60
+	webPushWorkers          = 4
+)
+
+// address ranges a push service never lives in, see rfc 6890
+var webPushReservedNets = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}()
+
+type WebPushFilter struct {
+	System    uint   `json:"system,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Talkgroup uint   `json:"talkgroup,omitempty"`
+}
+
+func (filter *WebPushFilter) FromMap(m map[string]any) *WebPushFilter {
+	switch v := m["system"].(type) {
+	case float64:
+		filter.System = uint(v)
+	}
+
+	switch v := m["tag"].(type) {
+	case string:
+		filter.Tag = v
+	}
+
+	switch v := m["talkgroup"].(type) {
+	case float64:
+		filter.Talkgroup = uint(v)
+	}
+
+	return filter
+}
+
+func (filter *WebPushFilter) Match(call *Call) bool {
+	if filter.System > 0 && filter.System != call.System {
+		return false
+	}
+
+	if filter.Talkgroup > 0 && filter.Talkgroup != call.Talkgroup {
+		return false
+	}
+
+	if len(filter.Tag) > 0 && filter.Tag != call.talkgroupTag {
+		return false
+	}
+
+	return true
+}
+
+type WebPushSubscription struct {
+	Id         uint            `json:"_id"`
+	Auth       string          `json:"auth"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Endpoint   string          `json:"endpoint"`
+	Expiration any             `json:"expiration"`
+	Filters    []WebPushFilter `json:"filters"`
+	Ident      string          `json:"ident"`
+	Ip         string          `json:"ip"`
+	P256dh     string          `json:"p256dh"`
+}
+
+func (subscription *WebPushSubscription) HasExpired() bool {
+	switch v := subscription.Expiration.(type) {
+	case time.Time:
+		return v.Before(time.Now())
+	}
+	return false
+}
+
+func (subscription *WebPushSubscription) Match(call *Call) bool {
+	for _, filter := range subscription.Filters {
+		if filter.Match(call) {
+			return true
+		}
+	}
+	return false
+}
+
+func (subscription *WebPushSubscription) isOwnedBy(auth string) bool {
+	return subtle.ConstantTimeCompare([]byte(trimBase64(subscription.Auth)), []byte(trimBase64(auth))) == 1
+}
+
+// identified listeners are capped by ident, anonymous ones by ip
+func (subscription *WebPushSubscription) isSameOwner(other *WebPushSubscription) bool {
+	if len(other.Ident) > 0 {
+		return subscription.Ident == other.Ident
+	}
+
+	return len(subscription.Ident) == 0 && subscription.Ip == other.Ip
+}
+
+type WebPush struct {
+	Controller    *Controller
+	Subscriptions []*WebPushSubscription
+	client        *http.Client
+	jobs          chan *webPushJob
+	mutex         sync.Mutex
+	privateKey    *ecdsa.PrivateKey
+	publicKey     string
+}
+
+type webPushJob struct {
+	call         *Call
+	payload      []byte
+	subscription *WebPushSubscription
+}
+
+func NewWebPush(controller *Controller) *WebPush {
+	webPush := &WebPush{
+		Controller:    controller,
+		Subscriptions: []*WebPushSubscription{},
+		jobs:          make(chan *webPushJob, webPushQueueSize),
+		mutex:         sync.Mutex{},
+	}
+
+	// endpoints are checked again once resolved, a name can point anywhere by the time we dial it
+	dialer := &net.Dialer{
+		Timeout: webPushTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if webPush.allowPrivate() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicIp(net.ParseIP(host)) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	webPush.client = &http.Client{Timeout: webPushTimeout, Transport: transport}
+
+	for i := 0; i < webPushWorkers; i++ {
+		go func() {
+			for job := range webPush.jobs {
+				webPush.deliver(job)
+			}
+		}()
+	}
+
+	return webPush
+}
+
+func NewVapidPrivateKey() (string, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func (webPush *WebPush) GetPublicKey() string {
+	return webPush.publicKey
+}
+
+func (webPush *WebPush) Init(vapidPrivateKey string) error {
+	formatError := func(err error) error {
+		return fmt.Errorf("webpush.init: %v", err)
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(vapidPrivateKey)
+	if err != nil {
+		return formatError(err)
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return formatError(err)
+	}
+
+	pub := key.PublicKey().Bytes()
+
+	webPush.privateKey = &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:65]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}
+	webPush.publicKey = base64.RawURLEncoding.EncodeToString(pub)
+
+	return nil
+}
+
+func (webPush *WebPush) Prune(db *Database) error {
+	for _, subscription := range webPush.getSubscriptions() {
+		if subscription.HasExpired() {
+			if err := webPush.remove(subscription.Endpoint, db); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (webPush *WebPush) Read(db *Database) error {
+	var (
+		err  error
+		rows *sql.Rows
+	)
+
+	webPush.mutex.Lock()
+	defer webPush.mutex.Unlock()
+
+	webPush.Subscriptions = []*WebPushSubscription{}
+
+	formatError := func(err error) error {
+		return fmt.Errorf("webpush.read: %v", err)
+	}
+
+	q := "select `_id`, `auth`, `createdAt`, `endpoint`, `expiration`, `filters`, `ident`, `ip`, `p256dh` from `rdioScannerPushSubscriptions`"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "select _id, auth, createdAt, endpoint, expiration, filters, ident, ip, p256dh from rdioScannerPushSubscriptions"
+	}
+	if rows, err = db.Sql.Query(q); err != nil {
+		return formatError(err)
+	}
+
+	for rows.Next() {
+		var (
+			createdAt  any
+			expiration any
+			filters    string
+		)
+
+		subscription := &WebPushSubscription{}
+
+		if err = rows.Scan(&subscription.Id, &subscription.Auth, &createdAt, &subscription.Endpoint, &expiration, &filters, &subscription.Ident, &subscription.Ip, &subscription.P256dh); err != nil {
+			break
+		}
+
+		if t, err := db.ParseDateTime(createdAt); err == nil {
+			subscription.CreatedAt = t
+		}
+
+		if t, err := db.ParseDateTime(expiration); err == nil {
+			subscription.Expiration = t
+		}
+
+		if err := json.Unmarshal([]byte(filters), &subscription.Filters); err != nil {
+			subscription.Filters = []WebPushFilter{}
+		}
+
+		webPush.Subscriptions = append(webPush.Subscriptions, subscription)
+	}
+
+	rows.Close()
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	return nil
+}
+
+func (webPush *WebPush) Send(call *Call) {
+	subscriptions := webPush.getSubscriptions()
+	if len(subscriptions) == 0 || webPush.privateKey == nil {
+		return
+	}
+
+	controller := webPush.Controller
+
+	// the call is shared with the other emitters
+	c := *call
+	controller.populateCall(&c)
+
+	// push services cap payloads at 4kb, keep it to what a notification needs
+	payload, err := json.Marshal(map[string]any{
+		"id":             c.Id,
+		"dateTime":       c.DateTime.Format(time.RFC3339),
+		"source":         c.Source,
+		"system":         c.System,
+		"systemLabel":    c.systemLabel,
+		"talkgroup":      c.Talkgroup,
+		"talkgroupLabel": c.talkgroupLabel,
+		"talkgroupName":  c.talkgroupName,
+		"talkgroupTag":   c.talkgroupTag,
+	})
+	if err != nil {
+		return
+	}
+
+	restricted := controller.Accesses.IsRestricted()
+
+	for _, subscription := range subscriptions {
+		if subscription.HasExpired() || !subscription.Match(&c) {
+			continue
+		}
+
+		if restricted {
+			access, ok := controller.Accesses.GetAccessByIdent(subscription.Ident)
+			if !ok || access.HasExpired() || !access.HasAccess(&c) {
+				continue
+			}
+		}
+
+		// a slow push service must not hold up the calls behind it, drop rather than pile up goroutines
+		select {
+		case webPush.jobs <- &webPushJob{call: call, payload: payload, subscription: subscription}:
+		default:
+			controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("webpush: system=%v talkgroup=%v to ident %s dropped, queue is full", call.System, call.Talkgroup, subscription.Ident))
+		}
+	}
+}
+
+func (webPush *WebPush) Subscribe(subscription *WebPushSubscription, db *Database) error {
+	formatError := func(err error) error {
+		return fmt.Errorf("webpush.subscribe: %v", err)
+	}
+
+	if err := webPush.checkEndpoint(subscription.Endpoint); err != nil {
+		return formatError(err)
+	}
+
+	if b, err := base64.RawURLEncoding.DecodeString(trimBase64(subscription.Auth)); err != nil || len(b) != 16 {
+		return formatError(errors.New("invalid auth secret"))
+	}
+
+	if b, err := base64.RawURLEncoding.DecodeString(trimBase64(subscription.P256dh)); err != nil || len(b) != 65 {
+		return formatError(errors.New("invalid p256dh key"))
+	}
+
+	filters, err := json.Marshal(subscription.Filters)
+	if err != nil {
+		return formatError(err)
+	}
+
+	webPush.mutex.Lock()
+	defer webPush.mutex.Unlock()
+
+	count := 0
+
+	for _, s := range webPush.Subscriptions {
+		if s.Endpoint == subscription.Endpoint {
+			// only the browser holding the subscription knows its auth secret
+			if !s.isOwnedBy(subscription.Auth) {
+				return formatError(errors.New("endpoint already subscribed"))
+			}
+
+			q := "update `rdioScannerPushSubscriptions` set `expiration` = ?, `filters` = ?, `ident` = ?, `ip` = ?, `p256dh` = ? where `_id` = ?"
+			if db.Config.DbType == DbTypePostgresql {
+				q = "update rdioScannerPushSubscriptions set expiration = $1, filters = $2, ident = $3, ip = $4, p256dh = $5 where _id = $6"
+			}
+			if _, err = db.Sql.Exec(q, subscription.Expiration, string(filters), subscription.Ident, subscription.Ip, subscription.P256dh, s.Id); err != nil {
+				return formatError(err)
+			}
+
+			s.Expiration = subscription.Expiration
+			s.Filters = subscription.Filters
+			s.Ident = subscription.Ident
+			s.Ip = subscription.Ip
+			s.P256dh = subscription.P256dh
+
+			return nil
+		}
+
+		if s.isSameOwner(subscription) {
+			count++
+		}
+	}
+
+	if count >= webPushSubscriptionsMax {
+		return formatError(fmt.Errorf("too many subscriptions, at most %d are allowed", webPushSubscriptionsMax))
+	}
+
+	subscription.CreatedAt = time.Now().UTC()
+
+	q := "insert into `rdioScannerPushSubscriptions` (`auth`, `createdAt`, `endpoint`, `expiration`, `filters`, `ident`, `ip`, `p256dh`) values (?, ?, ?, ?, ?, ?, ?, ?)"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "insert into rdioScannerPushSubscriptions (auth, createdAt, endpoint, expiration, filters, ident, ip, p256dh) values ($1, $2, $3, $4, $5, $6, $7, $8) returning _id"
+		err = db.Sql.QueryRow(q, subscription.Auth, subscription.CreatedAt, subscription.Endpoint, subscription.Expiration, string(filters), subscription.Ident, subscription.Ip, subscription.P256dh).Scan(&subscription.Id)
+
+	} else if res, e := db.Sql.Exec(q, subscription.Auth, subscription.CreatedAt, subscription.Endpoint, subscription.Expiration, string(filters), subscription.Ident, subscription.Ip, subscription.P256dh); e == nil {
+		if id, e := res.LastInsertId(); e == nil {
+			subscription.Id = uint(id)
+		}
+
+	} else {
+		err = e
+	}
+
+	if err != nil {
+		return formatError(err)
+	}
+
+	webPush.Subscriptions = append(webPush.Subscriptions, subscription)
+
+	return nil
+}
+
+func (webPush *WebPush) Unsubscribe(endpoint string, auth string, db *Database) error {
+	for _, s := range webPush.getSubscriptions() {
+		if s.Endpoint == endpoint {
+			if !s.isOwnedBy(auth) {
+				return errors.New("webpush.unsubscribe: not the owner of this subscription")
+			}
+
+			return webPush.remove(endpoint, db)
+		}
+	}
+
+	return nil
+}
+
+func (webPush *WebPush) allowPrivate() bool {
+	if webPush.Controller == nil || webPush.Controller.Options == nil {
+		return false
+	}
+
+	return webPush.Controller.Options.WebPushAllowPrivate
+}
+
+// push services are public https hosts, anything else needs the admin to opt in
+func (webPush *WebPush) checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Hostname()) == 0 || (u.Scheme != "https" && u.Scheme != "http") {
+		return errors.New("invalid endpoint")
+	}
+
+	if webPush.allowPrivate() {
+		return nil
+	}
+
+	if u.Scheme != "https" {
+		return errors.New("endpoint must use https")
+	}
+
+	if port := u.Port(); len(port) > 0 && port != "443" {
+		return errors.New("endpoint must use the default https port")
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIp(ip) {
+			return errors.New("endpoint must be a public host")
+		}
+
+	} else if !strings.Contains(host, ".") || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("endpoint must be a public host")
+	}
+
+	return nil
+}
+
+func (webPush *WebPush) deliver(job *webPushJob) {
+	controller := webPush.Controller
+
+	status, err := webPush.send(job.subscription, job.payload)
+
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		controller.Logs.LogEvent(LogLevelInfo, fmt.Sprintf("webpush: subscription for %s is gone, removed", job.subscription.Endpoint))
+
+		if err := webPush.remove(job.subscription.Endpoint, controller.Database); err != nil {
+			controller.Logs.LogEvent(LogLevelError, err.Error())
+		}
+
+	case err != nil:
+		controller.Logs.LogEvent(LogLevelWarn, fmt.Sprintf("webpush: system=%v talkgroup=%v to ident %s %v", job.call.System, job.call.Talkgroup, job.subscription.Ident, err))
+	}
+}
+
+// aes128gcm content encoding as specified by rfc 8291
+func (webPush *WebPush) encrypt(subscription *WebPushSubscription, plaintext []byte) ([]byte, error) {
+	auth, err := base64.RawURLEncoding.DecodeString(trimBase64(subscription.Auth))
+	if err != nil {
+		return nil, err
+	}
+
+	p256dh, err := base64.RawURLEncoding.DecodeString(trimBase64(subscription.P256dh))
+	if err != nil {
+		return nil, err
+	}
+
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, err
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	info := append(append([]byte("WebPush: info\x00"), p256dh...), asPublic...)
+	ikm := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, auth, info), ikm); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+
+	cek := make([]byte, 16)
+	if _, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 21+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	// a single record, terminated by the last record padding delimiter
+	return gcm.Seal(header, nonce, append(plaintext, 0x02), nil), nil
+}
+
+func (webPush *WebPush) getAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": webPushSubject,
+	}).SignedString(webPush.privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, webPush.publicKey), nil
+}
+
+func (webPush *WebPush) getSubscriptions() []*WebPushSubscription {
+	webPush.mutex.Lock()
+	defer webPush.mutex.Unlock()
+
+	return append([]*WebPushSubscription{}, webPush.Subscriptions...)
+}
+
+func (webPush *WebPush) remove(endpoint string, db *Database) error {
+	webPush.mutex.Lock()
+	defer webPush.mutex.Unlock()
+
+	q := "delete from `rdioScannerPushSubscriptions` where `endpoint` = ?"
+	if db.Config.DbType == DbTypePostgresql {
+		q = "delete from rdioScannerPushSubscriptions where endpoint = $1"
+	}
+	if _, err := db.Sql.Exec(q, endpoint); err != nil {
+		return fmt.Errorf("webpush.remove: %v", err)
+	}
+
+	for i, s := range webPush.Subscriptions {
+		if s.Endpoint == endpoint {
+			webPush.Subscriptions = append(webPush.Subscriptions[:i], webPush.Subscriptions[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (webPush *WebPush) send(subscription *WebPushSubscription, payload []byte) (int, error) {
+	body, err := webPush.encrypt(subscription, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	authorization, err := webPush.getAuthorization(subscription.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprintf("%d", webPushTtl))
+	req.Header.Set("Urgency", "high")
+
+	res, err := webPush.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("push service responded %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+func isPublicIp(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, n := range webPushReservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// browsers may hand out padded or standard base64 keys
+func trimBase64(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch c {
+		case '+':
+			b[i] = '-'
+		case '/':
+			b[i] = '_'
+		}
+	}
+	return string(bytes.TrimRight(b, "="))
+}
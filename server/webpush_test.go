@@ -0,0 +1,351 @@
+// Copyright (C) 2019-2022 Chrystian Huot <chrystian.huot@saubeo.solutions>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+type testPushKeys struct {
+	auth    []byte
+	private *ecdh.PrivateKey
+}
+
+func newTestPushKeys(t *testing.T) *testPushKeys {
+	t.Helper()
+
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testPushKeys{auth: auth, private: private}
+}
+
+func (keys *testPushKeys) subscription(endpoint string) *WebPushSubscription {
+	return &WebPushSubscription{
+		Auth:     base64.RawURLEncoding.EncodeToString(keys.auth),
+		Endpoint: endpoint,
+		Filters:  []WebPushFilter{{System: 1}},
+		P256dh:   base64.RawURLEncoding.EncodeToString(keys.private.PublicKey().Bytes()),
+	}
+}
+
+// what the browser does with an aes128gcm push message, rfc 8291
+func (keys *testPushKeys) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, fmt.Errorf("short body")
+	}
+
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idlen := int(body[20])
+	if rs != webPushRecordSize || len(body) < 21+idlen {
+		return nil, fmt.Errorf("bad header")
+	}
+
+	asPublic := body[21 : 21+idlen]
+
+	public, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := keys.private.ECDH(public)
+	if err != nil {
+		return nil, err
+	}
+
+	info := append(append([]byte("WebPush: info\x00"), keys.private.PublicKey().Bytes()...), asPublic...)
+	ikm := make([]byte, 32)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, keys.auth, info), ikm); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+
+	cek := make([]byte, 16)
+	if _, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(plaintext, []byte{0x02}), nil
+}
+
+func newTestWebPush(t *testing.T, allowPrivate bool) *WebPush {
+	t.Helper()
+
+	controller := newTestController(t)
+	controller.Options.WebPushAllowPrivate = allowPrivate
+
+	key, err := NewVapidPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := controller.WebPush.Init(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return controller.WebPush
+}
+
+func waitTestWebPush(t *testing.T, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the push service")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebPushSendDelivers(t *testing.T) {
+	webPush := newTestWebPush(t, true)
+	keys := newTestPushKeys(t)
+
+	received := make(chan []byte, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || !strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	if err := webPush.Subscribe(keys.subscription(server.URL+"/push/1"), webPush.Controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	call := newTestDownstreamCall()
+	call.Id = 42
+
+	webPush.Send(call)
+
+	select {
+	case body := <-received:
+		plaintext, err := keys.decrypt(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := map[string]any{}
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if payload["id"] != float64(42) || payload["talkgroup"] != float64(150) {
+			t.Fatalf("unexpected payload %v", payload)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("push never reached the push service")
+	}
+}
+
+func TestWebPushSendRemovesGoneSubscriptions(t *testing.T) {
+	webPush := newTestWebPush(t, true)
+	keys := newTestPushKeys(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	if err := webPush.Subscribe(keys.subscription(server.URL), webPush.Controller.Database); err != nil {
+		t.Fatal(err)
+	}
+
+	webPush.Send(newTestDownstreamCall())
+
+	waitTestWebPush(t, func() bool { return len(webPush.getSubscriptions()) == 0 })
+}
+
+func TestWebPushSubscribeRejectsPrivateEndpoints(t *testing.T) {
+	webPush := newTestWebPush(t, false)
+	keys := newTestPushKeys(t)
+
+	for _, endpoint := range []string{
+		"http://push.example.com/1",
+		"https://127.0.0.1/1",
+		"https://10.1.2.3/1",
+		"https://169.254.169.254/latest",
+		"https://[::1]/1",
+		"https://100.64.0.1/1",
+		"https://localhost/1",
+		"https://intranet/1",
+		"https://push.example.com:8443/1",
+		"ftp://push.example.com/1",
+	} {
+		if err := webPush.Subscribe(keys.subscription(endpoint), webPush.Controller.Database); err == nil {
+			t.Errorf("%s was accepted", endpoint)
+		}
+	}
+
+	if err := webPush.Subscribe(keys.subscription("https://push.example.com/1"), webPush.Controller.Database); err != nil {
+		t.Fatalf("public endpoint rejected, %v", err)
+	}
+}
+
+func TestWebPushSendRefusesPrivateAddresses(t *testing.T) {
+	webPush := newTestWebPush(t, false)
+	keys := newTestPushKeys(t)
+
+	var hits atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// as if a public name had been rebound to loopback after it was subscribed
+	if _, err := webPush.send(keys.subscription(server.URL), []byte("{}")); err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Fatalf("expected the dial to be refused, got %v", err)
+	}
+
+	if hits.Load() != 0 {
+		t.Fatal("push service was reached")
+	}
+}
+
+func TestWebPushSubscribeCap(t *testing.T) {
+	webPush := newTestWebPush(t, false)
+	db := webPush.Controller.Database
+
+	subscribe := func(n int, ident string, ip string) error {
+		subscription := newTestPushKeys(t).subscription(fmt.Sprintf("https://push.example.com/%s/%s/%d", ident, ip, n))
+		subscription.Ident = ident
+		subscription.Ip = ip
+		return webPush.Subscribe(subscription, db)
+	}
+
+	for i := 0; i < webPushSubscriptionsMax; i++ {
+		if err := subscribe(i, "", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := subscribe(i, "alice", fmt.Sprintf("192.0.2.%d", i+10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := subscribe(webPushSubscriptionsMax, "", "192.0.2.1"); err == nil {
+		t.Fatal("anonymous cap not enforced")
+	}
+
+	if err := subscribe(webPushSubscriptionsMax, "alice", "192.0.2.99"); err == nil {
+		t.Fatal("ident cap not enforced")
+	}
+
+	if err := subscribe(0, "", "192.0.2.2"); err != nil {
+		t.Fatalf("other ip refused, %v", err)
+	}
+}
+
+func TestWebPushOwnership(t *testing.T) {
+	webPush := newTestWebPush(t, false)
+	db := webPush.Controller.Database
+
+	owner := newTestPushKeys(t)
+	other := newTestPushKeys(t)
+
+	endpoint := "https://push.example.com/owned"
+
+	if err := webPush.Subscribe(owner.subscription(endpoint), db); err != nil {
+		t.Fatal(err)
+	}
+
+	hijack := other.subscription(endpoint)
+	hijack.Filters = []WebPushFilter{{System: 2}}
+	if err := webPush.Subscribe(hijack, db); err == nil {
+		t.Fatal("subscription updated without its auth secret")
+	}
+
+	if err := webPush.Unsubscribe(endpoint, hijack.Auth, db); err == nil {
+		t.Fatal("unsubscribed without the auth secret")
+	}
+
+	if subscriptions := webPush.getSubscriptions(); len(subscriptions) != 1 || subscriptions[0].Filters[0].System != 1 {
+		t.Fatalf("subscription was altered, %v", subscriptions)
+	}
+
+	if err := webPush.Unsubscribe(endpoint, owner.subscription(endpoint).Auth, db); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webPush.getSubscriptions()) != 0 {
+		t.Fatal("owner could not unsubscribe")
+	}
+
+	if err := webPush.Read(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webPush.getSubscriptions()) != 0 {
+		t.Fatal("subscription still in the database")
+	}
+}